@@ -1,5 +1,7 @@
 package config
 
+import "time"
+
 // GinConfig 定义 Gin 配置文件的结构体
 type GinConfig struct {
 	Host string `mapstructure:"host"`
@@ -28,13 +30,48 @@ type RedisConfig struct {
 
 // JWTConfig 定义 jwt 配置文件结构体
 type JWTConfig struct {
-	SigningKey string `mapstructure:"signing_key"`
+	SigningKey    string        `mapstructure:"signing_key"`
+	AccessExpire  time.Duration `mapstructure:"access_expire"`  // access token 有效期
+	RefreshExpire time.Duration `mapstructure:"refresh_expire"` // refresh token 有效期
+}
+
+// StorageConfig 定义对象存储配置文件结构体
+type StorageConfig struct {
+	Type      string `mapstructure:"type"`       // local 或 s3
+	BaseURL   string `mapstructure:"base_url"`   // 文件访问地址前缀，local 为空时使用请求的 Host
+	Root      string `mapstructure:"root"`       // local 存储根目录
+	Endpoint  string `mapstructure:"endpoint"`   // s3 服务地址
+	Region    string `mapstructure:"region"`     // s3 区域
+	AccessKey string `mapstructure:"access_key"` // s3 access key
+	SecretKey string `mapstructure:"secret_key"` // s3 secret key
+	Bucket    string `mapstructure:"bucket"`     // s3 bucket
+	UseSSL    bool   `mapstructure:"use_ssl"`    // s3 是否使用 https
+	// s3 是否允许匿名读取 bucket，为 false 且未配置 base_url 时返回带签名的临时地址
+	PublicRead bool          `mapstructure:"public_read"`
+	URLExpire  time.Duration `mapstructure:"url_expire"` // s3 签名地址有效期
+}
+
+// RenditionConfig 定义一种 HLS 清晰度
+type RenditionConfig struct {
+	Name         string `mapstructure:"name"`          // 清晰度名称，同时作为子目录名
+	Height       int    `mapstructure:"height"`        // 视频高度，宽度按比例缩放
+	VideoBitrate int    `mapstructure:"video_bitrate"` // 视频码率（kbps）
+	AudioBitrate int    `mapstructure:"audio_bitrate"` // 音频码率（kbps）
+}
+
+// TranscodeConfig 定义转码配置文件结构体
+type TranscodeConfig struct {
+	Workers     int               `mapstructure:"workers"`      // 并发转码数
+	SegmentTime int               `mapstructure:"segment_time"` // HLS 分片时长（秒）
+	Renditions  []RenditionConfig `mapstructure:"renditions"`   // 输出的清晰度，按从低到高排列
 }
 
 // System 定义项目配置文件结构体
 type System struct {
-	GinConfig   *GinConfig   `mapstructure:"gin"`
-	MySQLConfig *MySQLConfig `mapstructure:"mysql"`
-	RedisConfig *RedisConfig `mapstructure:"redis"`
-	JWTConfig   *JWTConfig   `mapstructure:"jwt"`
+	GinConfig       *GinConfig       `mapstructure:"gin"`
+	MySQLConfig     *MySQLConfig     `mapstructure:"mysql"`
+	RedisConfig     *RedisConfig     `mapstructure:"redis"`
+	JWTConfig       *JWTConfig       `mapstructure:"jwt"`
+	StorageConfig   *StorageConfig   `mapstructure:"storage"`
+	TranscodeConfig *TranscodeConfig `mapstructure:"transcode"`
 }
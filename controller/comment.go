@@ -18,6 +18,7 @@ type CommentActionRequest struct {
 	ActionType  uint   `form:"action_type" json:"action_type"`
 	CommentText string `form:"comment_text" json:"comment_text"`
 	CommentID   uint64 `form:"comment_id" json:"comment_id"`
+	ParentID    uint64 `form:"parent_id" json:"parent_id"` // 回复的评论 ID，为空时评论视频
 }
 
 type CommentActionResponse struct {
@@ -25,17 +26,47 @@ type CommentActionResponse struct {
 	Comment Comment `json:"comment,omitempty"`
 }
 
+// 评论列表排序方式
+const (
+	CommentSortTypeTime = "time" // 按发布时间倒序
+	CommentSortTypeHot  = "hot"  // 按点赞数倒序
+)
+
+// CommentLikeActionRequest 评论点赞操作的请求
+type CommentLikeActionRequest struct {
+	Token      string `form:"token" json:"token"`
+	CommentID  uint64 `form:"comment_id" json:"comment_id"`
+	ActionType uint   `form:"action_type" json:"action_type"`
+}
+
 // CommentListRequest 评论列表的请求
 type CommentListRequest struct {
-	UserID  uint64 `form:"user_id" json:"user_id"`
-	Token   string `form:"token" json:"token"`
-	VideoID uint64 `form:"video_id" json:"video_id"`
+	UserID   uint64 `form:"user_id" json:"user_id"`
+	Token    string `form:"token" json:"token"`
+	VideoID  uint64 `form:"video_id" json:"video_id"`
+	SortType string `form:"sort_type" json:"sort_type"`
 }
 
 // CommentListResponse 评论列表的响应
 type CommentListResponse struct {
 	Response
 	CommentList []Comment `json:"comment_list,omitempty"`
+	NextCursor  string    `json:"next_cursor,omitempty"` // 下一页的游标，分页且还有更多时返回
+	HasMore     bool      `json:"has_more"`
+}
+
+// CommentReplyListRequest 回复列表的请求
+type CommentReplyListRequest struct {
+	Token       string `form:"token" json:"token"`
+	CommentID   uint64 `form:"comment_id" json:"comment_id"`
+	LastReplyID uint64 `form:"last_reply_id" json:"last_reply_id"` // 上一页最后一条回复的 ID，为空时从第一条开始
+}
+
+// CommentReplyListResponse 回复列表的响应
+type CommentReplyListResponse struct {
+	Response
+	ReplyList []Comment `json:"reply_list,omitempty"`
+	HasMore   bool      `json:"has_more"`
 }
 
 // CommentAction 评论操作接口
@@ -78,9 +109,13 @@ func CommentAction(c *gin.Context) {
 			VideoID:   r.VideoID,
 			UserID:    r.UserID,
 			Content:   r.CommentText,
+			ParentID:  r.ParentID,
 		}
 		// 评论失败
-		if err = service.AddComment(&commentModel); err != nil {
+		if err = service.AddComment(&commentModel); err == service.ErrUserBlocked {
+			c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: err.Error()})
+			return
+		} else if err != nil {
 			c.JSON(500, Response{StatusCode: 1, StatusMsg: "comment failed"})
 			return
 		}
@@ -96,22 +131,34 @@ func CommentAction(c *gin.Context) {
 			c.JSON(500, Response{StatusCode: 1, StatusMsg: err.Error()})
 			return
 		}
+		// 评论中提及的用户
+		mentionMap, err := service.GetCommentMentionMap([]uint64{commentModel.CommentID})
+		if err != nil {
+			c.JSON(500, Response{StatusCode: 1, StatusMsg: err.Error()})
+			return
+		}
 		// 返回JSON
 		c.JSON(http.StatusOK, CommentActionResponse{
 			Response: Response{StatusCode: 0},
 			Comment: Comment{
 				Id: int64(commentModel.CommentID),
 				User: User{
-					Id:             userModel.UserID,
-					Name:           userModel.Name,
-					FollowCount:    userModel.FollowCount,
-					FollowerCount:  userModel.FollowerCount,
-					TotalFavorited: userModel.TotalFavorited,
-					FavoriteCount:  userModel.FavoriteCount,
-					IsFollow:       isFollow,
+					Id:              userModel.UserID,
+					Name:            userModel.Name,
+					FollowCount:     userModel.FollowCount,
+					FollowerCount:   userModel.FollowerCount,
+					TotalFavorited:  userModel.TotalFavorited,
+					FavoriteCount:   userModel.FavoriteCount,
+					IsFollow:        isFollow,
+					Avatar:          avatarURL(c, userModel.Avatar),
+					BackgroundImage: backgroundURL(c, userModel.BackgroundImage),
+					Signature:       userModel.Signature,
 				},
-				Content:    commentModel.Content,
-				CreateDate: commentModel.CreatedAt.Format("2006-01-02 15:04"),
+				Content:       commentModel.Content,
+				CreateDate:    commentModel.CreatedAt.Format("2006-01-02 15:04"),
+				ParentId:      commentModel.ParentID,
+				ReplyToUserId: commentModel.ReplyToUserID,
+				Mentions:      newMentionJsonList(mentionMap[commentModel.CommentID]),
 			},
 		})
 		return
@@ -125,6 +172,36 @@ func CommentAction(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{StatusCode: 0})
 }
 
+// CommentLikeAction 评论点赞操作接口
+func CommentLikeAction(c *gin.Context) {
+	// 参数绑定
+	var r CommentLikeActionRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "bind error"})
+		return
+	}
+	// 判断 action_type 是否正确
+	if r.ActionType != 1 && r.ActionType != 2 {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "action type error"})
+		return
+	}
+	// 获取当前用户的 ID
+	userID := c.GetUint64("UserID")
+	// 点赞操作
+	var err error
+	if r.ActionType == 1 {
+		err = service.AddCommentLike(userID, r.CommentID)
+	} else {
+		err = service.CancelCommentLike(userID, r.CommentID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "server error"})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, Response{StatusCode: 0})
+}
+
 // CommentList 评论列表接口
 func CommentList(c *gin.Context) {
 	// 参数绑定
@@ -134,25 +211,92 @@ func CommentList(c *gin.Context) {
 		return
 	}
 
+	// 判断 sort_type 是否正确
+	var byLike bool
+	switch r.SortType {
+	case "", CommentSortTypeTime:
+		byLike = false
+	case CommentSortTypeHot:
+		byLike = true
+	default:
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter sort_type is wrong"})
+		return
+	}
+
+	// 解析分页参数
+	cursor, limit, _, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
 	var commentModelList []model.Comment
 	var userModelList []model.User
-	// 获取评论列表以及对应的作者
-	if err := service.GetCommentListAndUserListRedis(r.VideoID, &commentModelList, &userModelList); err != nil {
+	// 获取评论列表以及对应的作者，未分页时 limit 为 0 返回全部
+	nextCursor, err := service.GetCommentListAndUserListRedis(r.VideoID, byLike, cursor, limit, &commentModelList, &userModelList)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
 		return
 	}
 
 	var (
-		isFollowList []bool
-		isLogged     = false // 用户是否传入了合法有效的token（是否登录）
-		isFollow     bool
-		err          error
+		isLogged = false // 用户是否传入了合法有效的token（是否登录）
+		userID   uint64
 	)
-
-	var userID uint64
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
+		if err == nil {
+			// token合法
+			userID = claims.UserID
+			isLogged = true
+		}
+	}
+
+	// 过滤当前用户拉黑或屏蔽的用户发表的评论
+	commentModelList, userModelList, err = service.FilterCommentsByViewer(userID, commentModelList, userModelList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	commentJsonList, err := buildCommentJsonList(c, userID, isLogged, commentModelList, userModelList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, CommentListResponse{
+		Response:    Response{StatusCode: 0},
+		CommentList: commentJsonList,
+		NextCursor:  util.EncodeCursor(nextCursor),
+		HasMore:     nextCursor != nil,
+	})
+}
+
+// CommentReplyList 回复列表接口，按时间正序分页返回一条顶层评论的回复
+func CommentReplyList(c *gin.Context) {
+	// 参数绑定
+	var r CommentReplyListRequest
+	if err := c.ShouldBind(&r); err != nil || r.CommentID == 0 {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+		return
+	}
+
+	var replyModelList []model.Comment
+	var userModelList []model.User
+	// 获取回复列表以及对应的作者
+	hasMore, err := service.GetReplyListAndUserListRedis(r.CommentID, r.LastReplyID, global.REPLY_NUM, &replyModelList, &userModelList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
+	var (
+		isLogged = false // 用户是否传入了合法有效的token（是否登录）
+		userID   uint64
+	)
+	// 判断传入的token是否合法，用户是否存在
+	if r.Token != "" {
+		claims, err := service.ParseAccessToken(r.Token)
 		if err == nil {
 			// token合法
 			userID = claims.UserID
@@ -160,20 +304,68 @@ func CommentList(c *gin.Context) {
 		}
 	}
 
+	// 过滤当前用户拉黑或屏蔽的用户发表的评论
+	replyModelList, userModelList, err = service.FilterCommentsByViewer(userID, replyModelList, userModelList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	replyJsonList, err := buildCommentJsonList(c, userID, isLogged, replyModelList, userModelList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, CommentReplyListResponse{
+		Response:  Response{StatusCode: 0},
+		ReplyList: replyJsonList,
+		HasMore:   hasMore,
+	})
+}
+
+// buildCommentJsonList 填充评论列表返回的 JSON，登录时附带是否关注评论作者
+func buildCommentJsonList(c *gin.Context, userID uint64, isLogged bool, commentModelList []model.Comment, userModelList []model.User) ([]Comment, error) {
+	var (
+		isFollowList []bool
+		isFriendList []bool
+		isLikeList   []bool
+		isFollow     bool
+		isFriend     bool
+		isLike       bool
+		err          error
+	)
+
 	if isLogged {
-		// 当用户登录时 一次性获取用户是否点赞了列表中的视频以及是否关注了评论的作者
-		authorIDList := make([]uint64, len(commentModelList))
+		// 当用户登录时 一次性获取用户是否点赞了列表中的评论以及是否关注了评论的作者
+		authorIDList := make([]uint64, len(userModelList))
 		for i, user_ := range userModelList {
 			authorIDList[i] = user_.UserID
 		}
+		commentIDList := make([]uint64, len(commentModelList))
+		for i, comment := range commentModelList {
+			commentIDList[i] = comment.CommentID
+		}
 		// 批量判断用户是否关注评论的作者
-		isFollowList, err = service.GetFollowStatusList(userID, authorIDList)
+		isFollowList, isFriendList, err = service.GetFollowAndFriendStatusList(userID, authorIDList)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
-			return
+			return nil, err
+		}
+		// 批量判断用户是否点赞评论
+		isLikeList, err = service.GetCommentLikeStatusList(userID, commentIDList)
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	// 批量获取评论中提及的用户
+	commentIDList := make([]uint64, len(commentModelList))
+	for i, comment := range commentModelList {
+		commentIDList[i] = comment.CommentID
+	}
+	mentionMap, err := service.GetCommentMentionMap(commentIDList)
+	if err != nil {
+		return nil, err
+	}
+
 	var (
 		commentJsonList = make([]Comment, 0, len(commentModelList))
 		commentJson     Comment
@@ -184,9 +376,13 @@ func CommentList(c *gin.Context) {
 	for i, comment := range commentModelList {
 		// 未登录时默认为未关注未点赞
 		isFollow = false
+		isFriend = false
+		isLike = false
 		if isLogged {
-			// 当用户登录时，判断是否关注当前作者
+			// 当用户登录时，判断是否关注当前作者以及是否点赞当前评论
 			isFollow = isFollowList[i]
+			isFriend = isFriendList[i]
+			isLike = isLikeList[i]
 		}
 		user = userModelList[i]
 		userJson.Id = user.UserID
@@ -196,16 +392,23 @@ func CommentList(c *gin.Context) {
 		userJson.TotalFavorited = user.TotalFavorited
 		userJson.FavoriteCount = user.FavoriteCount
 		userJson.IsFollow = isFollow
+		userJson.IsFriend = isFriend
+		userJson.Avatar = avatarURL(c, user.Avatar)
+		userJson.BackgroundImage = backgroundURL(c, user.BackgroundImage)
+		userJson.Signature = user.Signature
 
 		commentJson.Id = int64(comment.CommentID)
 		commentJson.User = userJson
 		commentJson.Content = comment.Content
-		commentJson.CreateDate = comment.CreatedAt.Format("2023-02-01 05:21")
+		commentJson.CreateDate = comment.CreatedAt.Format("2006-01-02 15:04")
+		commentJson.ParentId = comment.ParentID
+		commentJson.ReplyToUserId = comment.ReplyToUserID
+		commentJson.ReplyCount = comment.ReplyCount
+		commentJson.LikeCount = comment.LikeCount
+		commentJson.IsLiked = isLike
+		commentJson.Mentions = newMentionJsonList(mentionMap[comment.CommentID])
 
 		commentJsonList = append(commentJsonList, commentJson)
 	}
-	c.JSON(http.StatusOK, CommentListResponse{
-		Response:    Response{StatusCode: 0},
-		CommentList: commentJsonList,
-	})
+	return commentJsonList, nil
 }
@@ -1,52 +1,177 @@
 package controller
 
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
 type Response struct {
 	StatusCode int32  `json:"status_code"`
 	StatusMsg  string `json:"status_msg,omitempty"`
 }
 
 type Video struct {
-	Id            uint64 `json:"id"`
-	Author        User   `json:"author"`
-	PlayUrl       string `json:"play_url"`
-	CoverUrl      string `json:"cover_url"`
-	FavoriteCount int64  `json:"favorite_count"`
-	CommentCount  int64  `json:"comment_count"`
-	IsFavorite    bool   `json:"is_favorite"`
-	Title         string `json:"title"`
+	Id            uint64    `json:"id"`
+	Author        User      `json:"author"`
+	PlayUrl       string    `json:"play_url"`
+	CoverUrl      string    `json:"cover_url"`
+	FavoriteCount int64     `json:"favorite_count"`
+	CommentCount  int64     `json:"comment_count"`
+	IsFavorite    bool      `json:"is_favorite"`
+	Title         string    `json:"title"`
+	Mentions      []Mention `json:"mentions,omitempty"` // 标题中提及的用户
+}
+
+// Mention 视频标题或评论中提及的用户，客户端据此将 @用户名 渲染为链接
+type Mention struct {
+	UserId uint64 `json:"user_id"`
+	Name   string `json:"name"`
 }
 
 type Comment struct {
-	Id         int64  `json:"id,omitempty"`
-	User       User   `json:"user"`
-	Content    string `json:"content,omitempty"`
-	CreateDate string `json:"create_date,omitempty"`
+	Id            int64     `json:"id,omitempty"`
+	User          User      `json:"user"`
+	Content       string    `json:"content,omitempty"`
+	CreateDate    string    `json:"create_date,omitempty"`
+	ParentId      uint64    `json:"parent_id,omitempty"`        // 所属顶层评论 ID，顶层评论不返回
+	ReplyToUserId uint64    `json:"reply_to_user_id,omitempty"` // 被回复的用户 ID
+	ReplyCount    int64     `json:"reply_count"`                // 回复数目
+	LikeCount     int64     `json:"like_count"`                 // 点赞数目
+	IsLiked       bool      `json:"is_liked"`                   // 当前用户是否点赞
+	Mentions      []Mention `json:"mentions,omitempty"`         // 评论中提及的用户
 }
 
 // User 用户信息响应结构体
 type User struct {
-	Id             uint64 `json:"id"`
-	Name           string `json:"name"`
-	FollowCount    int64  `json:"follow_count,omitempty"`
-	FollowerCount  int64  `json:"follower_count,omitempty"`
-	TotalFavorited int64  `json:"total_favorited,omitempty"`
-	FavoriteCount  int64  `json:"favorite_count,omitempty"`
-	IsFollow       bool   `json:"is_follow"`
+	Id              uint64 `json:"id"`
+	Name            string `json:"name"`
+	FollowCount     int64  `json:"follow_count,omitempty"`
+	FollowerCount   int64  `json:"follower_count,omitempty"`
+	TotalFavorited  int64  `json:"total_favorited,omitempty"`
+	FavoriteCount   int64  `json:"favorite_count,omitempty"`
+	IsFollow        bool   `json:"is_follow"`
+	IsFriend        bool   `json:"is_friend"`            // 是否互相关注
+	IsPrivate       bool   `json:"is_private,omitempty"` // 是否为私密账号
+	Avatar          string `json:"avatar"`               // 头像地址
+	BackgroundImage string `json:"background_image"`     // 个人主页背景图地址
+	Signature       string `json:"signature"`            // 个人简介
 }
 
+// Message 私信响应结构体
 type Message struct {
-	Id         int64  `json:"id,omitempty"`
-	Content    string `json:"content,omitempty"`
-	CreateTime string `json:"create_time,omitempty"`
+	Id         uint64 `json:"id"`
+	ToUserId   uint64 `json:"to_user_id"`
+	FromUserId uint64 `json:"from_user_id"`
+	Content    string `json:"content"`
+	CreateTime int64  `json:"create_time"` // 毫秒时间戳
 }
 
+// MessageSendEvent 客户端通过 WebSocket 发送的消息
 type MessageSendEvent struct {
 	UserId     int64  `json:"user_id,omitempty"`
 	ToUserId   int64  `json:"to_user_id,omitempty"`
 	MsgContent string `json:"msg_content,omitempty"`
 }
 
+// MessagePushEvent 服务端通过 WebSocket 推送的消息
 type MessagePushEvent struct {
+	MsgId      uint64 `json:"msg_id,omitempty"`
 	FromUserId int64  `json:"user_id,omitempty"`
+	ToUserId   int64  `json:"to_user_id,omitempty"`
 	MsgContent string `json:"msg_content,omitempty"`
+	CreateTime int64  `json:"create_time,omitempty"` // 毫秒时间戳
+}
+
+// mediaURL 生成媒体文件的访问地址，存储返回相对地址时使用当前请求的 Host 补全
+func mediaURL(c *gin.Context, key string) string {
+	url := global.STORAGE.URL(key)
+	if strings.HasPrefix(url, "/") {
+		return "http://" + c.Request.Host + url
+	}
+	return url
+}
+
+// newMentionJsonList 生成提及列表返回的 JSON
+func newMentionJsonList(mentionList []model.Mention) []Mention {
+	if len(mentionList) == 0 {
+		return nil
+	}
+	mentionJsonList := make([]Mention, len(mentionList))
+	for i, mention := range mentionList {
+		mentionJsonList[i] = Mention{UserId: mention.UserID, Name: mention.UserName}
+	}
+	return mentionJsonList
+}
+
+// avatarURL 生成头像的访问地址，未设置头像时返回空字符串
+func avatarURL(c *gin.Context, name string) string {
+	if name == "" {
+		return ""
+	}
+	return mediaURL(c, storage.AvatarKey(name))
+}
+
+// backgroundURL 生成背景图的访问地址，未设置背景图时返回空字符串
+func backgroundURL(c *gin.Context, name string) string {
+	if name == "" {
+		return ""
+	}
+	return mediaURL(c, storage.BackgroundKey(name))
+}
+
+// parsePageParams 解析分页参数 cursor 和 limit，两者都未传时不分页
+func parsePageParams(c *gin.Context) (cursor *util.Cursor, limit int, paged bool, err error) {
+	cursorString := c.Query("cursor")
+	limitString := c.Query("limit")
+	if cursorString == "" && limitString == "" {
+		return nil, 0, false, nil
+	}
+	limit = global.PAGE_SIZE
+	if limitString != "" {
+		if limit, err = strconv.Atoi(limitString); err != nil || limit <= 0 {
+			return nil, 0, false, errors.New("parameter limit is wrong")
+		}
+		if limit > global.MAX_PAGE_SIZE {
+			limit = global.MAX_PAGE_SIZE
+		}
+	}
+	if cursorString != "" {
+		if cursor, err = util.DecodeCursor(cursorString); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	return cursor, limit, true, nil
+}
+
+// parseOptionalToken 解析可选的 token，token 合法时返回当前用户的 ID
+func parseOptionalToken(c *gin.Context) (uint64, bool) {
+	if token := c.Query("token"); token != "" {
+		if claims, err := service.ParseAccessToken(token); err == nil {
+			return claims.UserID, true
+		}
+	}
+	return 0, false
+}
+
+// checkUserContentVisible 私密账号的内容只对本人和已关注的用户可见，不可见时直接返回错误响应
+func checkUserContentVisible(c *gin.Context, ownerID uint64) bool {
+	viewerID, _ := parseOptionalToken(c)
+	visible, err := service.CanViewUserContent(viewerID, ownerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return false
+	}
+	if !visible {
+		c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: "account is private"})
+		return false
+	}
+	return true
 }
@@ -1,7 +1,9 @@
 package controller
 
 import (
+	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/Ljkkun/GreenBeanMiners/util"
 	"github.com/gin-gonic/gin"
 	"net/http"
@@ -66,23 +68,46 @@ func FavoriteList(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
 		return
 	}
+	// 私密账号只对本人和已关注的用户可见
+	if !checkUserContentVisible(c, r.UserID) {
+		return
+	}
 	// 判断是否登录
 	var isLogin bool
 	var userID uint64
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
 		if err == nil {
 			userID = claims.UserID
 			isLogin = true
 		}
 	}
+	// 解析分页参数
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
 	// 获取用户的点赞列表
-	videoModelList, err := service.GetFavoriteListByUserID(r.UserID)
+	var (
+		videoModelList []model.Video
+		nextCursor     *util.Cursor
+	)
+	if paged {
+		videoModelList, nextCursor, err = service.GetFavoriteListByUserIDPage(r.UserID, cursor, limit)
+	} else {
+		videoModelList, err = service.GetFavoriteListByUserID(r.UserID)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get favorite list failed"})
 		return
 	}
+	// 过滤当前用户无权查看的视频
+	if videoModelList, err = service.FilterVisibleVideoList(userID, videoModelList); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get favorite list failed"})
+		return
+	}
 	// 产生相应结构体
 	celebrityIDList := make([]uint64, len(videoModelList))
 	videoIDList := make([]uint64, len(videoModelList))
@@ -93,19 +118,22 @@ func FavoriteList(c *gin.Context) {
 			continue
 		}
 		var author = User{
-			Id:             each.AuthorID,
-			Name:           userModel.Name,
-			FollowCount:    userModel.FollowCount,
-			FollowerCount:  userModel.FollowerCount,
-			TotalFavorited: userModel.TotalFavorited,
-			FavoriteCount:  userModel.FavoriteCount,
+			Id:              each.AuthorID,
+			Name:            userModel.Name,
+			FollowCount:     userModel.FollowCount,
+			FollowerCount:   userModel.FollowerCount,
+			TotalFavorited:  userModel.TotalFavorited,
+			FavoriteCount:   userModel.FavoriteCount,
+			Avatar:          avatarURL(c, userModel.Avatar),
+			BackgroundImage: backgroundURL(c, userModel.BackgroundImage),
+			Signature:       userModel.Signature,
 		}
 		var isFavorite bool // 是否对视频点赞
 		video := Video{
 			Id:            each.VideoID,
 			Author:        author,
-			PlayUrl:       "http://" + c.Request.Host + "/public/video/" + each.PlayName,
-			CoverUrl:      "http://" + c.Request.Host + "/public/cover/" + each.CoverName,
+			PlayUrl:       mediaURL(c, storage.VideoKey(each.PlayName)),
+			CoverUrl:      mediaURL(c, storage.CoverKey(each.CoverName)),
 			FavoriteCount: each.FavoriteCount,
 			CommentCount:  each.CommentCount,
 			IsFavorite:    isFavorite,
@@ -114,13 +142,23 @@ func FavoriteList(c *gin.Context) {
 		celebrityIDList[idx] = each.AuthorID
 		videoIDList[idx] = each.VideoID
 	}
+	// 批量获取标题中提及的用户
+	mentionMap, err := service.GetTitleMentionMap(videoIDList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get favorite list failed"})
+		return
+	}
+	for i := range videoList {
+		videoList[i].Mentions = newMentionJsonList(mentionMap[videoList[i].Id])
+	}
 	// 批量处理
 	if isLogin {
 		// 登录时，获取是否关注以及是否点赞，否则总是为false
-		isFollowList, _ := service.GetFollowStatusList(userID, celebrityIDList)
+		isFollowList, isFriendList, _ := service.GetFollowAndFriendStatusList(userID, celebrityIDList)
 		isFavoriteList, _ := service.GetFavoriteStatusList(userID, videoIDList)
 		for i := 0; i < len(videoModelList); i++ {
 			videoList[i].Author.IsFollow = isFollowList[i]
+			videoList[i].Author.IsFriend = isFriendList[i]
 			videoList[i].IsFavorite = isFavoriteList[i]
 		}
 	}
@@ -130,6 +168,8 @@ func FavoriteList(c *gin.Context) {
 			StatusCode: 0,
 			StatusMsg:  "OK",
 		},
-		VideoList: videoList,
+		VideoList:  videoList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
 	})
 }
@@ -4,21 +4,32 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/Ljkkun/GreenBeanMiners/service"
-	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/gin-gonic/gin"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 )
 
+// 视频流类型
+const (
+	FeedTypeRecommend = "recommend" // 全站视频按时间倒序
+	FeedTypeFollowing = "following" // 只包含关注的作者发布的视频
+)
+
 type FeedResponse struct {
 	Response
 	VideoList []Video `json:"video_list,omitempty"`
 	NextTime  int64   `json:"next_time,omitempty"`
 }
 
+type HotFeedResponse struct {
+	Response
+	VideoList  []Video `json:"video_list,omitempty"`
+	NextOffset int64   `json:"next_offset"`
+	HasMore    bool    `json:"has_more"`
+}
+
 // Feed 视频流接口（给客户端推送短视频）
 func Feed(c *gin.Context) {
 	// 不传latest_time默认为当前时间
@@ -31,10 +42,47 @@ func Feed(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter latest_time is wrong"})
 		return
 	}
+
+	var (
+		userID   uint64
+		isLogged = false // 用户是否传入了合法有效的token（是否登录）
+	)
+	// 判断传入的token是否合法，用户是否存在
+	if token := c.Query("token"); token != "" {
+		claims, err := service.ParseAccessToken(token)
+		if err == nil {
+			// token合法
+			userID = claims.UserID
+			isLogged = true
+		}
+	}
+
+	// 根据 feed_type 选择视频流
+	feedType := c.DefaultQuery("feed_type", FeedTypeRecommend)
+	var getFeed func(videoList *[]model.Video, authorList *[]model.User, latestTime int64) (int, error)
+	switch feedType {
+	case FeedTypeRecommend:
+		getFeed = func(videoList *[]model.Video, authorList *[]model.User, latestTime int64) (int, error) {
+			return service.GetFeedVideosAndAuthorsRedis(userID, videoList, authorList, latestTime, global.FEED_NUM)
+		}
+	case FeedTypeFollowing:
+		// 关注流需要登录
+		if !isLogged {
+			c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: "token is requested"})
+			return
+		}
+		getFeed = func(videoList *[]model.Video, authorList *[]model.User, latestTime int64) (int, error) {
+			return service.GetFollowingFeedVideosAndAuthorsRedis(userID, videoList, authorList, latestTime, global.FEED_NUM)
+		}
+	default:
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter feed_type is wrong"})
+		return
+	}
+
 	// 得到本次要返回的视频以及其作者
 	var videoList []model.Video
 	var authorList []model.User
-	numVideos, err := service.GetFeedVideosAndAuthorsRedis(&videoList, &authorList, LatestTime, global.FEED_NUM)
+	numVideos, err := getFeed(&videoList, &authorList, LatestTime)
 
 	if err != nil {
 		// 访问数据库出错
@@ -43,7 +91,7 @@ func Feed(c *gin.Context) {
 	}
 	if numVideos == 0 {
 		// 没有满足条件的视频 使用当前时间再获取一遍
-		numVideos, _ = service.GetFeedVideosAndAuthorsRedis(&videoList, &authorList, CurrentTimeInt, global.FEED_NUM)
+		numVideos, _ = getFeed(&videoList, &authorList, CurrentTimeInt)
 		if numVideos == 0 {
 			// 后端没有视频了
 			c.JSON(http.StatusOK, FeedResponse{
@@ -55,20 +103,37 @@ func Feed(c *gin.Context) {
 		}
 	}
 
+	videoJsonList, err := buildVideoJsonList(c, userID, isLogged, videoList, authorList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
+	//本次返回的视频中发布最早的时间
+	nextTime := videoList[numVideos-1].CreatedAt.UnixMilli()
+
+	c.JSON(http.StatusOK, FeedResponse{
+		Response:  Response{StatusCode: 0},
+		VideoList: videoJsonList,
+		NextTime:  nextTime,
+	})
+}
+
+// HotFeed 热门视频流，按热度从高到低分页返回
+func HotFeed(c *gin.Context) {
+	offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter offset is wrong"})
+		return
+	}
+
 	var (
-		videoJsonList  = make([]Video, 0, numVideos)
-		videoJson      Video
-		author         model.User
-		authorJson     User
-		isFavoriteList []bool
-		isFollowList   []bool
-		isLogged       = false // 用户是否传入了合法有效的token（是否登录）
+		userID   uint64
+		isLogged = false // 用户是否传入了合法有效的token（是否登录）
 	)
-
-	var userID uint64
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
 		if err == nil {
 			// token合法
 			userID = claims.UserID
@@ -76,6 +141,43 @@ func Feed(c *gin.Context) {
 		}
 	}
 
+	// 得到本次要返回的视频以及其作者
+	var videoList []model.Video
+	var authorList []model.User
+	consumed, hasMore, err := service.GetHotVideosAndAuthorsRedis(userID, &videoList, &authorList, offset, int64(global.FEED_NUM))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
+	videoJsonList, err := buildVideoJsonList(c, userID, isLogged, videoList, authorList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, HotFeedResponse{
+		Response:   Response{StatusCode: 0},
+		VideoList:  videoJsonList,
+		NextOffset: offset + consumed,
+		HasMore:    hasMore,
+	})
+}
+
+// buildVideoJsonList 填充视频流返回的 JSON，登录时附带点赞与关注状态
+func buildVideoJsonList(c *gin.Context, userID uint64, isLogged bool, videoList []model.Video, authorList []model.User) ([]Video, error) {
+	numVideos := len(videoList)
+	var (
+		videoJsonList  = make([]Video, 0, numVideos)
+		videoJson      Video
+		author         model.User
+		authorJson     User
+		isFavoriteList []bool
+		isFollowList   []bool
+		isFriendList   []bool
+		err            error
+	)
+
 	if isLogged {
 		// 当用户登录时 批量获取用户是否点赞了列表中的视频以及是否关注了视频的作者
 		videoIDList := make([]uint64, numVideos)
@@ -87,37 +189,38 @@ func Feed(c *gin.Context) {
 		// 批量获取用户是否用视频点赞
 		isFavoriteList, err = service.GetFavoriteStatusList(userID, videoIDList)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
-			return
+			return nil, err
 		}
 		// 批量获取用户是否关注作者
-		isFollowList, err = service.GetFollowStatusList(userID, authorIDList)
+		isFollowList, isFriendList, err = service.GetFollowAndFriendStatusList(userID, authorIDList)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
-			return
+			return nil, err
 		}
 	}
 
+	// 批量获取标题中提及的用户
+	videoIDList := make([]uint64, numVideos)
+	for i, video := range videoList {
+		videoIDList[i] = video.VideoID
+	}
+	mentionMap, err := service.GetTitleMentionMap(videoIDList)
+	if err != nil {
+		return nil, err
+	}
+
 	// 未登录时默认为未关注未点赞
 	var isFavorite = false
 	var isFollow = false
+	var isFriend = false
 
 	for i, video := range videoList {
 		if isLogged {
 			// 当用户登录时，判断是否关注当前作者
 			isFollow = isFollowList[i]
+			isFriend = isFriendList[i]
 			isFavorite = isFavoriteList[i]
 		}
 
-		// 二次确认返回的视频与封面是服务器存在的
-		VideoLocation := filepath.Join(global.VIDEO_ADDR, video.PlayName)
-		if _, err = os.Stat(VideoLocation); err != nil {
-			continue
-		}
-		CoverLocation := filepath.Join(global.COVER_ADDR, video.CoverName)
-		if _, err = os.Stat(CoverLocation); err != nil {
-			continue
-		}
 		// 填充JSON返回值
 		author = authorList[i]
 		authorJson.Id = author.UserID
@@ -127,25 +230,22 @@ func Feed(c *gin.Context) {
 		authorJson.TotalFavorited = author.TotalFavorited
 		authorJson.FavoriteCount = author.FavoriteCount
 		authorJson.IsFollow = isFollow
+		authorJson.IsFriend = isFriend
+		authorJson.Avatar = avatarURL(c, author.Avatar)
+		authorJson.BackgroundImage = backgroundURL(c, author.BackgroundImage)
+		authorJson.Signature = author.Signature
 
 		videoJson.Id = video.VideoID
 		videoJson.Author = authorJson
-		videoJson.PlayUrl = "http://" + c.Request.Host + "/public/video/" + video.PlayName
-		videoJson.CoverUrl = "http://" + c.Request.Host + "/public/cover/" + video.CoverName
+		videoJson.PlayUrl = mediaURL(c, storage.VideoKey(video.PlayName))
+		videoJson.CoverUrl = mediaURL(c, storage.CoverKey(video.CoverName))
 		videoJson.FavoriteCount = video.FavoriteCount
 		videoJson.CommentCount = video.CommentCount
 		videoJson.Title = video.Title
 		videoJson.IsFavorite = isFavorite
+		videoJson.Mentions = newMentionJsonList(mentionMap[video.VideoID])
 
 		videoJsonList = append(videoJsonList, videoJson)
 	}
-
-	//本次返回的视频中发布最早的时间
-	nextTime := videoList[numVideos-1].CreatedAt.UnixMilli()
-
-	c.JSON(http.StatusOK, FeedResponse{
-		Response:  Response{StatusCode: 0},
-		VideoList: videoJsonList,
-		NextTime:  nextTime,
-	})
+	return videoJsonList, nil
 }
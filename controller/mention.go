@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// MentionNotice 提及通知响应结构体
+type MentionNotice struct {
+	Id         uint64 `json:"id"`
+	FromUser   User   `json:"from_user"`            // 提及者
+	VideoId    uint64 `json:"video_id"`             // 所在视频
+	CommentId  uint64 `json:"comment_id,omitempty"` // 所在评论，在视频标题中提及时不返回
+	CreateTime int64  `json:"create_time"`          // 毫秒时间戳
+}
+
+type MentionListResponse struct {
+	Response
+	MentionList []MentionNotice `json:"mention_list"`
+	NextCursor  string          `json:"next_cursor,omitempty"` // 下一页的游标，还有更多时返回
+	HasMore     bool            `json:"has_more"`
+}
+
+// MentionList 提及当前用户的通知，按时间倒序分页返回
+func MentionList(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	if !paged {
+		limit = global.PAGE_SIZE
+	}
+	mentionList, fromUserList, nextCursor, err := service.GetMentionListByUserID(userID, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get mention list failed"})
+		return
+	}
+	fromUserIDList := make([]uint64, len(fromUserList))
+	for idx, user := range fromUserList {
+		fromUserIDList[idx] = user.UserID
+	}
+	isFollowList, isFriendList, err := service.GetFollowAndFriendStatusList(userID, fromUserIDList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 生成 response 数据
+	noticeList := make([]MentionNotice, len(mentionList))
+	for idx, mention := range mentionList {
+		user := fromUserList[idx]
+		noticeList[idx] = MentionNotice{
+			Id: mention.MentionID,
+			FromUser: User{
+				Id:              user.UserID,
+				Name:            user.Name,
+				FollowCount:     user.FollowCount,
+				FollowerCount:   user.FollowerCount,
+				TotalFavorited:  user.TotalFavorited,
+				FavoriteCount:   user.FavoriteCount,
+				IsFollow:        isFollowList[idx],
+				IsFriend:        isFriendList[idx],
+				Avatar:          avatarURL(c, user.Avatar),
+				BackgroundImage: backgroundURL(c, user.BackgroundImage),
+				Signature:       user.Signature,
+			},
+			VideoId:    mention.VideoID,
+			CommentId:  mention.CommentID,
+			CreateTime: mention.CreatedAt.UnixMilli(),
+		}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, MentionListResponse{
+		Response:    Response{StatusCode: 0, StatusMsg: "OK"},
+		MentionList: noticeList,
+		NextCursor:  util.EncodeCursor(nextCursor),
+		HasMore:     nextCursor != nil,
+	})
+}
@@ -1,69 +1,201 @@
 package controller
 
 import (
-	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/service"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"log"
 	"net/http"
-	"strconv"
-	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-var tempChat = map[string][]Message{}
+// upgrader 将 http 连接升级为 WebSocket 连接，移动端没有 Origin 限制
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// MessageActionRequest 发送消息的请求
+type MessageActionRequest struct {
+	Token      string `form:"token" json:"token"`
+	ToUserID   uint64 `form:"to_user_id" json:"to_user_id"`
+	ActionType uint   `form:"action_type" json:"action_type"`
+	Content    string `form:"content" json:"content"`
+}
 
-var messageIdSequence = int64(1)
+// MessageChatRequest 聊天记录的请求
+type MessageChatRequest struct {
+	Token      string `form:"token" json:"token"`
+	ToUserID   uint64 `form:"to_user_id" json:"to_user_id"`
+	PreMsgTime int64  `form:"pre_msg_time" json:"pre_msg_time"` // 上次最新消息的时间（毫秒）
+	PreMsgID   uint64 `form:"pre_msg_id" json:"pre_msg_id"`     // 上次最新消息的 ID，与 pre_msg_time 一起确定位置
+}
 
 type ChatResponse struct {
 	Response
 	MessageList []Message `json:"message_list"`
 }
 
-// MessageAction no practical effect, just check if token is valid
+// MessageAction 发送消息接口
 func MessageAction(c *gin.Context) {
-	token := c.Query("token")
-	toUserId := c.Query("to_user_id")
-	content := c.Query("content")
+	// 参数绑定
+	var r MessageActionRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "bind error"})
+		return
+	}
+	// 判断 action_type 是否正确
+	if r.ActionType != 1 {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "action type error"})
+		return
+	}
+	// 判断消息是否合法
+	if utf8.RuneCountInString(r.Content) > global.MAX_MESSAGE_LENGTH ||
+		utf8.RuneCountInString(r.Content) <= 0 {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "非法消息"})
+		return
+	}
+	// 获取当前用户的 ID
+	userID := c.GetUint64("UserID")
+	// 写入数据库
+	message, err := service.SendMessage(userID, r.ToUserID, r.Content)
+	if err != nil {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 推送给在线的接收方
+	pushMessage(message)
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, Response{StatusCode: 0})
+}
+
+// MessageChat 聊天记录接口，只返回 pre_msg_time 和 pre_msg_id 之后的消息
+func MessageChat(c *gin.Context) {
+	// 参数绑定
+	var r MessageChatRequest
+	if err := c.ShouldBind(&r); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "bind error"})
+		return
+	}
+	// 获取当前用户的 ID
+	userID := c.GetUint64("UserID")
+	// 查询聊天记录
+	messageModelList, err := service.GetMessageList(userID, r.ToUserID, r.PreMsgTime, r.PreMsgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 生成 response 数据
+	messageList := make([]Message, 0, len(messageModelList))
+	for _, each := range messageModelList {
+		messageList = append(messageList, Message{
+			Id:         each.MessageID,
+			ToUserId:   each.ToUserID,
+			FromUserId: each.FromUserID,
+			Content:    each.Content,
+			CreateTime: each.CreatedAt.UnixMilli(),
+		})
+	}
+	c.JSON(http.StatusOK, ChatResponse{
+		Response:    Response{StatusCode: 0},
+		MessageList: messageList,
+	})
+}
 
-	if user, exist := usersLoginInfo[token]; exist {
-		userIdB, _ := strconv.Atoi(toUserId)
-		chatKey := genChatKey(int64(user.Id), int64(userIdB))
+// MessageWebSocket 私信实时推送接口
+// 1. 连接建立后推送离线消息 2. 接收客户端发送的消息并推送给接收方的所有在线设备
+func MessageWebSocket(c *gin.Context) {
+	// 获取当前用户的 ID
+	userID := c.GetUint64("UserID")
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 失败时已经写回了错误响应
+		return
+	}
+	// 登记设备并推送离线消息
+	client, err := service.RegisterMessageClient(userID, conn, func(message *model.Message) interface{} {
+		return newMessagePushEvent(message)
+	})
+	defer service.UnregisterMessageClient(client)
+	if err != nil {
+		log.Println("push offline message failed:", err)
+		return
+	}
 
-		atomic.AddInt64(&messageIdSequence, 1)
-		curMessage := Message{
-			Id:         messageIdSequence,
-			Content:    content,
-			CreateTime: time.Now().Format(time.Kitchen),
+	// 心跳保活
+	conn.SetReadLimit(global.WS_MAX_MESSAGE_SIZE)
+	_ = conn.SetReadDeadline(time.Now().Add(global.WS_PONG_WAIT))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(global.WS_PONG_WAIT))
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(global.WS_PING_PERIOD)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := client.Ping(); err != nil {
+					return
+				}
+			case <-done:
+				return
+			}
 		}
+	}()
 
-		if messages, exist := tempChat[chatKey]; exist {
-			tempChat[chatKey] = append(messages, curMessage)
-		} else {
-			tempChat[chatKey] = []Message{curMessage}
+	// 接收客户端消息
+	for {
+		var event MessageSendEvent
+		if err = conn.ReadJSON(&event); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println("read message failed:", err)
+			}
+			return
+		}
+		// 内容为空的消息仅用于建立会话，忽略
+		if utf8.RuneCountInString(event.MsgContent) <= 0 {
+			continue
 		}
-		c.JSON(http.StatusOK, Response{StatusCode: 0})
-	} else {
-		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "User doesn't exist"})
+		if utf8.RuneCountInString(event.MsgContent) > global.MAX_MESSAGE_LENGTH {
+			_ = client.Push(Response{StatusCode: 1, StatusMsg: "非法消息"})
+			continue
+		}
+		// 发送方以 token 中的用户为准
+		message, err := service.SendMessage(userID, uint64(event.ToUserId), event.MsgContent)
+		if err != nil {
+			_ = client.Push(Response{StatusCode: 1, StatusMsg: err.Error()})
+			continue
+		}
+		pushMessage(message)
 	}
 }
 
-// MessageChat all users have same follow list
-func MessageChat(c *gin.Context) {
-	token := c.Query("token")
-	toUserId := c.Query("to_user_id")
-
-	if user, exist := usersLoginInfo[token]; exist {
-		userIdB, _ := strconv.Atoi(toUserId)
-		chatKey := genChatKey(int64(user.Id), int64(userIdB))
-
-		c.JSON(http.StatusOK, ChatResponse{Response: Response{StatusCode: 0}, MessageList: tempChat[chatKey]})
-	} else {
-		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "User doesn't exist"})
+// pushMessage 将消息推送给接收方的所有在线设备，推送成功则标记为已送达
+func pushMessage(message *model.Message) {
+	if service.PushToUser(message.ToUserID, message.MessageID, newMessagePushEvent(message)) == 0 {
+		// 接收方不在线，保留为离线消息
+		return
+	}
+	if err := service.MarkMessageListDelivered([]uint64{message.MessageID}); err != nil {
+		log.Println("mark message delivered failed:", err)
 	}
 }
 
-func genChatKey(userIdA int64, userIdB int64) string {
-	if userIdA > userIdB {
-		return fmt.Sprintf("%d_%d", userIdB, userIdA)
+// newMessagePushEvent 生成推送事件
+func newMessagePushEvent(message *model.Message) MessagePushEvent {
+	return MessagePushEvent{
+		MsgId:      message.MessageID,
+		FromUserId: int64(message.FromUserID),
+		ToUserId:   int64(message.ToUserID),
+		MsgContent: message.Content,
+		CreateTime: message.CreatedAt.UnixMilli(),
 	}
-	return fmt.Sprintf("%d_%d", userIdA, userIdB)
 }
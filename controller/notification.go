@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strconv"
+)
+
+// Notification 通知响应结构体，同一类型、同一对象的未读通知聚合为一条
+type Notification struct {
+	Id         uint64 `json:"id"`
+	Type       int8   `json:"type"`                // 1-点赞 2-评论 3-回复 4-关注 5-提及
+	FromUser   User   `json:"from_user"`           // 最近一次触发通知的用户
+	ActorCount int64  `json:"actor_count"`         // 聚合的事件数目
+	VideoId    uint64 `json:"video_id,omitempty"`  // 相关视频，关注通知不返回
+	TargetId   uint64 `json:"target_id,omitempty"` // 回复通知为顶层评论 ID，提及通知为提及 ID
+	IsRead     bool   `json:"is_read"`
+	UpdateTime int64  `json:"update_time"` // 毫秒时间戳
+}
+
+type NotificationListResponse struct {
+	Response
+	NotificationList []Notification `json:"notification_list"`
+	NextCursor       string         `json:"next_cursor,omitempty"` // 下一页的游标，还有更多时返回
+	HasMore          bool           `json:"has_more"`
+}
+
+type UnreadCountResponse struct {
+	Response
+	UnreadCount int64 `json:"unread_count"`
+}
+
+// NotificationList 当前用户的通知，按通知产生的先后倒序分页返回
+func NotificationList(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	if !paged {
+		limit = global.PAGE_SIZE
+	}
+	notificationModelList, actorList, nextCursor, err := service.GetNotificationListByUserID(userID, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get notification list failed"})
+		return
+	}
+	actorIDList := make([]uint64, len(actorList))
+	for idx, user := range actorList {
+		actorIDList[idx] = user.UserID
+	}
+	isFollowList, isFriendList, err := service.GetFollowAndFriendStatusList(userID, actorIDList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 生成 response 数据
+	notificationList := make([]Notification, len(notificationModelList))
+	for idx, notification := range notificationModelList {
+		user := actorList[idx]
+		notificationList[idx] = Notification{
+			Id:   notification.NotificationID,
+			Type: notification.Type,
+			FromUser: User{
+				Id:              user.UserID,
+				Name:            user.Name,
+				FollowCount:     user.FollowCount,
+				FollowerCount:   user.FollowerCount,
+				TotalFavorited:  user.TotalFavorited,
+				FavoriteCount:   user.FavoriteCount,
+				IsFollow:        isFollowList[idx],
+				IsFriend:        isFriendList[idx],
+				Avatar:          avatarURL(c, user.Avatar),
+				BackgroundImage: backgroundURL(c, user.BackgroundImage),
+				Signature:       user.Signature,
+			},
+			ActorCount: notification.ActorCount,
+			VideoId:    notification.VideoID,
+			TargetId:   notification.TargetID,
+			IsRead:     notification.Unread == nil,
+			UpdateTime: notification.UpdatedAt.UnixMilli(),
+		}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, NotificationListResponse{
+		Response:         Response{StatusCode: 0, StatusMsg: "OK"},
+		NotificationList: notificationList,
+		NextCursor:       util.EncodeCursor(nextCursor),
+		HasMore:          nextCursor != nil,
+	})
+}
+
+// NotificationUnreadCount 当前用户的未读通知数目
+func NotificationUnreadCount(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	count, err := service.GetUnreadNotificationCount(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, UnreadCountResponse{
+		Response:    Response{StatusCode: 0, StatusMsg: "OK"},
+		UnreadCount: count,
+	})
+}
+
+// NotificationRead 将通知标记为已读，不传 notification_id 时标记全部通知
+func NotificationRead(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	var notificationID uint64
+	if notificationIDStr := c.Query("notification_id"); notificationIDStr != "" {
+		var err error
+		if notificationID, err = strconv.ParseUint(notificationIDStr, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+			return
+		}
+	}
+	if err := service.MarkNotificationsRead(userID, notificationID); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
@@ -4,18 +4,21 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/Ljkkun/GreenBeanMiners/service"
-	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 	"unicode/utf8"
 )
 
 type VideoListResponse struct {
 	Response
-	VideoList []Video `json:"video_list"`
+	VideoList  []Video `json:"video_list"`
+	NextCursor string  `json:"next_cursor,omitempty"` // 下一页的游标，分页且还有更多时返回
+	HasMore    bool    `json:"has_more"`
 }
 
 // Publish 投稿接口
@@ -31,6 +34,28 @@ func Publish(c *gin.Context) {
 		return
 	}
 
+	// 可见范围，默认所有人可见
+	visibility, err := strconv.ParseInt(c.DefaultPostForm("visibility", "0"), 10, 8)
+	if err != nil || visibility < int64(model.VideoVisibilityPublic) || visibility > int64(model.VideoVisibilityPrivate) {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter visibility is wrong"})
+		return
+	}
+	// 定时发布时间，单位为毫秒，不传时转码完成后立即发布
+	var publishAt *time.Time
+	if publishAtStr := c.PostForm("publish_at"); publishAtStr != "" {
+		publishAtMilli, err := strconv.ParseInt(publishAtStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter publish_at is wrong"})
+			return
+		}
+		t := time.UnixMilli(publishAtMilli)
+		if t.Before(time.Now()) || t.After(time.Now().Add(global.MAX_SCHEDULE_AHEAD)) {
+			c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter publish_at is out of range"})
+			return
+		}
+		publishAt = &t
+	}
+
 	data, err := c.FormFile("data")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
@@ -50,13 +75,11 @@ func Publish(c *gin.Context) {
 		return
 	}
 	name := strconv.FormatUint(videoID, 10)
-	videoName := name + c.GetString("FileType")
-	coverName := name + ".jpg"
+	sourceName := name + c.GetString("FileType")
 
-	videoSavePath := filepath.Join(global.VIDEO_ADDR, videoName)
-	coverSavePath := filepath.Join(global.COVER_ADDR, coverName)
-
-	if err = c.SaveUploadedFile(data, videoSavePath); err != nil {
+	// 保存到临时目录，由后台转码后写入存储
+	sourcePath := filepath.Join(global.UPLOAD_ADDR, sourceName)
+	if err = c.SaveUploadedFile(data, sourcePath); err != nil {
 		// 视频无法保存
 		c.JSON(http.StatusInternalServerError, Response{
 			StatusCode: 1,
@@ -65,21 +88,12 @@ func Publish(c *gin.Context) {
 		return
 	}
 
-	//if err := util.GetFrame(videoSavePath, coverSavePath, 1); err != nil {
-	if _, err := util.GetFrame(videoSavePath, coverSavePath, 1); err != nil {
-		// 封面无法保存
-		c.JSON(http.StatusInternalServerError, Response{
-			StatusCode: 1,
-			StatusMsg:  err.Error(),
-		})
-		return
-	}
-
-	// 写入数据库
-	err = service.PublishVideo(userID, videoID, videoName, coverName, title)
+	// 写入数据库并加入转码队列
+	err = service.PublishVideo(userID, videoID, sourceName, title, int8(visibility), publishAt)
 
 	if err != nil {
 		// 无法写入数据库
+		_ = os.Remove(sourcePath)
 		c.JSON(http.StatusInternalServerError, Response{
 			StatusCode: 1,
 			StatusMsg:  err.Error(),
@@ -89,10 +103,47 @@ func Publish(c *gin.Context) {
 
 	c.JSON(http.StatusOK, Response{
 		StatusCode: 0,
-		StatusMsg:  " uploaded successfully",
+		StatusMsg:  " uploaded successfully, transcoding",
 	})
 }
 
+// PublishDelete 删除视频接口，只有作者可以删除
+func PublishDelete(c *gin.Context) {
+	videoID, err := strconv.ParseUint(c.Query("video_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+		return
+	}
+	userID := c.GetUint64("UserID")
+	if err = service.DeleteVideo(userID, videoID); err != nil {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
+// PublishUpdate 修改视频标题接口，只有作者可以修改
+func PublishUpdate(c *gin.Context) {
+	videoID, err := strconv.ParseUint(c.Query("video_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+		return
+	}
+	title := c.Query("title")
+	// 判断title是否合法
+	if utf8.RuneCountInString(title) > global.MAX_TITLE_LENGTH ||
+		utf8.RuneCountInString(title) <= 0 {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "非法视频描述"})
+		return
+	}
+	userID := c.GetUint64("UserID")
+	if err = service.UpdateVideoTitle(userID, videoID, title); err != nil {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
 // PublishList 发布列表接口
 func PublishList(c *gin.Context) {
 	// 获取 authorID
@@ -104,6 +155,10 @@ func PublishList(c *gin.Context) {
 		})
 		return
 	}
+	// 私密账号只对本人和已关注的用户可见
+	if !checkUserContentVisible(c, authorID) {
+		return
+	}
 	// 得到用户发布过的视频
 	var videoList []model.Video
 	numVideos, err := service.GetPublishedVideosRedis(&videoList, authorID)
@@ -137,18 +192,25 @@ func PublishList(c *gin.Context) {
 		authorJson     User
 		isFavoriteList []bool
 		isFollowList   []bool
+		isFriendList   []bool
 		isLogged       = false // 用户是否传入了合法有效的token（是否登录）
 	)
 
 	var userID uint64
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
 		if err == nil {
 			userID = claims.UserID
 			isLogged = true
 		}
 	}
+	// 过滤当前用户无权查看的视频
+	if videoList, err = service.FilterVisibleVideoList(userID, videoList); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	numVideos = len(videoList)
 
 	if isLogged {
 		// 当用户登录时 批量获取用户是否点赞了列表中的视频以及是否关注了视频的作者
@@ -164,32 +226,36 @@ func PublishList(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
 			return
 		}
-		isFollowList, err = service.GetFollowStatusList(userID, authorIDList)
+		isFollowList, isFriendList, err = service.GetFollowAndFriendStatusList(userID, authorIDList)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
 			return
 		}
 	}
 
+	// 批量获取标题中提及的用户
+	videoIDList := make([]uint64, numVideos)
+	for i, video := range videoList {
+		videoIDList[i] = video.VideoID
+	}
+	mentionMap, err := service.GetTitleMentionMap(videoIDList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+
 	// 未登录时默认为未关注未点赞
 	var isFavorite = false
 	var isFollow = false
+	var isFriend = false
 
 	for i, video := range videoList {
 		if isLogged {
 			// 当用户登录时，判断是否关注当前作者
 			isFollow = isFollowList[i]
+			isFriend = isFriendList[i]
 			isFavorite = isFavoriteList[i]
 		}
-		// 二次确认返回的视频与封面是服务器存在的
-		VideoLocation := filepath.Join(global.VIDEO_ADDR, video.PlayName)
-		if _, err = os.Stat(VideoLocation); err != nil {
-			continue
-		}
-		CoverLocation := filepath.Join(global.COVER_ADDR, video.CoverName)
-		if _, err = os.Stat(CoverLocation); err != nil {
-			continue
-		}
 
 		authorJson.Id = author.UserID
 		authorJson.Name = author.Name
@@ -198,15 +264,20 @@ func PublishList(c *gin.Context) {
 		authorJson.TotalFavorited = author.TotalFavorited
 		authorJson.FavoriteCount = author.FavoriteCount
 		authorJson.IsFollow = isFollow
+		authorJson.IsFriend = isFriend
+		authorJson.Avatar = avatarURL(c, author.Avatar)
+		authorJson.BackgroundImage = backgroundURL(c, author.BackgroundImage)
+		authorJson.Signature = author.Signature
 
 		videoJson.Id = video.VideoID
 		videoJson.Author = authorJson
-		videoJson.PlayUrl = "http://" + c.Request.Host + "/public/video/" + video.PlayName
-		videoJson.CoverUrl = "http://" + c.Request.Host + "/public/cover/" + video.CoverName
+		videoJson.PlayUrl = mediaURL(c, storage.VideoKey(video.PlayName))
+		videoJson.CoverUrl = mediaURL(c, storage.CoverKey(video.CoverName))
 		videoJson.FavoriteCount = video.FavoriteCount
 		videoJson.CommentCount = video.CommentCount
 		videoJson.Title = video.Title
 		videoJson.IsFavorite = isFavorite
+		videoJson.Mentions = newMentionJsonList(mentionMap[video.VideoID])
 
 		videoJsonList = append(videoJsonList, videoJson)
 	}
@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/Ljkkun/GreenBeanMiners/service"
 	"github.com/Ljkkun/GreenBeanMiners/util"
 	"github.com/gin-gonic/gin"
@@ -10,7 +11,9 @@ import (
 
 type UserListResponse struct {
 	Response
-	UserList []User `json:"user_list"`
+	UserList   []User `json:"user_list"`
+	NextCursor string `json:"next_cursor,omitempty"` // 下一页的游标，分页且还有更多时返回
+	HasMore    bool   `json:"has_more"`
 }
 
 // RelationAction 评论操作
@@ -25,11 +28,17 @@ func RelationAction(c *gin.Context) {
 	}
 	// 获取当前用户的 ID
 	viewID := c.GetUint64("UserID")
-	// 关注操作
+	// 关注操作，私密账号需要等待对方同意
 	if actionType == 1 {
-		if err := service.AddFollow(viewID, toUserID); err != nil {
+		if pending, err := service.RequestFollow(viewID, toUserID); err == service.ErrUserBlocked {
+			c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: err.Error()})
+			return
+		} else if err != nil {
 			c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "server error"})
 			return
+		} else if pending {
+			c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "follow request pending"})
+			return
 		}
 	} else {
 		if err := service.CancelFollow(viewID, toUserID); err != nil {
@@ -49,6 +58,10 @@ func FollowList(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
 		return
 	}
+	// 私密账号只对本人和已关注的用户可见
+	if !checkUserContentVisible(c, followerID) {
+		return
+	}
 	// 判断是否登录
 	var (
 		isLogin  bool
@@ -56,14 +69,28 @@ func FollowList(c *gin.Context) {
 	)
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
 		if err == nil {
 			viewerID = claims.UserID
 			isLogin = true
 		}
 	}
+	// 解析分页参数
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
 	// 获取用户的关注列表
-	celebrityList, err := service.GetFollowListByUserID(followerID)
+	var (
+		celebrityList []model.User
+		nextCursor    *util.Cursor
+	)
+	if paged {
+		celebrityList, nextCursor, err = service.GetFollowListByUserIDPage(followerID, cursor, limit)
+	} else {
+		celebrityList, err = service.GetFollowListByUserID(followerID)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get Follower list failed"})
 		return
@@ -73,26 +100,32 @@ func FollowList(c *gin.Context) {
 	var userList []User
 	for idx, celebrity := range celebrityList {
 		var user = User{
-			Id:            celebrity.UserID,
-			Name:          celebrity.Name,
-			FollowCount:   celebrity.FollowCount,
-			FollowerCount: celebrity.FollowerCount,
+			Id:              celebrity.UserID,
+			Name:            celebrity.Name,
+			FollowCount:     celebrity.FollowCount,
+			FollowerCount:   celebrity.FollowerCount,
+			Avatar:          avatarURL(c, celebrity.Avatar),
+			BackgroundImage: backgroundURL(c, celebrity.BackgroundImage),
+			Signature:       celebrity.Signature,
 		}
 		userList = append(userList, user)
 		celebrityIDList[idx] = celebrity.UserID
 	}
 	// 批量处理
 	if isLogin {
-		// 登录时，获取是否关注，否则总是为false
-		isFollowList, _ := service.GetFollowStatusList(viewerID, celebrityIDList)
-		for idx, isFollow := range isFollowList {
-			userList[idx].IsFollow = isFollow
+		// 登录时，获取是否关注和是否互相关注，否则总是为false
+		isFollowList, isFriendList, _ := service.GetFollowAndFriendStatusList(viewerID, celebrityIDList)
+		for idx := range isFollowList {
+			userList[idx].IsFollow = isFollowList[idx]
+			userList[idx].IsFriend = isFriendList[idx]
 		}
 	}
 	// 返回成功并生成响应 json
 	c.JSON(http.StatusOK, UserListResponse{
-		Response: Response{StatusCode: 0, StatusMsg: "OK"},
-		UserList: userList,
+		Response:   Response{StatusCode: 0, StatusMsg: "OK"},
+		UserList:   userList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
 	})
 }
 
@@ -100,6 +133,10 @@ func FollowList(c *gin.Context) {
 func FollowerList(c *gin.Context) {
 	// 参数绑定
 	celebrityID, _ := strconv.ParseUint(c.Query("user_id"), 10, 64)
+	// 私密账号只对本人和已关注的用户可见
+	if !checkUserContentVisible(c, celebrityID) {
+		return
+	}
 	// 判断是否登录
 	var (
 		isLogin  bool
@@ -107,34 +144,134 @@ func FollowerList(c *gin.Context) {
 	)
 	// 判断传入的token是否合法，用户是否存在
 	if token := c.Query("token"); token != "" {
-		claims, err := util.ParseToken(token)
+		claims, err := service.ParseAccessToken(token)
 		if err == nil {
 			viewerID = claims.UserID
 			isLogin = true
 		}
 	}
+	// 解析分页参数
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
 	// 获取用户的粉丝列表
-	followerList, err := service.GetFollowerListByUserID(celebrityID)
+	var (
+		followerList []model.User
+		nextCursor   *util.Cursor
+	)
+	if paged {
+		followerList, nextCursor, err = service.GetFollowerListByUserIDPage(celebrityID, cursor, limit)
+	} else {
+		followerList, err = service.GetFollowerListByUserID(celebrityID)
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get Follower list failed"})
 		return
 	}
 	// 生成 response 数据
+	followerIDList := make([]uint64, len(followerList))
 	var userList []User
-	for _, follower := range followerList {
-		isFollow := false
-		if isLogin {
-			// 登录时，获取是否关注，否则总是为false
-			isFollow, _ = service.GetFollowStatus(viewerID, follower.UserID)
-		}
+	for idx, follower := range followerList {
 		var user = User{
-			Id:            follower.UserID,
-			Name:          follower.Name,
-			FollowCount:   follower.FollowCount,
-			FollowerCount: follower.FollowerCount,
-			IsFollow:      isFollow,
+			Id:              follower.UserID,
+			Name:            follower.Name,
+			FollowCount:     follower.FollowCount,
+			FollowerCount:   follower.FollowerCount,
+			Avatar:          avatarURL(c, follower.Avatar),
+			BackgroundImage: backgroundURL(c, follower.BackgroundImage),
+			Signature:       follower.Signature,
 		}
 		userList = append(userList, user)
+		followerIDList[idx] = follower.UserID
+	}
+	// 批量处理
+	if isLogin {
+		// 登录时，获取是否关注和是否互相关注，否则总是为false
+		isFollowList, isFriendList, _ := service.GetFollowAndFriendStatusList(viewerID, followerIDList)
+		for idx := range isFollowList {
+			userList[idx].IsFollow = isFollowList[idx]
+			userList[idx].IsFriend = isFriendList[idx]
+		}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, UserListResponse{
+		Response:   Response{StatusCode: 0, StatusMsg: "OK"},
+		UserList:   userList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
+	})
+}
+
+// BlockAction 拉黑操作，拉黑后解除双方关注关系，被拉黑的用户不能关注、评论和私信
+func BlockAction(c *gin.Context) {
+	relationAction(c, service.AddBlock, service.CancelBlock)
+}
+
+// MuteAction 屏蔽操作，被屏蔽用户的视频和评论不再展示
+func MuteAction(c *gin.Context) {
+	relationAction(c, service.AddMute, service.CancelMute)
+}
+
+// BlockList 获取当前用户的拉黑列表
+func BlockList(c *gin.Context) {
+	relationList(c, service.GetBlockListByUserID)
+}
+
+// MuteList 获取当前用户的屏蔽列表
+func MuteList(c *gin.Context) {
+	relationList(c, service.GetMuteListByUserID)
+}
+
+// relationAction 拉黑、屏蔽和处理关注申请的公共部分，action_type 为 1 表示添加或同意，2 表示取消或拒绝
+func relationAction(c *gin.Context, add func(userID, targetID uint64) error, cancel func(userID, targetID uint64) error) {
+	// 参数绑定
+	toUserID, err := strconv.ParseUint(c.Query("to_user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+		return
+	}
+	actionType, _ := strconv.ParseInt(c.Query("action_type"), 10, 64)
+	// 判断 action_type 是否正确
+	if actionType != 1 && actionType != 2 {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "action type error"})
+		return
+	}
+	// 获取当前用户的 ID
+	userID := c.GetUint64("UserID")
+	if actionType == 1 {
+		err = add(userID, toUserID)
+	} else {
+		err = cancel(userID, toUserID)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "server error"})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
+// relationList 拉黑、屏蔽和关注申请列表的公共部分，只能查看自己的列表
+func relationList(c *gin.Context, getList func(userID uint64) ([]model.User, error)) {
+	userModelList, err := getList(c.GetUint64("UserID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get user list failed"})
+		return
+	}
+	// 生成 response 数据
+	userList := make([]User, len(userModelList))
+	for idx, user := range userModelList {
+		userList[idx] = User{
+			Id:              user.UserID,
+			Name:            user.Name,
+			FollowCount:     user.FollowCount,
+			FollowerCount:   user.FollowerCount,
+			Avatar:          avatarURL(c, user.Avatar),
+			BackgroundImage: backgroundURL(c, user.BackgroundImage),
+			Signature:       user.Signature,
+		}
 	}
 	// 返回成功并生成响应 json
 	c.JSON(http.StatusOK, UserListResponse{
@@ -143,12 +280,73 @@ func FollowerList(c *gin.Context) {
 	})
 }
 
-// FriendList all users have same friend list
-//func FriendList(c *gin.Context) {
-//	c.JSON(http.StatusOK, UserListResponse{
-//		Response: Response{
-//			StatusCode: 0,
-//		},
-//		UserList: []User{DemoUser},
-//	})
-//}
+// FollowRequestList 获取当前用户待处理的关注申请
+func FollowRequestList(c *gin.Context) {
+	relationList(c, service.GetPendingFollowRequestList)
+}
+
+// FollowRequestAction 处理 to_user_id 发来的关注申请，action_type 为 1 表示同意，2 表示拒绝
+func FollowRequestAction(c *gin.Context) {
+	relationAction(c, service.ApproveFollowRequest, service.RejectFollowRequest)
+}
+
+// FriendUser 好友列表中的用户，附带与其最新的一条私信
+type FriendUser struct {
+	User
+	Message string `json:"message,omitempty"` // 最新的一条私信内容
+	MsgType int64  `json:"msgType"`           // 0 表示当前用户接收的消息，1 表示当前用户发送的消息
+}
+
+type FriendListResponse struct {
+	Response
+	UserList []FriendUser `json:"user_list"`
+}
+
+// FriendList 获取好友列表，即互相关注的用户，以及与每个好友的最新一条私信
+func FriendList(c *gin.Context) {
+	// 获取当前用户的 ID，好友列表包含私信内容，只允许查询自己的
+	viewerID := c.GetUint64("UserID")
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		userID, err := strconv.ParseUint(userIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+			return
+		}
+		if userID != viewerID {
+			c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: "permission denied"})
+			return
+		}
+	}
+	// 获取用户的好友列表
+	friendList, messageList, err := service.GetFriendListByUserID(viewerID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get friend list failed"})
+		return
+	}
+	// 生成 response 数据
+	userList := make([]FriendUser, len(friendList))
+	for idx, friend := range friendList {
+		userList[idx].User = User{
+			Id:              friend.UserID,
+			Name:            friend.Name,
+			FollowCount:     friend.FollowCount,
+			FollowerCount:   friend.FollowerCount,
+			IsFollow:        true,
+			IsFriend:        true,
+			Avatar:          avatarURL(c, friend.Avatar),
+			BackgroundImage: backgroundURL(c, friend.BackgroundImage),
+			Signature:       friend.Signature,
+		}
+		if message := messageList[idx]; message != nil {
+			userList[idx].Message = message.Content
+			if message.FromUserID == viewerID {
+				userList[idx].MsgType = 1
+			}
+		}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, FriendListResponse{
+		Response: Response{StatusCode: 0, StatusMsg: "OK"},
+		UserList: userList,
+	})
+}
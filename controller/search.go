@@ -0,0 +1,108 @@
+package controller
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// SearchVideo 按标题搜索视频，按相关度从高到低分页返回
+func SearchVideo(c *gin.Context) {
+	keyword, cursor, limit, err := parseSearchParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	userID, isLogged := parseOptionalToken(c)
+
+	videoList, authorList, nextCursor, err := service.SearchVideos(userID, keyword, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "search videos failed"})
+		return
+	}
+	videoJsonList, err := buildVideoJsonList(c, userID, isLogged, videoList, authorList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, VideoListResponse{
+		Response:   Response{StatusCode: 0, StatusMsg: "OK"},
+		VideoList:  videoJsonList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
+	})
+}
+
+// SearchUser 按用户名搜索用户，按相关度从高到低分页返回
+func SearchUser(c *gin.Context) {
+	keyword, cursor, limit, err := parseSearchParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	viewerID, isLogged := parseOptionalToken(c)
+
+	userModelList, nextCursor, err := service.SearchUsers(viewerID, keyword, cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "search users failed"})
+		return
+	}
+	// 生成 response 数据
+	userIDList := make([]uint64, len(userModelList))
+	userList := make([]User, len(userModelList))
+	for idx, user := range userModelList {
+		userList[idx] = User{
+			Id:              user.UserID,
+			Name:            user.Name,
+			FollowCount:     user.FollowCount,
+			FollowerCount:   user.FollowerCount,
+			TotalFavorited:  user.TotalFavorited,
+			FavoriteCount:   user.FavoriteCount,
+			Avatar:          avatarURL(c, user.Avatar),
+			BackgroundImage: backgroundURL(c, user.BackgroundImage),
+			Signature:       user.Signature,
+		}
+		userIDList[idx] = user.UserID
+	}
+	if isLogged {
+		// 登录时，获取是否关注和是否互相关注，否则总是为false
+		isFollowList, isFriendList, err := service.GetFollowAndFriendStatusList(viewerID, userIDList)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+			return
+		}
+		for idx := range isFollowList {
+			userList[idx].IsFollow = isFollowList[idx]
+			userList[idx].IsFriend = isFriendList[idx]
+		}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, UserListResponse{
+		Response:   Response{StatusCode: 0, StatusMsg: "OK"},
+		UserList:   userList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
+	})
+}
+
+// parseSearchParams 解析搜索关键词和分页参数，未传分页参数时返回第一页
+func parseSearchParams(c *gin.Context) (keyword string, cursor *util.Cursor, limit int, err error) {
+	keyword = strings.TrimSpace(c.Query("keyword"))
+	if keyword == "" || utf8.RuneCountInString(keyword) > global.MAX_KEYWORD_LENGTH {
+		return "", nil, 0, errors.New("parameter keyword is wrong")
+	}
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		return "", nil, 0, err
+	}
+	if !paged {
+		limit = global.PAGE_SIZE
+	}
+	return keyword, cursor, limit, nil
+}
@@ -0,0 +1,85 @@
+package controller
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+)
+
+// Tag 话题响应结构体
+type Tag struct {
+	Name       string `json:"name"`
+	VideoCount int64  `json:"video_count"` // 近期发布的视频数量
+}
+
+type TagListResponse struct {
+	Response
+	TagList []Tag `json:"tag_list"`
+}
+
+// TagVideoList 话题页，分页返回话题下的视频，sort 为 hot 时按热度排序，默认按发布时间倒序
+func TagVideoList(c *gin.Context) {
+	name := strings.TrimPrefix(strings.TrimSpace(c.Query("tag")), "#")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter tag is wrong"})
+		return
+	}
+	sort := c.DefaultQuery("sort", "time")
+	if sort != "time" && sort != "hot" {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "parameter sort is wrong"})
+		return
+	}
+	cursor, limit, paged, err := parsePageParams(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	if !paged {
+		limit = global.PAGE_SIZE
+	}
+	userID, isLogged := parseOptionalToken(c)
+
+	tag, err := service.GetTagByName(name)
+	if err != nil {
+		c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	videoList, authorList, nextCursor, err := service.GetTagVideoList(userID, tag.TagID, sort == "hot", cursor, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get tag video list failed"})
+		return
+	}
+	videoJsonList, err := buildVideoJsonList(c, userID, isLogged, videoList, authorList)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, VideoListResponse{
+		Response:   Response{StatusCode: 0, StatusMsg: "OK"},
+		VideoList:  videoJsonList,
+		NextCursor: util.EncodeCursor(nextCursor),
+		HasMore:    nextCursor != nil,
+	})
+}
+
+// TrendingTags 热门话题，按近期发布的视频数量从高到低返回
+func TrendingTags(c *gin.Context) {
+	nameList, countList, err := service.GetTrendingTagList()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: "get trending tags failed"})
+		return
+	}
+	tagList := make([]Tag, len(nameList))
+	for i, name := range nameList {
+		tagList[i] = Tag{Name: name, VideoCount: countList[i]}
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, TagListResponse{
+		Response: Response{StatusCode: 0, StatusMsg: "OK"},
+		TagList:  tagList,
+	})
+}
@@ -1,35 +1,26 @@
 package controller
 
 import (
+	"errors"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/service"
 	"github.com/Ljkkun/GreenBeanMiners/util"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
 	"unicode/utf8"
 )
 
-// usersLoginInfo use map to store user info, and key is username+password for demo
-// user data will be cleared every time the server starts
-// test data: username=zhanglei, password=douyin
-var usersLoginInfo = map[string]User{
-	"zhangleidouyin": {
-		Id:            1,
-		Name:          "zhanglei",
-		FollowCount:   10,
-		FollowerCount: 5,
-		IsFollow:      true,
-	},
-}
-
-var userIdSequence = int64(1)
-
 type UserLoginResponse struct {
 	Response
-	UserID uint64 `json:"user_id,omitempty"`
-	Token  string `json:"token"`
+	UserID       uint64 `json:"user_id,omitempty"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
 }
 
 type UserResponse struct {
@@ -43,8 +34,8 @@ func Register(c *gin.Context) {
 	password := c.Query("password")
 	// 验证用户名合法性
 	if utf8.RuneCountInString(username) > global.MAX_USERNAME_LENGTH ||
-		utf8.RuneCountInString(username) <= 0 {
-		c.JSON(200, Response{StatusCode: 1, StatusMsg: "非法用户名"})
+		utf8.RuneCountInString(username) <= 0 || !util.IsValidUsername(username) {
+		c.JSON(200, Response{StatusCode: 1, StatusMsg: "非法用户名，由字母、数字和下划线组成"})
 		return
 	}
 	// 验证密码合法性
@@ -59,16 +50,17 @@ func Register(c *gin.Context) {
 		return
 	}
 	// 生成对应 token
-	tokenString, err := util.GenerateToken(userModel)
+	tokenString, refreshToken, err := service.IssueTokens(userModel)
 	if err != nil {
 		c.JSON(500, Response{StatusCode: 1, StatusMsg: err.Error()})
 		return
 	}
 	// 返回成功并生成响应 json
 	c.JSON(200, UserLoginResponse{
-		Response: Response{StatusCode: 0, StatusMsg: "OK"},
-		UserID:   userModel.UserID,
-		Token:    tokenString,
+		Response:     Response{StatusCode: 0, StatusMsg: "OK"},
+		UserID:       userModel.UserID,
+		Token:        tokenString,
+		RefreshToken: refreshToken,
 	})
 }
 
@@ -83,19 +75,159 @@ func Login(c *gin.Context) {
 		return
 	}
 	// 生成对应 token
-	tokenString, err := util.GenerateToken(userModel)
+	tokenString, refreshToken, err := service.IssueTokens(userModel)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
 		return
 	}
 	// 返回成功并生成响应 json
 	c.JSON(http.StatusOK, UserLoginResponse{
+		Response:     Response{StatusCode: 0, StatusMsg: "OK"},
+		UserID:       userModel.UserID,
+		Token:        tokenString,
+		RefreshToken: refreshToken,
+	})
+}
+
+// RefreshToken 使用 refresh token 换取新的 token
+func RefreshToken(c *gin.Context) {
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		refreshToken = c.Query("refresh_token")
+	}
+	if refreshToken == "" {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "refresh token is requested"})
+		return
+	}
+	claims, tokenString, newRefreshToken, err := service.RefreshTokens(refreshToken)
+	if err != nil {
+		c.JSON(http.StatusForbidden, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, UserLoginResponse{
+		Response:     Response{StatusCode: 0, StatusMsg: "OK"},
+		UserID:       claims.UserID,
+		Token:        tokenString,
+		RefreshToken: newRefreshToken,
+	})
+}
+
+// Logout 退出当前设备，吊销当前 token 及提交的 refresh token
+func Logout(c *gin.Context) {
+	claims := c.MustGet("Claims").(*util.UserClaims)
+	refreshToken := c.PostForm("refresh_token")
+	if refreshToken == "" {
+		refreshToken = c.Query("refresh_token")
+	}
+	if err := service.Logout(claims, refreshToken); err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
+// LogoutAll 退出所有设备，使该用户已签发的 token 全部失效
+func LogoutAll(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	if err := service.LogoutAllDevices(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
+// UserPrivacy 修改账号隐私设置，is_private 为 true 时设为私密账号
+func UserPrivacy(c *gin.Context) {
+	isPrivate, err := strconv.ParseBool(c.Query("is_private"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: "request is invalid"})
+		return
+	}
+	userID := c.GetUint64("UserID")
+	if err = service.SetUserPrivacy(userID, isPrivate); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, Response{StatusCode: 0, StatusMsg: "OK"})
+}
+
+// UserProfile 修改个人资料，可选字段 signature 为个人简介，avatar 和 background_image 为上传的图片
+func UserProfile(c *gin.Context) {
+	userID := c.GetUint64("UserID")
+	var signature *string
+	if value, ok := c.GetPostForm("signature"); ok {
+		if utf8.RuneCountInString(value) > global.MAX_SIGNATURE_LENGTH {
+			c.JSON(http.StatusOK, Response{StatusCode: 1, StatusMsg: "非法个人简介"})
+			return
+		}
+		signature = &value
+	}
+	avatarPath, err := saveProfileImage(c, "avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	backgroundPath, err := saveProfileImage(c, "background_image")
+	if err != nil {
+		if avatarPath != "" {
+			_ = os.Remove(avatarPath)
+		}
+		c.JSON(http.StatusBadRequest, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	if err = service.UpdateUserProfile(userID, signature, avatarPath, backgroundPath); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	userModel, err := service.UserInfoByUserID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
+		return
+	}
+	// 返回成功并生成响应 json
+	c.JSON(http.StatusOK, UserResponse{
 		Response: Response{StatusCode: 0, StatusMsg: "OK"},
-		UserID:   userModel.UserID,
-		Token:    tokenString,
+		User: User{
+			Id:              userModel.UserID,
+			Name:            userModel.Name,
+			FollowCount:     userModel.FollowCount,
+			FollowerCount:   userModel.FollowerCount,
+			TotalFavorited:  userModel.TotalFavorited,
+			FavoriteCount:   userModel.FavoriteCount,
+			IsPrivate:       userModel.IsPrivate,
+			Avatar:          avatarURL(c, userModel.Avatar),
+			BackgroundImage: backgroundURL(c, userModel.BackgroundImage),
+			Signature:       userModel.Signature,
+		},
 	})
 }
 
+// saveProfileImage 将表单中上传的图片保存到临时目录，未上传时返回空路径
+func saveProfileImage(c *gin.Context, field string) (string, error) {
+	data, err := c.FormFile(field)
+	if err == http.ErrMissingFile {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	if data.Size >= global.MAX_IMAGE_SIZE {
+		return "", errors.New("uploaded image should be smaller than 5 MB")
+	}
+	if _, ok := global.WHITELIST_IMAGE[strings.ToLower(path.Ext(data.Filename))]; !ok {
+		return "", errors.New("unsupported image type")
+	}
+	id, err := global.ID_GENERATOR.NextID()
+	if err != nil {
+		return "", err
+	}
+	imagePath := filepath.Join(global.UPLOAD_ADDR, strconv.FormatUint(id, 10)+path.Ext(data.Filename))
+	if err = c.SaveUploadedFile(data, imagePath); err != nil {
+		return "", err
+	}
+	return imagePath, nil
+}
+
 // UserInfo 获取用户信息
 func UserInfo(c *gin.Context) {
 	// 获取指定用户的 ID
@@ -111,8 +243,8 @@ func UserInfo(c *gin.Context) {
 	}
 	// 获取当前用户的 ID
 	viewerID := c.GetUint64("UserID")
-	// 查询当前用户是否关注指定用户
-	isFollow, err := service.GetFollowStatus(viewerID, userID)
+	// 查询当前用户是否关注指定用户，以及是否互相关注
+	isFollowList, isFriendList, err := service.GetFollowAndFriendStatusList(viewerID, []uint64{userID})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{StatusCode: 1, StatusMsg: err.Error()})
 		return
@@ -121,13 +253,18 @@ func UserInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, UserResponse{
 		Response: Response{StatusCode: 0, StatusMsg: "OK"},
 		User: User{
-			Id:             userModel.UserID,
-			Name:           userModel.Name,
-			FollowCount:    userModel.FollowCount,
-			FollowerCount:  userModel.FollowerCount,
-			TotalFavorited: userModel.TotalFavorited,
-			FavoriteCount:  userModel.FavoriteCount,
-			IsFollow:       isFollow,
+			Id:              userModel.UserID,
+			Name:            userModel.Name,
+			FollowCount:     userModel.FollowCount,
+			FollowerCount:   userModel.FollowerCount,
+			TotalFavorited:  userModel.TotalFavorited,
+			FavoriteCount:   userModel.FavoriteCount,
+			IsFollow:        isFollowList[0],
+			IsFriend:        isFriendList[0],
+			IsPrivate:       userModel.IsPrivate,
+			Avatar:          avatarURL(c, userModel.Avatar),
+			BackgroundImage: backgroundURL(c, userModel.BackgroundImage),
+			Signature:       userModel.Signature,
 		},
 	})
 }
@@ -3,6 +3,7 @@ package global
 import (
 	"context"
 	"github.com/Ljkkun/GreenBeanMiners/config"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/go-redis/redis/v8"
 	"github.com/sony/sonyflake"
 	"gorm.io/gorm"
@@ -14,6 +15,7 @@ var (
 	CONFIG               config.System            // 系统配置信息
 	DB                   *gorm.DB                 // 数据库接口
 	REDIS                *redis.Client            // Redis 缓存接口
+	STORAGE              storage.Storage          // 媒体文件存储接口
 	FILE_TYPE_MAP        sync.Map                 // 文件类型映射
 	ID_GENERATOR         *sonyflake.Sonyflake     // 主键生成器
 	CONTEXT              = context.Background()   // 上下文信息
@@ -22,24 +24,114 @@ var (
 	MIN_PASSWORD_PATTERN = "^[_a-zA-Z0-9]{6,32}$" // 密码格式
 	START_TIME           = "2022-05-21 00:00:01"  // 固定启动时间，保证生成 ID 唯一性
 	FEED_NUM             = 30                     // 每次返回视频数量
-	VIDEO_ADDR           = "./public/video/"      // 视频存放位置
-	COVER_ADDR           = "./public/cover/"      // 封面存放位置
+	FANOUT_THRESHOLD     = 1000                   // 粉丝数达到该值的作者不再推送收件箱，改为读时拉取
+	INBOX_MAX_LENGTH     = 1000                   // 关注收件箱保留的视频数量
+	HOT_PUBLISH_WEIGHT   = 10.0                   // 新发布视频的初始热度
+	HOT_FAVORITE_WEIGHT  = 1.0                    // 每个点赞贡献的热度
+	HOT_COMMENT_WEIGHT   = 2.0                    // 每条评论贡献的热度
+	HOT_DECAY_FACTOR     = 0.9                    // 每个衰减周期热度乘以该系数
+	HOT_DECAY_INTERVAL   = time.Hour              // 热度衰减周期
+	HOT_MIN_SCORE        = 0.1                    // 热度低于该值的视频移出热榜
+	HOT_WINDOW           = 7 * 24 * time.Hour     // 重建热榜时只统计该时间内发布的视频
+	HOT_MAX_LENGTH       = 10000                  // 热榜最多保留的视频数量，超出时移除热度最低的视频
+	UPLOAD_ADDR          = "./upload/"            // 上传文件临时存放位置
+	TRANSCODE_QUEUE_SIZE = 1024                   // 转码队列长度
+	TRANSCODE_RESCAN     = time.Minute            // 扫描等待转码视频的周期，转码队列已满时未入队的视频由扫描补充
+	TRANSCODE_MAX_TRIES  = 3                      // 转码失败的视频最多尝试的次数，失败后等待下次扫描重新入队
+	SCHEDULE_INTERVAL    = 10 * time.Second       // 检查定时发布视频的周期
+	MAX_SCHEDULE_AHEAD   = 30 * 24 * time.Hour    // 定时发布时间最多提前设置的时长
+	MEDIA_GC_INTERVAL    = 10 * time.Minute       // 检查待删除媒体文件的周期
+	MEDIA_GC_DELAY       = 24 * time.Hour         // 视频删除后延迟该时长再删除媒体文件，避免正在播放的用户中断
 	MAX_FILE_SIZE        = int64(10 << 20)        // 上传文件大小限制为10MB
+	MAX_IMAGE_SIZE       = int64(5 << 20)         // 上传图片大小限制为5MB
+	AVATAR_SIZE          = 300                    // 头像裁剪后的边长
+	BACKGROUND_WIDTH     = 1080                   // 背景图裁剪后的宽度
+	BACKGROUND_HEIGHT    = 720                    // 背景图裁剪后的高度
+	MAX_SIGNATURE_LENGTH = 100                    // 个人简介最大长度
 	MAX_TITLE_LENGTH     = 140                    // 视频描述最大长度
+	MAX_TAGS_PER_VIDEO   = 10                     // 每个视频最多关联的话题数量
+	MAX_TAG_LENGTH       = 32                     // 话题最大长度
+	TAG_TRENDING_WINDOW  = 24 * time.Hour         // 热门话题统计该时间内发布的视频
+	TRENDING_TAG_NUM     = 20                     // 热门话题返回数量
+	MAX_MENTIONS         = 10                     // 每条视频标题或评论最多提及的用户数量
 	MAX_COMMENT_LENGTH   = 300                    // 评论最大长度
+	REPLY_NUM            = 20                     // 每次返回回复数量
+	PAGE_SIZE            = 20                     // 列表分页默认数量
+	MAX_PAGE_SIZE        = 100                    // 列表分页最大数量
+	MAX_KEYWORD_LENGTH   = 50                     // 搜索关键词最大长度
+	MAX_MESSAGE_LENGTH   = 300                    // 私信最大长度
+	MESSAGE_NUM          = 100                    // 每次返回私信数量
 	WHITELIST_VIDEO      = map[string]bool{".mp4": true, ".avi": true, ".wmv": true, ".mpeg": true,
 		".mov": true, ".flv": true, ".rmvb": true, ".3gb": true, ".vob": true, ".m4v": true}
+	WHITELIST_IMAGE = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".bmp": true,
+		".tif": true, ".tiff": true}
 )
 
 // 过期时间
 var (
 	FAVORITE_EXPIRE       = 10 * time.Minute
+	COMMENT_LIKE_EXPIRE   = 10 * time.Minute
 	VIDEO_COMMENTS_EXPIRE = 10 * time.Minute
 	COMMENT_EXPIRE        = 10 * time.Minute
 	FOLLOW_EXPIRE         = 10 * time.Minute
+	BLOCK_EXPIRE          = 10 * time.Minute
 	USER_INFO_EXPIRE      = 10 * time.Minute
 	VIDEO_EXPIRE          = 10 * time.Minute
 	PUBLISH_EXPIRE        = 10 * time.Minute
+	INBOX_EXPIRE          = 24 * time.Hour
+	TOKEN_VERSION_EXPIRE  = 10 * time.Minute
+	TRENDING_TAGS_EXPIRE  = 5 * time.Minute
 	EMPTY_EXPIRE          = 10 * time.Minute
 	EXPIRE_TIME_JITTER    = 10 * time.Minute
 )
+
+// WebSocket 相关配置
+var (
+	WS_WRITE_WAIT       = 10 * time.Second // 写超时时间
+	WS_PONG_WAIT        = 60 * time.Second // 等待心跳响应的时间
+	WS_PING_PERIOD      = 54 * time.Second // 心跳间隔，需小于 WS_PONG_WAIT
+	WS_MAX_MESSAGE_SIZE = int64(4096)      // 单条消息最大字节数
+)
+
+// Redis 熔断相关配置
+var (
+	CACHE_BREAKER_THRESHOLD  = 5               // 连续失败该次数后熔断，读请求直接查询数据库
+	CACHE_BREAKER_TIMEOUT    = 5 * time.Second // 熔断后经过该时长放行探测请求
+	CACHE_DIRTY_MAX_KEYS     = 100000          // 熔断期间最多记录的待失效 key 数目
+	CACHE_INVALIDATE_BATCH   = 100             // 恢复后每批删除的 key 数目
+	REVOKED_TOKEN_SYNC_BATCH = 500             // 恢复后每批写回缓存的吊销记录数目
+)
+
+// 缓存重建相关配置
+var (
+	CACHE_REBUILD_LOCK_EXPIRE = 3 * time.Second       // 重建锁的过期时间，持有者异常退出时自动释放
+	CACHE_REBUILD_WAIT        = 2 * time.Second       // 未抢到重建锁时最多等待的时长，超时后自行查询数据库
+	CACHE_REBUILD_POLL        = 50 * time.Millisecond // 等待重建完成时检查锁的间隔
+)
+
+// 计数写回相关配置
+var (
+	COUNTER_FLUSH_INTERVAL    = 5 * time.Second // 计数增量写回数据库的周期
+	COUNTER_FLUSH_BATCH       = 500             // 每个事务写回的计数数目
+	COUNTER_FLUSH_LOCK_EXPIRE = time.Minute     // 写回锁的过期时间，多个实例同时运行时每次只由一个实例写回
+)
+
+// 事件总线相关配置
+var (
+	EVENT_STREAM_MAX_LEN = int64(100000)    // Stream 大约保留的事件数目，超出后裁剪最早的事件
+	EVENT_READ_COUNT     = int64(100)       // 每次读取的事件数目
+	EVENT_READ_BLOCK     = 2 * time.Second  // 没有新事件时阻塞等待的时长，读取出错时也等待该时长后重试
+	EVENT_RETRY_INTERVAL = 10 * time.Second // 检查待重试事件的周期
+	EVENT_RETRY_IDLE     = 30 * time.Second // 事件处理失败或消费者异常退出后，经过该时长重新投递
+	EVENT_MAX_DELIVERY   = int64(5)         // 投递达到该次数仍未处理成功的事件移入死信队列
+	EVENT_HANDLED_EXPIRE = 24 * time.Hour   // 已处理事件的去重记录保留时长，需长于事件可能被重新投递的时长
+)
+
+// 发件箱相关配置
+var (
+	OUTBOX_RELAY_INTERVAL = 5 * time.Second  // 检查未执行的发件箱记录的周期
+	OUTBOX_RELAY_DELAY    = 10 * time.Second // 记录写入超过该时长仍未删除时，视为提交后执行失败并重放
+	OUTBOX_RELAY_BATCH    = 100              // 每次重放的记录数目
+	OUTBOX_MAX_ATTEMPTS   = 10               // 重放失败达到该次数的记录不再执行，相关缓存等待过期
+	OUTBOX_EVENT_EXPIRE   = time.Hour        // 记录已发出事件的标记的过期时间，需长于记录可能被重放的时长
+)
@@ -16,9 +16,8 @@ func Global() {
 	global.ID_GENERATOR = sonyflake.NewSonyflake(sonyflake.Settings{
 		StartTime: startTime,
 	})
-	// 创建 video 存放目录
-	util.CheckPathAndCreate(global.VIDEO_ADDR)
-	util.CheckPathAndCreate(global.COVER_ADDR)
+	// 创建上传文件临时存放目录
+	util.CheckPathAndCreate(global.UPLOAD_ADDR)
 	// 创建白名单类型
 	global.FILE_TYPE_MAP.Store("0000002066747970", ".mp4")
 	global.FILE_TYPE_MAP.Store("0000001c66747970", ".mp4")
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/service"
 	_ "github.com/go-sql-driver/mysql"
 	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
@@ -51,12 +52,41 @@ func MySQL() {
 
 	// 自动生成对应的数据库表
 	if global.AUTO_CREATE_DB {
+		// 新增计数列时根据已有数据回填
+		backfill := !global.DB.Migrator().HasColumn(&model.Video{}, "favorite_count") ||
+			!global.DB.Migrator().HasColumn(&model.User{}, "follow_count")
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.User{})
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Video{})
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Message{})
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Comment{})
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Favorite{})
+		// 建立唯一索引前清理重复的点赞记录，建立失败时不能继续运行，否则重复点赞会被重复计数
+		if global.DB.Migrator().HasTable(&model.CommentLike{}) && !global.DB.Migrator().HasIndex(&model.CommentLike{}, "idx_03") {
+			if err := service.DedupCommentLikes(); err != nil {
+				panic("dedup comment likes failed: " + err.Error())
+			}
+		}
+		if err := global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.CommentLike{}); err != nil {
+			panic("migrate comment likes failed: " + err.Error())
+		}
 		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Follow{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Block{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Mute{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.FollowRequest{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Tag{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.VideoTag{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Mention{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Notification{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.NotificationActor{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.Outbox{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.CounterFlush{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.HandledEvent{})
+		global.DB.Set("gorm:table_options", "ENGINE=InnoDB").AutoMigrate(&model.RevokedToken{})
+		if backfill {
+			if err := service.BackfillCounters(); err != nil {
+				panic("backfill counters failed: " + err.Error())
+			}
+		}
 	}
 
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/service"
 	"github.com/go-redis/redis/v8"
+	"log"
 )
 
 func Redis() {
@@ -14,13 +15,17 @@ func Redis() {
 		DB:       global.CONFIG.RedisConfig.DB,
 		PoolSize: global.CONFIG.RedisConfig.PoolSize,
 	})
+	// Redis 不可用时熔断，读操作直接查询数据库，恢复后自动切回缓存
+	rdb.AddHook(service.CacheBreakerHook{})
+	global.REDIS = rdb
+	service.StartCacheProbe()
 	// 检查 Redis 连通性
 	if _, err := rdb.Ping(global.CONTEXT).Result(); err != nil {
-		panic(err.Error())
+		log.Println("redis is unavailable, falling back to mysql:", err)
+		return
 	}
-	global.REDIS = rdb
 	// 主动查询 feed，导入缓存
 	if err := service.GoFeed(); err != nil {
-		panic(err.Error())
+		log.Println("load feed into redis failed:", err)
 	}
 }
@@ -5,25 +5,35 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/controller"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/middleware"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/gin-gonic/gin"
 )
 
 func Router() {
 	r := gin.Default()
-	// 静态文件存放目录
-	r.Static("/public", "./public")
+	// 使用本地存储时，由 Gin 提供静态文件访问
+	if local, ok := global.STORAGE.(*storage.Local); ok {
+		r.Static(local.URLPrefix, local.Root)
+	}
 
 	apiRouter := r.Group("/douyin")
 
 	// basic apis
 	apiRouter.GET("/feed/", controller.Feed)
+	apiRouter.GET("/feed/hot/", controller.HotFeed)
 	apiRouter.POST("/user/register/", controller.Register)
 	apiRouter.POST("/user/login/", controller.Login)
+	apiRouter.POST("/user/refresh/", controller.RefreshToken)
 	apiRouter.GET("/publish/list/", controller.PublishList)
+	apiRouter.GET("/search/video/", controller.SearchVideo)
+	apiRouter.GET("/search/user/", controller.SearchUser)
+	apiRouter.GET("/tag/video/list/", controller.TagVideoList)
+	apiRouter.GET("/tag/trending/", controller.TrendingTags)
 
 	// extra apis - I
 	apiRouter.GET("/favorite/list/", controller.FavoriteList)
 	apiRouter.GET("/comment/list/", controller.CommentList)
+	apiRouter.GET("/comment/reply/list/", controller.CommentReplyList)
 
 	// extra apis - II
 	apiRouter.GET("/relation/follow/list/", controller.FollowList)
@@ -35,13 +45,34 @@ func Router() {
 	{
 		// basic apis
 		authed.GET("/user/", controller.UserInfo)
+		authed.POST("/user/logout/", controller.Logout)
+		authed.POST("/user/logout/all/", controller.LogoutAll)
+		authed.POST("/user/privacy/", controller.UserPrivacy)
+		authed.POST("/user/profile/", controller.UserProfile)
+		authed.POST("/publish/delete/", controller.PublishDelete)
+		authed.POST("/publish/update/", controller.PublishUpdate)
 
 		// extra apis - I
 		authed.POST("/favorite/action/", controller.FavoriteAction)
 		authed.POST("/comment/action/", controller.CommentAction)
+		authed.POST("/comment/like/action/", controller.CommentLikeAction)
 
 		// extra apis - II
 		authed.POST("/relation/action/", controller.RelationAction)
+		authed.GET("/relation/friend/list/", controller.FriendList)
+		authed.POST("/relation/block/action/", controller.BlockAction)
+		authed.GET("/relation/block/list/", controller.BlockList)
+		authed.POST("/relation/mute/action/", controller.MuteAction)
+		authed.GET("/relation/mute/list/", controller.MuteList)
+		authed.GET("/relation/request/list/", controller.FollowRequestList)
+		authed.POST("/relation/request/action/", controller.FollowRequestAction)
+		authed.POST("/message/action/", controller.MessageAction)
+		authed.GET("/message/chat/", controller.MessageChat)
+		authed.GET("/message/ws/", controller.MessageWebSocket)
+		authed.GET("/mention/list/", controller.MentionList)
+		authed.GET("/notification/list/", controller.NotificationList)
+		authed.GET("/notification/unread/", controller.NotificationUnreadCount)
+		authed.POST("/notification/read/", controller.NotificationRead)
 	}
 
 	// 用户权限校验
@@ -0,0 +1,27 @@
+package initialize
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
+)
+
+func Storage() {
+	storageConfig := global.CONFIG.StorageConfig
+	switch storageConfig.Type {
+	case "s3":
+		s3, err := storage.NewS3(storageConfig.Endpoint, storageConfig.Region, storageConfig.AccessKey,
+			storageConfig.SecretKey, storageConfig.Bucket, storageConfig.BaseURL, storageConfig.UseSSL,
+			storageConfig.PublicRead, storageConfig.URLExpire)
+		if err != nil {
+			panic(err.Error())
+		}
+		global.STORAGE = s3
+	default:
+		// 默认使用本地存储，通过 /public 路由访问
+		local, err := storage.NewLocal(storageConfig.Root, "/public", storageConfig.BaseURL)
+		if err != nil {
+			panic(err.Error())
+		}
+		global.STORAGE = local
+	}
+}
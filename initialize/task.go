@@ -0,0 +1,26 @@
+package initialize
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+)
+
+// Task 启动后台任务
+func Task() {
+	// 启动转码协程
+	if err := service.StartTranscodeWorkers(global.CONFIG.TranscodeConfig.Workers); err != nil {
+		panic(err.Error())
+	}
+	// 启动定时发布协程
+	service.StartScheduledPublish()
+	// 启动媒体文件回收协程
+	service.StartMediaGC()
+	// 启动热度衰减协程
+	service.StartHotDecay()
+	// 启动计数写回协程
+	service.StartCounterFlush()
+	// 启动事件消费协程
+	service.StartEventConsumers()
+	// 启动发件箱中继协程
+	service.StartOutboxRelay()
+}
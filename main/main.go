@@ -5,11 +5,13 @@ import (
 )
 
 func main() {
-	initialize.Global() // 初始化全局变量
-	initialize.Viper()  // 初始化配置信息
-	initialize.MySQL()  // 初始化 MySQL 连接
-	initialize.Redis()  // 初始化 Redis 连接
-	initialize.Router() // 初始化 GinRouter
+	initialize.Global()  // 初始化全局变量
+	initialize.Viper()   // 初始化配置信息
+	initialize.MySQL()   // 初始化 MySQL 连接
+	initialize.Redis()   // 初始化 Redis 连接
+	initialize.Storage() // 初始化媒体文件存储
+	initialize.Task()    // 启动后台任务
+	initialize.Router()  // 初始化 GinRouter
 	//http.Handle("/public/", http.StripPrefix("/cover/", http.FileServer(http.Dir("./png"))))
 	//http.Handle("/public/", http.StripPrefix("/video/", http.FileServer(http.Dir("./mp4"))))
 }
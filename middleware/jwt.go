@@ -2,7 +2,7 @@ package middleware
 
 import (
 	"github.com/Ljkkun/GreenBeanMiners/controller"
-	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/Ljkkun/GreenBeanMiners/service"
 	"github.com/gin-gonic/gin"
 	"net/http"
 )
@@ -20,7 +20,8 @@ func JWT() gin.HandlerFunc {
 			return
 		}
 
-		claims, err := util.ParseToken(tokenString)
+		// 校验签名、类型，以及是否已被吊销
+		claims, err := service.ParseAccessToken(tokenString)
 		if err != nil {
 			c.JSON(http.StatusForbidden, controller.Response{StatusCode: 1, StatusMsg: err.Error()})
 			c.Abort()
@@ -30,6 +31,8 @@ func JWT() gin.HandlerFunc {
 
 		// 保存 userID 到 Context的 key 中，可以通过Get()取
 		c.Set("UserID", userID)
+		// 保存 claims，供退出登录时吊销当前 token
+		c.Set("Claims", claims)
 
 		// 执行函数
 		c.Next()
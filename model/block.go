@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// Block 拉黑关系，被拉黑的用户不能关注、评论或私信拉黑者
+type Block struct {
+	BlockID   uint64    `gorm:"column:block_id;primary_key;NOT NULL"`
+	UserID    uint64    `gorm:"column:user_id;NOT NULL;index:idx_01,priority:1"`
+	BlockedID uint64    `gorm:"column:blocked_id;NOT NULL;index:idx_01,priority:2;index:idx_02"`
+	IsBlock   bool      `gorm:"column:is_block;NOT NULL"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
+
+// Mute 屏蔽关系，被屏蔽用户的视频和评论不再展示给屏蔽者
+type Mute struct {
+	MuteID    uint64    `gorm:"column:mute_id;primary_key;NOT NULL"`
+	UserID    uint64    `gorm:"column:user_id;NOT NULL;index:idx_01,priority:1"`
+	MutedID   uint64    `gorm:"column:muted_id;NOT NULL;index:idx_01,priority:2"`
+	IsMute    bool      `gorm:"column:is_mute;NOT NULL"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+	UpdatedAt time.Time `gorm:"column:updated_at"`
+}
@@ -6,10 +6,14 @@ import (
 )
 
 type Comment struct {
-	CommentID uint64         `gorm:"column:comment_id;primary_key;NOT NULL" redis:"-"`
-	VideoID   uint64         `gorm:"column:video_id;index:video_user,priority:1;NOT NULL" redis:"video_id"`
-	UserID    uint64         `gorm:"column:user_id;index:video_user,priority:2;NOT NULL" redis:"user_id"`
-	Content   string         `gorm:"content:content;NOT NULL" redis:"content"`
-	CreatedAt time.Time      `gorm:"column:created_at" redis:"-"`
-	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at" redis:"-"`
+	CommentID     uint64         `gorm:"column:comment_id;primary_key;NOT NULL" redis:"-"`
+	VideoID       uint64         `gorm:"column:video_id;index:video_user,priority:1;NOT NULL" redis:"video_id"`
+	UserID        uint64         `gorm:"column:user_id;index:video_user,priority:2;NOT NULL" redis:"user_id"`
+	Content       string         `gorm:"content:content;NOT NULL" redis:"content"`
+	ParentID      uint64         `gorm:"column:parent_id;index:parent_comment;NOT NULL;default:0" redis:"parent_id"` // 所属顶层评论 ID，顶层评论为 0
+	ReplyToUserID uint64         `gorm:"column:reply_to_user_id;NOT NULL;default:0" redis:"reply_to_user_id"`        // 被回复的用户 ID
+	ReplyCount    int64          `gorm:"column:reply_count;NOT NULL;default:0" redis:"reply_count"`                  // 顶层评论的回复数目
+	LikeCount     int64          `gorm:"column:like_count;NOT NULL;default:0" redis:"like_count"`                    // 评论的点赞数目
+	CreatedAt     time.Time      `gorm:"column:created_at" redis:"-"`
+	DeletedAt     gorm.DeletedAt `gorm:"column:deleted_at" redis:"-"`
 }
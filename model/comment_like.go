@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+type CommentLike struct {
+	CommentLikeID uint64    `gorm:"column:comment_like_id;primary_key;NOT NULL"`
+	CommentID     uint64    `gorm:"column:comment_id;NOT NULL;uniqueIndex:idx_03,priority:2;index:idx_02"`
+	UserID        uint64    `gorm:"column:user_id;NOT NULL;uniqueIndex:idx_03,priority:1"` // 每个用户对每条评论只有一条记录
+	IsLike        bool      `gorm:"column:is_like;NOT NULL"`
+	CreatedAt     time.Time `gorm:"column:created_at"`
+	UpdatedAt     time.Time `gorm:"column:updated_at"`
+}
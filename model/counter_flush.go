@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// CounterFlush 已写回数据库的计数增量，与计数列在同一事务中写入
+// 写回后未能删除 Redis 中的增量时，重试会跳过已有记录的行，避免重复写回
+type CounterFlush struct {
+	FlushID   uint64    `gorm:"column:flush_id;primary_key;NOT NULL"` // 一次写回的编号，每个计数列的每次写回各不相同
+	RowID     uint64    `gorm:"column:row_id;primary_key;NOT NULL"`   // 写回的行
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+)
+
+// 关注申请状态
+const (
+	FollowRequestPending  int8 = 0 // 等待私密账号的主人处理
+	FollowRequestApproved int8 = 1 // 已同意，关注关系已建立
+	FollowRequestRejected int8 = 2 // 已拒绝
+)
+
+type FollowRequest struct {
+	RequestID   uint64    `gorm:"column:request_id;primary_key;NOT NULL"`
+	CelebrityID uint64    `gorm:"column:celebrity_id;NOT NULL;index:idx_01,priority:1;uniqueIndex:idx_03,priority:2"`
+	FollowerID  uint64    `gorm:"column:follower_id;NOT NULL;uniqueIndex:idx_03,priority:1"` // 每对用户只保留一条申请记录
+	Status      int8      `gorm:"column:status;NOT NULL;index:idx_01,priority:2"`
+	CreatedAt   time.Time `gorm:"column:created_at"`
+	UpdatedAt   time.Time `gorm:"column:updated_at"`
+}
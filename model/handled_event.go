@@ -0,0 +1,12 @@
+package model
+
+import (
+	"time"
+)
+
+// HandledEvent 消费者已处理的事件，与处理结果在同一事务中写入，重复投递的事件不再处理
+type HandledEvent struct {
+	GroupName string    `gorm:"column:group_name;primary_key;size:64;NOT NULL"` // 消费者组
+	EventID   string    `gorm:"column:event_id;primary_key;size:64;NOT NULL"`   // 事件流中的消息 ID
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+)
+
+// Mention 视频标题或评论中 @用户名 提及的用户，CommentID 为 0 表示在视频标题中提及
+type Mention struct {
+	MentionID  uint64    `gorm:"column:mention_id;primary_key;NOT NULL"`
+	UserID     uint64    `gorm:"column:user_id;NOT NULL;index:idx_01"` // 被提及的用户
+	UserName   string    `gorm:"column:user_name;NOT NULL"`            // 被提及的用户名，用于客户端渲染链接
+	FromUserID uint64    `gorm:"column:from_user_id;NOT NULL"`
+	VideoID    uint64    `gorm:"column:video_id;NOT NULL;index:idx_02,priority:1"`
+	CommentID  uint64    `gorm:"column:comment_id;NOT NULL;default:0;index:idx_02,priority:2;index:idx_03"`
+	CreatedAt  time.Time `gorm:"column:created_at"`
+}
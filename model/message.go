@@ -5,10 +5,11 @@ import (
 )
 
 type Message struct {
-	FollowID   uint64    `gorm:"column:id;primary_key;NOT NULL" redis:"-"`
-	ToUserID   uint64    `gorm:"column:to_user_id;NOT NULL" redis:"to_user_id"`
-	FromUserID uint64    `gorm:"column:from_user_id;NOT NULL" redis:"from_user_id"`
-	Content    string    `gorm:"column:content;NOT NULL" redis:"content"`
-	CreatedAt  time.Time `gorm:"column:id;create_at;NOT NULL" redis:"-"`
-	UpdatedAt  time.Time `gorm:"column:id;update_at;NOT NULL" redis:"-"`
+	MessageID   uint64    `gorm:"column:id;primary_key;NOT NULL" redis:"-"`
+	ToUserID    uint64    `gorm:"column:to_user_id;NOT NULL;index:idx_chat,priority:2;index:idx_offline,priority:1" redis:"to_user_id"`
+	FromUserID  uint64    `gorm:"column:from_user_id;NOT NULL;index:idx_chat,priority:1" redis:"from_user_id"`
+	Content     string    `gorm:"column:content;NOT NULL" redis:"content"`
+	IsDelivered bool      `gorm:"column:is_delivered;NOT NULL;default:false;index:idx_offline,priority:2" redis:"-"`
+	CreatedAt   time.Time `gorm:"column:created_at;NOT NULL;index:idx_chat,priority:3" redis:"-"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;NOT NULL" redis:"-"`
 }
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+)
+
+// 通知类型
+const (
+	NotificationTypeFavorite int8 = 1 // 视频被点赞，TargetID 为视频 ID
+	NotificationTypeComment  int8 = 2 // 视频被评论，TargetID 为视频 ID
+	NotificationTypeReply    int8 = 3 // 评论被回复，TargetID 为顶层评论 ID
+	NotificationTypeFollow   int8 = 4 // 被关注，TargetID 为 0
+	NotificationTypeMention  int8 = 5 // 被提及，TargetID 为提及 ID，不聚合
+)
+
+// Notification 用户收到的通知，同一类型、同一对象的未读通知聚合为一条，如“12 人赞了你的视频”
+// Unread 为 true 表示未读，已读时置为 NULL，使唯一索引只约束未读通知
+type Notification struct {
+	NotificationID uint64    `gorm:"column:notification_id;primary_key;NOT NULL"`
+	UserID         uint64    `gorm:"column:user_id;NOT NULL;uniqueIndex:idx_01,priority:1;index:idx_03"` // 接收通知的用户
+	Type           int8      `gorm:"column:type;NOT NULL;uniqueIndex:idx_01,priority:2"`
+	TargetID       uint64    `gorm:"column:target_id;NOT NULL;default:0;uniqueIndex:idx_01,priority:3"`
+	Unread         *bool     `gorm:"column:unread;uniqueIndex:idx_01,priority:4"`
+	VideoID        uint64    `gorm:"column:video_id;NOT NULL;default:0;index:idx_04"` // 相关视频，被关注时为 0
+	LastActorID    uint64    `gorm:"column:last_actor_id;NOT NULL"`                   // 最近一次触发通知的用户
+	ActorCount     int64     `gorm:"column:actor_count;NOT NULL;default:1"`           // 聚合的不同触发者数目
+	CreatedAt      time.Time `gorm:"column:created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at"`
+}
+
+// NotificationActor 聚合到通知上的触发者，每个用户在同一条通知上只记录一次
+type NotificationActor struct {
+	NotificationID uint64    `gorm:"column:notification_id;primary_key;NOT NULL"`
+	ActorID        uint64    `gorm:"column:actor_id;primary_key;NOT NULL"`
+	CreatedAt      time.Time `gorm:"column:created_at"`
+}
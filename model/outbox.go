@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// Outbox 发件箱，与业务数据在同一事务中写入，记录提交后需要执行的缓存操作和领域事件
+// 执行成功后删除；未删除的记录由中继协程重放
+type Outbox struct {
+	OutboxID  uint64    `gorm:"column:outbox_id;primary_key;NOT NULL"`
+	Type      string    `gorm:"column:type;NOT NULL"`               // 与领域事件类型一致
+	Payload   string    `gorm:"column:payload;type:text;NOT NULL"`  // JSON 格式的操作参数
+	Attempts  int       `gorm:"column:attempts;NOT NULL;default:0"` // 重放失败的次数
+	CreatedAt time.Time `gorm:"column:created_at;index"`
+}
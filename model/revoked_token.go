@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// RevokedToken 已吊销的 token，Redis 中的吊销列表是它的缓存，Redis 不可用时查询此表
+type RevokedToken struct {
+	TokenID   string    `gorm:"column:token_id;primary_key;size:64;NOT NULL"` // token 的 jti
+	UserID    uint64    `gorm:"column:user_id;NOT NULL"`
+	ExpiresAt time.Time `gorm:"column:expires_at;index"` // token 自然过期的时间，之后可以删除
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
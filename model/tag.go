@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Tag 话题，由视频标题中的 #话题 解析得到
+type Tag struct {
+	TagID     uint64    `gorm:"column:tag_id;primary_key;NOT NULL"`
+	Name      string    `gorm:"column:name;size:64;NOT NULL;uniqueIndex"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+// VideoTag 视频与话题的关联
+type VideoTag struct {
+	VideoTagID uint64    `gorm:"column:video_tag_id;primary_key;NOT NULL"`
+	TagID      uint64    `gorm:"column:tag_id;NOT NULL;uniqueIndex:idx_01,priority:1"`
+	VideoID    uint64    `gorm:"column:video_id;NOT NULL;uniqueIndex:idx_01,priority:2;index:idx_02"`
+	CreatedAt  time.Time `gorm:"column:created_at"`
+}
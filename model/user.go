@@ -5,15 +5,20 @@ import (
 )
 
 type User struct {
-	UserID         uint64    `gorm:"column:id;primary_key;NOT NULL" redis:"user_id"`
-	Name           string    `gorm:"column:name;NOT NULL" redis:"name"`
-	Password       string    `gorm:"column:password;NOT NULL" redis:"password"`
-	FollowCount    int64     `gorm:"-" redis:"follow_count"`
-	FollowerCount  int64     `gorm:"-" redis:"follower_count"`
-	IsFollower     int64     `gorm:"-" redis:"is_follower"`
-	TotalFavorited int64     `gorm:"-" redis:"total_forvited"`
-	WorkCount      int64     `gorm:"-" redis:"work_count"`
-	FavoriteCount  int64     `gorm:"-" redis:"favorite_count"`
-	CreatedAt      time.Time `gorm:"column:created_at" redis:"-"`
-	ExtInfo        *string   `gorm:"column:ext_info" redis:"-"`
+	UserID          uint64    `gorm:"column:id;primary_key;NOT NULL" redis:"user_id"`
+	Name            string    `gorm:"column:name;NOT NULL;index:idx_name_fulltext,class:FULLTEXT,option:WITH PARSER ngram" redis:"name"`
+	Password        string    `gorm:"column:password;NOT NULL" redis:"password"`
+	FollowCount     int64     `gorm:"column:follow_count;NOT NULL;default:0" redis:"follow_count"` // 计数列由写回协程批量更新，可能落后于缓存
+	FollowerCount   int64     `gorm:"column:follower_count;NOT NULL;default:0" redis:"follower_count"`
+	IsFollower      int64     `gorm:"-" redis:"is_follower"`
+	TotalFavorited  int64     `gorm:"column:total_favorited;NOT NULL;default:0" redis:"total_favorited"`
+	WorkCount       int64     `gorm:"-" redis:"work_count"`
+	FavoriteCount   int64     `gorm:"column:favorite_count;NOT NULL;default:0" redis:"favorite_count"`
+	Avatar          string    `gorm:"column:avatar;NOT NULL;default:''" redis:"avatar"`                     // 头像在存储中的文件名
+	BackgroundImage string    `gorm:"column:background_image;NOT NULL;default:''" redis:"background_image"` // 背景图在存储中的文件名
+	Signature       string    `gorm:"column:signature;NOT NULL;default:''" redis:"signature"`               // 个人简介
+	IsPrivate       bool      `gorm:"column:is_private;NOT NULL;default:false" redis:"is_private"`          // 私密账号的作品、点赞和关注列表只对已关注的用户可见
+	TokenVersion    int64     `gorm:"column:token_version;NOT NULL;default:0" redis:"-"`                    // 退出所有设备时递增，使已签发的 token 失效
+	CreatedAt       time.Time `gorm:"column:created_at" redis:"-"`
+	ExtInfo         *string   `gorm:"column:ext_info" redis:"-"`
 }
@@ -1,17 +1,42 @@
 package model
 
-import "time"
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// 视频转码状态，已有数据默认为 VideoStatusReady
+const (
+	VideoStatusReady      int8 = 0 // 转码完成，可以播放
+	VideoStatusPending    int8 = 1 // 等待转码
+	VideoStatusProcessing int8 = 2 // 转码中
+	VideoStatusFailed     int8 = 3 // 转码失败
+	VideoStatusScheduled  int8 = 4 // 转码完成，等待定时发布
+)
+
+// 视频可见范围，已有数据默认为 VideoVisibilityPublic
+const (
+	VideoVisibilityPublic    int8 = 0 // 所有人可见，出现在视频流和热榜中
+	VideoVisibilityFollowers int8 = 1 // 仅粉丝可见，只推送到粉丝的关注流
+	VideoVisibilityPrivate   int8 = 2 // 仅自己可见
+)
 
 type Video struct {
-	VideoID       uint64    `gorm:"column:video_id;primary_key;NOT NULL" redis:"-"`
-	Title         string    `gorm:"column:title;NOT NULL" redis:"title"`
-	AuthorID      uint64    `gorm:"column:author_id;index;NOT NULL" redis:"author_id"`
-	PlayName      string    `gorm:"column:play_name;NOT NULL" redis:"play_name"`
-	CoverName     string    `gorm:"column:cover_name;NOT NULL" redis:"cover_name"`
-	FavoriteCount int64     `gorm:"-" redis:"favorite_count"`
-	CommentCount  int64     `gorm:"-" redis:"comment_count"`
-	CreatedAt     time.Time `gorm:"column:created_at;index" redis:"-"`
-	ExtInfo       *string   `gorm:"column:ext_info" redis:"-"`
+	VideoID        uint64         `gorm:"column:video_id;primary_key;NOT NULL" redis:"-"`
+	Title          string         `gorm:"column:title;NOT NULL;index:idx_title_fulltext,class:FULLTEXT,option:WITH PARSER ngram" redis:"title"` // 全文索引使用 ngram 分词，支持中文搜索
+	AuthorID       uint64         `gorm:"column:author_id;index;NOT NULL" redis:"author_id"`
+	PlayName       string         `gorm:"column:play_name;NOT NULL" redis:"play_name"`
+	CoverName      string         `gorm:"column:cover_name;NOT NULL" redis:"cover_name"`
+	SourceName     string         `gorm:"column:source_name;NOT NULL;default:''" redis:"-"` // 上传的原始文件名，转码完成后删除
+	Status         int8           `gorm:"column:status;NOT NULL;default:0;index" redis:"-"`
+	TranscodeTries int8           `gorm:"column:transcode_tries;NOT NULL;default:0" redis:"-"` // 转码失败的次数，达到上限后不再重试
+	Visibility     int8           `gorm:"column:visibility;NOT NULL;default:0" redis:"visibility"`
+	PublishAt      *time.Time     `gorm:"column:publish_at;index" redis:"-"`                               // 定时发布时间，为空表示转码完成后立即发布
+	FavoriteCount  int64          `gorm:"column:favorite_count;NOT NULL;default:0" redis:"favorite_count"` // 计数列由写回协程批量更新，可能落后于缓存
+	CommentCount   int64          `gorm:"column:comment_count;NOT NULL;default:0" redis:"comment_count"`
+	CreatedAt      time.Time      `gorm:"column:created_at;index" redis:"-"`
+	ExtInfo        *string        `gorm:"column:ext_info" redis:"-"`
+	DeletedAt      gorm.DeletedAt `gorm:"column:deleted_at;index" redis:"-"`
 }
 
 type VideoCount struct {
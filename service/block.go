@@ -0,0 +1,356 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"gorm.io/gorm"
+)
+
+// ErrUserBlocked 双方存在拉黑关系时，关注、评论和私信等操作返回该错误
+var ErrUserBlocked = errors.New("user has been blocked")
+
+// GetBlockStatusForUpdate 获取拉黑状态，此处是针对 AddBlock 和 CancelBlock
+func GetBlockStatusForUpdate(userID, blockedID uint64) (bool, error) {
+	// 查询缓存
+	blockStatus, err := GetBlockStatusFromRedis(userID, blockedID)
+	if err == nil {
+		return blockStatus, nil
+	} else if !isCacheMiss(err) {
+		return false, err
+	}
+	// 缓存不存在，查询数据库
+	blockList, err := goBlockList(userID)
+	if err != nil {
+		return false, err
+	}
+	// 根据查询结果判断，Redis 不可用时同样适用
+	for _, each := range blockList {
+		if each.BlockedID == blockedID {
+			return each.IsBlock, nil
+		}
+	}
+	return false, errors.New("no tracking information")
+}
+
+// GetBlockStatus 获取拉黑状态，此处是针对非更新操作
+func GetBlockStatus(userID, blockedID uint64) (bool, error) {
+	blockStatus, err := GetBlockStatusForUpdate(userID, blockedID)
+	if err == nil || err.Error() == "no tracking information" {
+		return blockStatus, nil
+	}
+	return false, err
+}
+
+// CheckBlocked 检查两个用户之间是否有一方拉黑了另一方，存在拉黑关系时返回 ErrUserBlocked
+func CheckBlocked(userID, otherID uint64) error {
+	for _, pair := range [][2]uint64{{userID, otherID}, {otherID, userID}} {
+		isBlock, err := GetBlockStatus(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if isBlock {
+			return ErrUserBlocked
+		}
+	}
+	return nil
+}
+
+// AddBlock 拉黑，同时在同一事务中删除双方的关注关系和尚未处理的关注申请
+func AddBlock(userID, blockedID uint64) error {
+	if userID == blockedID {
+		return errors.New("can not block yourself")
+	}
+	// 获取当前拉黑状态
+	isBlock, err := GetBlockStatusForUpdate(userID, blockedID)
+	if err == nil && isBlock {
+		return nil
+	} else if err != nil && err.Error() != "no tracking information" {
+		return err
+	}
+	hasRecord := err == nil
+	// 两个方向上被删除的关注关系，依次为 blockedID 关注 userID、userID 关注 blockedID
+	pairList := [][2]uint64{{blockedID, userID}, {userID, blockedID}}
+	removedList := make([]bool, len(pairList))
+	if err = global.DB.Transaction(func(tx *gorm.DB) error {
+		if hasRecord {
+			// 数据库有记录，修改数据库
+			if err := tx.Model(&model.Block{}).Where("user_id = ? and blocked_id = ?", userID, blockedID).
+				Update("is_block", true).Error; err != nil {
+				return err
+			}
+		} else {
+			var block model.Block
+			// 数据库没有记录，写入数据库
+			block.BlockID, _ = global.ID_GENERATOR.NextID()
+			block.UserID = userID
+			block.BlockedID = blockedID
+			block.IsBlock = true
+			if err := tx.Create(&block).Error; err != nil {
+				return err
+			}
+		}
+		// 解除双方的关注关系，没有关注时不做处理
+		for i, pair := range pairList {
+			result := tx.Where("follower_id = ? and celebrity_id = ? and is_follow = ?", pair[0], pair[1], true).
+				Delete(&model.Follow{})
+			if result.Error != nil {
+				return result.Error
+			}
+			removedList[i] = result.RowsAffected > 0
+			if err := tx.Where("follower_id = ? and celebrity_id = ? and status = ?", pair[0], pair[1], model.FollowRequestPending).
+				Delete(&model.FollowRequest{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	// 更新缓存
+	if err = UpdateBlockForRedis(userID, blockedID, true); err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	// 只处理确实被删除的关注关系
+	for i, pair := range pairList {
+		if !removedList[i] {
+			continue
+		}
+		if err = removeFollow(pair[0], pair[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CancelBlock 取消拉黑
+func CancelBlock(userID, blockedID uint64) error {
+	// 获取当前拉黑状态
+	if isBlock, err := GetBlockStatusForUpdate(userID, blockedID); err == nil {
+		if !isBlock {
+			return nil
+		}
+		// 修改数据库
+		if err := global.DB.Model(&model.Block{}).Where("user_id = ? and blocked_id = ?", userID, blockedID).
+			Update("is_block", false).Error; err != nil {
+			return err
+		}
+	} else if err.Error() == "no tracking information" {
+		return nil
+	} else {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(UpdateBlockForRedis(userID, blockedID, false))
+}
+
+// GetBlockIDListByUserID 通过用户 ID 查询拉黑的用户 ID 列表
+func GetBlockIDListByUserID(userID uint64) ([]uint64, error) {
+	// 查询缓存
+	blockedIDList, err := GetBlockIDListByUserIDFromRedis(userID)
+	if err == nil {
+		return blockedIDList, nil
+	} else if !isCacheMiss(err) {
+		return nil, err
+	}
+	// 缓存不存在，查询数据库
+	blockList, err := goBlockList(userID)
+	if err != nil {
+		return nil, err
+	}
+	// 后续操作，返回拉黑 ID 列表
+	blockedIDList = make([]uint64, 0, len(blockList))
+	for _, each := range blockList {
+		if each.IsBlock {
+			blockedIDList = append(blockedIDList, each.BlockedID)
+		}
+	}
+	return blockedIDList, nil
+}
+
+// GetBlockListByUserID 获取用户拉黑列表
+func GetBlockListByUserID(userID uint64) ([]model.User, error) {
+	blockedIDList, err := GetBlockIDListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserListByUserIDList(blockedIDList)
+}
+
+// goBlockList 查询用户的拉黑记录并写入缓存
+func goBlockList(userID uint64) ([]model.Block, error) {
+	var blockList []model.Block
+	if result := global.DB.Select("blocked_id", "is_block").Model(&model.Block{}).
+		Where("user_id = ?", userID).Find(&blockList); result.Error != nil {
+		return nil, result.Error
+	}
+	// 更新缓存
+	if err := AddBlockIDListByUserIDToRedis(userID, blockList); err != nil && err != ErrCacheUnavailable {
+		return nil, err
+	}
+	return blockList, nil
+}
+
+// GetMuteStatusForUpdate 获取屏蔽状态，此处是针对 AddMute 和 CancelMute
+func GetMuteStatusForUpdate(userID, mutedID uint64) (bool, error) {
+	// 查询缓存
+	muteStatus, err := GetMuteStatusFromRedis(userID, mutedID)
+	if err == nil {
+		return muteStatus, nil
+	} else if !isCacheMiss(err) {
+		return false, err
+	}
+	// 缓存不存在，查询数据库
+	muteList, err := goMuteList(userID)
+	if err != nil {
+		return false, err
+	}
+	// 根据查询结果判断，Redis 不可用时同样适用
+	for _, each := range muteList {
+		if each.MutedID == mutedID {
+			return each.IsMute, nil
+		}
+	}
+	return false, errors.New("no tracking information")
+}
+
+// AddMute 屏蔽，被屏蔽用户的视频和评论不再出现在视频流和评论列表中
+func AddMute(userID, mutedID uint64) error {
+	if userID == mutedID {
+		return errors.New("can not mute yourself")
+	}
+	// 获取当前屏蔽状态
+	if isMute, err := GetMuteStatusForUpdate(userID, mutedID); err == nil {
+		if isMute {
+			return nil
+		}
+		// 数据库有记录，修改数据库
+		if err := global.DB.Model(&model.Mute{}).Where("user_id = ? and muted_id = ?", userID, mutedID).
+			Update("is_mute", true).Error; err != nil {
+			return err
+		}
+	} else if err.Error() == "no tracking information" {
+		var mute model.Mute
+		// 数据库没有记录，写入数据库
+		mute.MuteID, _ = global.ID_GENERATOR.NextID()
+		mute.UserID = userID
+		mute.MutedID = mutedID
+		mute.IsMute = true
+		if err := global.DB.Create(&mute).Error; err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(UpdateMuteForRedis(userID, mutedID, true))
+}
+
+// CancelMute 取消屏蔽
+func CancelMute(userID, mutedID uint64) error {
+	// 获取当前屏蔽状态
+	if isMute, err := GetMuteStatusForUpdate(userID, mutedID); err == nil {
+		if !isMute {
+			return nil
+		}
+		// 修改数据库
+		if err := global.DB.Model(&model.Mute{}).Where("user_id = ? and muted_id = ?", userID, mutedID).
+			Update("is_mute", false).Error; err != nil {
+			return err
+		}
+	} else if err.Error() == "no tracking information" {
+		return nil
+	} else {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(UpdateMuteForRedis(userID, mutedID, false))
+}
+
+// GetMuteIDListByUserID 通过用户 ID 查询屏蔽的用户 ID 列表
+func GetMuteIDListByUserID(userID uint64) ([]uint64, error) {
+	// 查询缓存
+	mutedIDList, err := GetMuteIDListByUserIDFromRedis(userID)
+	if err == nil {
+		return mutedIDList, nil
+	} else if !isCacheMiss(err) {
+		return nil, err
+	}
+	// 缓存不存在，查询数据库
+	muteList, err := goMuteList(userID)
+	if err != nil {
+		return nil, err
+	}
+	// 后续操作，返回屏蔽 ID 列表
+	mutedIDList = make([]uint64, 0, len(muteList))
+	for _, each := range muteList {
+		if each.IsMute {
+			mutedIDList = append(mutedIDList, each.MutedID)
+		}
+	}
+	return mutedIDList, nil
+}
+
+// GetMuteListByUserID 获取用户屏蔽列表
+func GetMuteListByUserID(userID uint64) ([]model.User, error) {
+	mutedIDList, err := GetMuteIDListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	return GetUserListByUserIDList(mutedIDList)
+}
+
+// goMuteList 查询用户的屏蔽记录并写入缓存
+func goMuteList(userID uint64) ([]model.Mute, error) {
+	var muteList []model.Mute
+	if result := global.DB.Select("muted_id", "is_mute").Model(&model.Mute{}).
+		Where("user_id = ?", userID).Find(&muteList); result.Error != nil {
+		return nil, result.Error
+	}
+	// 更新缓存
+	if err := AddMuteIDListByUserIDToRedis(userID, muteList); err != nil && err != ErrCacheUnavailable {
+		return nil, err
+	}
+	return muteList, nil
+}
+
+// GetHiddenUserIDSet 获取对当前用户隐藏的用户集合，即其拉黑或屏蔽的用户，未登录时为空
+func GetHiddenUserIDSet(viewerID uint64) (map[uint64]void, error) {
+	hiddenSet := make(map[uint64]void)
+	if viewerID == 0 {
+		return hiddenSet, nil
+	}
+	blockedIDList, err := GetBlockIDListByUserID(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	mutedIDList, err := GetMuteIDListByUserID(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	for _, each := range blockedIDList {
+		hiddenSet[each] = member
+	}
+	for _, each := range mutedIDList {
+		hiddenSet[each] = member
+	}
+	return hiddenSet, nil
+}
+
+// FilterCommentsByViewer 从评论列表中移除当前用户拉黑或屏蔽的用户发表的评论，userList 与 commentList 一一对应
+func FilterCommentsByViewer(viewerID uint64, commentList []model.Comment, userList []model.User) ([]model.Comment, []model.User, error) {
+	hiddenSet, err := GetHiddenUserIDSet(viewerID)
+	if err != nil || len(hiddenSet) == 0 {
+		return commentList, userList, err
+	}
+	filteredCommentList := make([]model.Comment, 0, len(commentList))
+	filteredUserList := make([]model.User, 0, len(userList))
+	for i, comment := range commentList {
+		if _, ok := hiddenSet[comment.UserID]; ok {
+			continue
+		}
+		filteredCommentList = append(filteredCommentList, comment)
+		filteredUserList = append(filteredUserList, userList[i])
+	}
+	return filteredCommentList, filteredUserList, nil
+}
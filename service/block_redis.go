@@ -0,0 +1,139 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"math/rand"
+	"time"
+)
+
+func GetBlockStatusFromRedis(userID, blockedID uint64) (bool, error) {
+	return getRelationStatusFromRedis(fmt.Sprintf(UserBlockPattern, userID), blockedID)
+}
+
+func AddBlockIDListByUserIDToRedis(userID uint64, blockList []model.Block) error {
+	statusMap := make(map[uint64]bool, len(blockList))
+	for _, each := range blockList {
+		statusMap[each.BlockedID] = each.IsBlock
+	}
+	return addRelationListToRedis(fmt.Sprintf(UserBlockPattern, userID), statusMap)
+}
+
+func UpdateBlockForRedis(userID, blockedID uint64, isBlock bool) error {
+	return updateRelationForRedis(fmt.Sprintf(UserBlockPattern, userID), blockedID, isBlock)
+}
+
+func GetBlockIDListByUserIDFromRedis(userID uint64) ([]uint64, error) {
+	return getRelationIDListFromRedis(fmt.Sprintf(UserBlockPattern, userID))
+}
+
+func GetMuteStatusFromRedis(userID, mutedID uint64) (bool, error) {
+	return getRelationStatusFromRedis(fmt.Sprintf(UserMutePattern, userID), mutedID)
+}
+
+func AddMuteIDListByUserIDToRedis(userID uint64, muteList []model.Mute) error {
+	statusMap := make(map[uint64]bool, len(muteList))
+	for _, each := range muteList {
+		statusMap[each.MutedID] = each.IsMute
+	}
+	return addRelationListToRedis(fmt.Sprintf(UserMutePattern, userID), statusMap)
+}
+
+func UpdateMuteForRedis(userID, mutedID uint64, isMute bool) error {
+	return updateRelationForRedis(fmt.Sprintf(UserMutePattern, userID), mutedID, isMute)
+}
+
+func GetMuteIDListByUserIDFromRedis(userID uint64) ([]uint64, error) {
+	return getRelationIDListFromRedis(fmt.Sprintf(UserMutePattern, userID))
+}
+
+// getRelationStatusFromRedis 查询拉黑、屏蔽等单向关系的状态，集合中 1 表示生效，0 表示已取消
+func getRelationStatusFromRedis(key string, targetID uint64) (bool, error) {
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) <= 0 then
+				return false
+			end
+			redis.call("Expire", KEYS[1], ARGV[2])
+			local tmp = redis.call("ZScore", KEYS[1], ARGV[1])
+			if not tmp then
+				return {err = "no tracking information"}
+			end
+			return tmp
+			`)
+	keys := []string{key}
+	values := []interface{}{targetID, global.BLOCK_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return false, errors.New("not found in cache")
+	} else {
+		return false, err
+	}
+}
+
+func addRelationListToRedis(key string, statusMap map[uint64]bool) error {
+	// 使用 pipeline
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		// 初始化
+		pipe.ZAdd(global.CONTEXT, key, &redis.Z{Score: 2, Member: Header})
+		// 增加关系
+		for targetID, status := range statusMap {
+			if status {
+				pipe.ZAdd(global.CONTEXT, key, &redis.Z{Score: 1, Member: targetID})
+			} else {
+				pipe.ZAdd(global.CONTEXT, key, &redis.Z{Score: 0, Member: targetID})
+			}
+		}
+		// 设置过期时间
+		pipe.Expire(global.CONTEXT, key, global.BLOCK_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
+		return nil
+	})
+	return err
+}
+
+func updateRelationForRedis(key string, targetID uint64, status bool) error {
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) > 0 then
+				redis.call("ZAdd", KEYS[1], ARGV[1], ARGV[2])
+				redis.call("Expire", KEYS[1], ARGV[3])
+				return true
+			end
+			return false
+		`)
+	var score int64 = 0
+	if status {
+		score = 1
+	}
+	keys := []string{key}
+	values := []interface{}{score, targetID, global.BLOCK_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+func getRelationIDListFromRedis(key string) ([]uint64, error) {
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) <= 0 then
+				return false
+			end
+			redis.call("Expire", KEYS[1], ARGV[1])
+			return redis.call("ZRangeByScore", KEYS[1], 1, 1)
+			`)
+	keys := []string{key}
+	values := []interface{}{global.BLOCK_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Uint64Slice()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return nil, errors.New("not found in cache")
+	} else {
+		return nil, err
+	}
+}
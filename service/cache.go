@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/singleflight"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCacheUnavailable Redis 不可用或已熔断，读操作应直接查询数据库，写操作跳过缓存维护
+var ErrCacheUnavailable = errors.New("cache unavailable")
+
+var (
+	cacheBreaker = util.NewCircuitBreaker(global.CACHE_BREAKER_THRESHOLD, global.CACHE_BREAKER_TIMEOUT, onCacheStateChange)
+	dirtyMutex   sync.Mutex
+	dirtyKeySet  = make(map[string]void)
+	rebuildGroup singleflight.Group
+	// onRebuildLoad 重建缓存查询数据库前调用，测试中用于统计查询次数
+	onRebuildLoad func(key string)
+)
+
+// 会修改数据的命令，失败或被熔断时需要在恢复后删除其涉及的 key
+var cacheWriteCommands = map[string]bool{
+	"del": true, "set": true, "setnx": true, "hset": true, "hincrby": true, "sadd": true, "srem": true,
+	"zadd": true, "zincrby": true, "zrem": true, "zremrangebyrank": true, "zremrangebyscore": true,
+	"zunionstore": true, "eval": true, "evalsha": true,
+}
+
+// isCacheMiss 缓存不存在或 Redis 不可用时返回 true，调用方应回源查询数据库
+func isCacheMiss(err error) bool {
+	return err == ErrCacheUnavailable || err.Error() == "not found in cache"
+}
+
+// skipCacheUnavailable Redis 不可用时忽略缓存维护失败，涉及的 key 已记录，恢复后删除
+func skipCacheUnavailable(err error) error {
+	if err == ErrCacheUnavailable {
+		return nil
+	}
+	return err
+}
+
+// rebuildCache 防止缓存击穿：同一 key 在本进程内的并发重建通过 singleflight 合并，多个实例之间通过重建锁只让一个调用方查询数据库
+// load 查询数据库并写入缓存；未抢到锁的调用方等待锁释放后通过 read 读取缓存，缓存仍不存在或等待超时时自行调用 load
+// 返回值由并发调用方共享，调用方不能修改
+func rebuildCache(key string, load func() (interface{}, error), read func() (interface{}, bool, error)) (interface{}, error) {
+	value, err, _ := rebuildGroup.Do(key, func() (interface{}, error) {
+		load := func() (interface{}, error) {
+			if onRebuildLoad != nil {
+				onRebuildLoad(key)
+			}
+			return load()
+		}
+		token, _ := global.ID_GENERATOR.NextID()
+		ok, err := AcquireRebuildLock(key, token)
+		if err != nil || ok {
+			if ok {
+				defer func() {
+					if err := ReleaseRebuildLock(key, token); err != nil && err != ErrCacheUnavailable {
+						log.Printf("release rebuild lock of %s failed: %v\n", key, err)
+					}
+				}()
+				// 缓存可能已由上一个持有锁的调用方重建
+				if value, hit, err := read(); err == nil && hit {
+					return value, nil
+				}
+			}
+			// 抢到锁，或 Redis 不可用时直接查询数据库
+			return load()
+		}
+		// 其它实例正在重建，等待其完成
+		for deadline := time.Now().Add(global.CACHE_REBUILD_WAIT); time.Now().Before(deadline); {
+			time.Sleep(global.CACHE_REBUILD_POLL)
+			if locked, err := ExistsRebuildLock(key); err != nil || !locked {
+				break
+			}
+		}
+		if value, hit, err := read(); err == nil && hit {
+			return value, nil
+		}
+		return load()
+	})
+	return value, err
+}
+
+// CacheBreakerHook Redis 熔断钩子：连接失败的命令统一返回 ErrCacheUnavailable，并记录写命令涉及的 key
+type CacheBreakerHook struct{}
+
+func (CacheBreakerHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	if !cacheBreaker.Allow() {
+		markDirtyKeys(cmd)
+		return ctx, ErrCacheUnavailable
+	}
+	return ctx, nil
+}
+
+func (CacheBreakerHook) AfterProcess(_ context.Context, cmd redis.Cmder) error {
+	err := cmd.Err()
+	if err == ErrCacheUnavailable {
+		return err
+	}
+	if !isConnectionError(err) {
+		cacheBreaker.Success()
+		return nil
+	}
+	cacheBreaker.Failure()
+	markDirtyKeys(cmd)
+	return ErrCacheUnavailable
+}
+
+func (CacheBreakerHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	if !cacheBreaker.Allow() {
+		for _, cmd := range cmds {
+			markDirtyKeys(cmd)
+		}
+		return ctx, ErrCacheUnavailable
+	}
+	return ctx, nil
+}
+
+func (CacheBreakerHook) AfterProcessPipeline(_ context.Context, cmds []redis.Cmder) error {
+	failed := false
+	for _, cmd := range cmds {
+		if err := cmd.Err(); err == ErrCacheUnavailable {
+			return err
+		} else if isConnectionError(err) {
+			failed = true
+			break
+		}
+	}
+	if !failed {
+		cacheBreaker.Success()
+		return nil
+	}
+	cacheBreaker.Failure()
+	for _, cmd := range cmds {
+		markDirtyKeys(cmd)
+	}
+	return ErrCacheUnavailable
+}
+
+// isConnectionError Redis 返回的错误和空结果说明连接正常，其余错误视为 Redis 不可用
+func isConnectionError(err error) bool {
+	if err == nil || err == redis.Nil {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
+
+// markDirtyKeys 记录未能执行的写命令涉及的 key
+func markDirtyKeys(cmd redis.Cmder) {
+	name := cmd.Name()
+	if !cacheWriteCommands[name] {
+		return
+	}
+	args := cmd.Args()
+	var keys []interface{}
+	switch name {
+	case "eval", "evalsha":
+		if len(args) < 3 {
+			return
+		}
+		numKeys, err := strconv.Atoi(redisArgString(args[2]))
+		if err != nil || 3+numKeys > len(args) {
+			return
+		}
+		keys = args[3 : 3+numKeys]
+	case "del":
+		keys = args[1:]
+	default:
+		if len(args) < 2 {
+			return
+		}
+		keys = args[1:2]
+	}
+	keyList := make([]string, 0, len(keys))
+	for _, each := range keys {
+		// 吊销记录、大 V 集合、计数增量、事件去重标记和衰减锁不是缓存，删除会使已吊销的令牌重新生效、丢失拉模式标记、丢失未写回的计数、重复处理事件或重复衰减
+		if key := redisArgString(each); key != "" && !strings.HasPrefix(key, "RevokedToken:") && key != BigAuthorKey &&
+			!strings.HasPrefix(key, "CounterDelta:") && !strings.HasPrefix(key, "CounterFlush:") &&
+			!strings.HasPrefix(key, "CounterFlushID:") && !strings.HasPrefix(key, "OutboxEvent:") &&
+			!strings.HasPrefix(key, "CounterEvent:") && !strings.HasPrefix(key, "HotDecayLock:") {
+			keyList = append(keyList, key)
+		}
+	}
+	addDirtyKeys(keyList)
+}
+
+// addDirtyKeys 记录待失效的 key，数目超过上限时不再记录
+func addDirtyKeys(keys []string) {
+	dirtyMutex.Lock()
+	defer dirtyMutex.Unlock()
+	for _, key := range keys {
+		if len(dirtyKeySet) >= global.CACHE_DIRTY_MAX_KEYS {
+			log.Println("too many dirty cache keys, some keys will be stale until expired")
+			return
+		}
+		dirtyKeySet[key] = member
+	}
+}
+
+func redisArgString(arg interface{}) string {
+	switch v := arg.(type) {
+	case string:
+		return v
+	case int:
+		return strconv.Itoa(v)
+	default:
+		return ""
+	}
+}
+
+// onCacheStateChange Redis 恢复后删除熔断期间记录的 key，同步吊销列表，并重建视频流
+func onCacheStateChange(from, to int) {
+	switch to {
+	case util.BreakerOpen:
+		log.Println("redis is unavailable, cache breaker opened")
+	case util.BreakerClosed:
+		log.Println("redis recovered, cache breaker closed")
+		invalidateDirtyKeys()
+		if err := SyncRevokedTokens(); err != nil {
+			log.Println("sync revoked tokens failed:", err)
+		}
+		if err := GoFeed(); err != nil {
+			log.Println("rebuild feed failed:", err)
+		}
+	}
+}
+
+// invalidateDirtyKeys 分批删除熔断期间记录的 key，删除失败的 key 保留到下次恢复
+func invalidateDirtyKeys() {
+	dirtyMutex.Lock()
+	keys := make([]string, 0, len(dirtyKeySet))
+	for key := range dirtyKeySet {
+		keys = append(keys, key)
+	}
+	dirtyKeySet = make(map[string]void)
+	dirtyMutex.Unlock()
+	for start := 0; start < len(keys); start += global.CACHE_INVALIDATE_BATCH {
+		end := start + global.CACHE_INVALIDATE_BATCH
+		if end > len(keys) {
+			end = len(keys)
+		}
+		if err := global.REDIS.Del(global.CONTEXT, keys[start:end]...).Err(); err != nil {
+			log.Println("invalidate dirty cache keys failed:", err)
+			addDirtyKeys(keys[start:])
+			return
+		}
+	}
+}
+
+// StartCacheProbe 启动 Redis 探测协程，熔断期间没有请求时也能及时恢复
+// 未熔断时个别写入失败的吊销记录也在这里同步
+func StartCacheProbe() {
+	go func() {
+		ticker := time.NewTicker(global.CACHE_BREAKER_TIMEOUT)
+		defer ticker.Stop()
+		for range ticker.C {
+			if cacheBreaker.State() != util.BreakerClosed {
+				_ = global.REDIS.Ping(global.CONTEXT).Err()
+			} else if atomic.LoadInt32(&revokedTokenUnsynced) == 1 {
+				if err := SyncRevokedTokens(); err != nil {
+					log.Println("sync revoked tokens failed:", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/go-redis/redis/v8"
+)
+
+// AcquireRebuildLock 尝试获取 key 的重建锁，token 用于释放时确认锁仍由自己持有
+func AcquireRebuildLock(key string, token uint64) (bool, error) {
+	lockRedis := fmt.Sprintf(RebuildLockPattern, key)
+	return global.REDIS.SetNX(global.CONTEXT, lockRedis, token, global.CACHE_REBUILD_LOCK_EXPIRE).Result()
+}
+
+// ReleaseRebuildLock 释放重建锁，锁已过期并被其它调用方获取时不删除
+func ReleaseRebuildLock(key string, token uint64) error {
+	lockRedis := fmt.Sprintf(RebuildLockPattern, key)
+	lua := redis.NewScript(`
+				if redis.call("Get", KEYS[1]) == ARGV[1] then
+					return redis.call("Del", KEYS[1])
+				end
+				return 0
+			`)
+	keys := []string{lockRedis}
+	values := []interface{}{token}
+	return lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+}
+
+// ExistsRebuildLock 判断 key 的重建锁是否仍被持有
+func ExistsRebuildLock(key string) (bool, error) {
+	lockRedis := fmt.Sprintf(RebuildLockPattern, key)
+	n, err := global.REDIS.Exists(global.CONTEXT, lockRedis).Result()
+	return n > 0, err
+}
@@ -0,0 +1,107 @@
+package service_test
+
+import (
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countRebuildLoads 统计测试期间每个 key 重建缓存时查询数据库的次数
+func countRebuildLoads(t *testing.T) func() map[string]int {
+	var mu sync.Mutex
+	loadCount := make(map[string]int)
+	service.SetRebuildLoadHook(func(key string) {
+		mu.Lock()
+		defer mu.Unlock()
+		loadCount[key]++
+	})
+	t.Cleanup(func() { service.SetRebuildLoadHook(nil) })
+	return func() map[string]int {
+		mu.Lock()
+		defer mu.Unlock()
+		return loadCount
+	}
+}
+
+func TestUserInfoConcurrent(t *testing.T) {
+	user := model.User{Name: fmt.Sprintf("rebuild%d", time.Now().UnixNano()), Password: "rebuild"}
+	user.UserID, _ = global.ID_GENERATOR.NextID()
+	if err := global.DB.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer global.DB.Delete(&model.User{}, user.UserID)
+	keyUser := fmt.Sprintf(service.UserPattern, user.UserID)
+	global.REDIS.Del(global.CONTEXT, keyUser)
+	defer global.REDIS.Del(global.CONTEXT, keyUser)
+	loadCount := countRebuildLoads(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userInfo, err := service.UserInfoByUserID(user.UserID)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if userInfo.Name != user.Name {
+				t.Errorf("unexpected user %s", userInfo.Name)
+			}
+		}()
+	}
+	wg.Wait()
+	if count := loadCount()[keyUser]; count != 1 {
+		t.Fatalf("expected user to be loaded once, got %d", count)
+	}
+}
+
+func TestVideoListConcurrent(t *testing.T) {
+	videoIDList := make([]uint64, 3)
+	for i := range videoIDList {
+		video := model.Video{AuthorID: 1, Title: "rebuild", Status: model.VideoStatusReady, CreatedAt: time.Now()}
+		video.VideoID, _ = global.ID_GENERATOR.NextID()
+		if err := global.DB.Create(&video).Error; err != nil {
+			t.Fatal(err)
+		}
+		defer global.DB.Unscoped().Delete(&model.Video{}, video.VideoID)
+		keyVideo := fmt.Sprintf(service.VideoPattern, video.VideoID)
+		global.REDIS.Del(global.CONTEXT, keyVideo)
+		defer global.REDIS.Del(global.CONTEXT, keyVideo)
+		videoIDList[i] = video.VideoID
+	}
+	loadCount := countRebuildLoads(t)
+
+	// 两批视频有重叠，重叠的视频也只查询一次
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		batch := videoIDList[:2]
+		if i%2 == 1 {
+			batch = videoIDList[1:]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var videoList []model.Video
+			if err := service.GetVideoListByIDsRedis(&videoList, batch); err != nil {
+				t.Error(err)
+				return
+			}
+			for j, video := range videoList {
+				if video.VideoID != batch[j] {
+					t.Errorf("unexpected video %d at %d", video.VideoID, j)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, videoID := range videoIDList {
+		if count := loadCount()[fmt.Sprintf(service.VideoPattern, videoID)]; count != 1 {
+			t.Fatalf("expected video %d to be loaded once, got %d", videoID, count)
+		}
+	}
+}
@@ -5,118 +5,336 @@ import (
 	"fmt"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 	"strconv"
 	"time"
 )
 
-// AddComment 添加评论，若redis添加失败则mysql回滚
+// AddComment 添加评论或回复，缓存操作与评论在同一事务中写入发件箱，提交后更新缓存并通知视频作者和被回复者
 func AddComment(comment *model.Comment) error {
-	return global.DB.Transaction(func(tx *gorm.DB) error {
+	var (
+		video  model.Video
+		outbox *model.Outbox
+	)
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		if comment.ParentID != 0 {
+			// 回复：查询被回复的评论，回复统一挂在顶层评论下
+			var target model.Comment
+			if result := tx.Where("comment_id = ?", comment.ParentID).Limit(1).Find(&target); result.Error != nil {
+				return result.Error
+			} else if result.RowsAffected == 0 {
+				return errors.New("comment does not exist")
+			}
+			if comment.VideoID != 0 && comment.VideoID != target.VideoID {
+				return errors.New("comment does not belong to video")
+			}
+			comment.VideoID = target.VideoID
+			comment.ReplyToUserID = target.UserID
+			if target.ParentID != 0 {
+				comment.ParentID = target.ParentID
+			}
+			// 被回复者拉黑了评论者，或评论者拉黑了被回复者时不能回复
+			if err := CheckBlocked(comment.UserID, target.UserID); err != nil {
+				return err
+			}
+			// 顶层评论回复数目加1
+			if err := tx.Model(&model.Comment{}).Where("comment_id = ?", comment.ParentID).
+				Update("reply_count", gorm.Expr("reply_count + 1")).Error; err != nil {
+				return err
+			}
+		}
+		// 视频作者拉黑了评论者，或评论者拉黑了视频作者时不能评论
+		if result := tx.Select("author_id").Where("video_id = ?", comment.VideoID).Limit(1).Find(&video); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errors.New("video does not exist")
+		}
+		if err := CheckBlocked(comment.UserID, video.AuthorID); err != nil {
+			return err
+		}
 		if err := tx.Create(comment).Error; err != nil {
 			return err
 		}
-		if err := AddCommentInRedis(comment); err != nil {
+		if err := saveMentions(tx, comment.UserID, comment.VideoID, comment.CommentID, comment.Content); err != nil {
 			return err
 		}
-		return nil
+		var err error
+		outbox, err = addOutbox(tx, EventCommentAdded, commentOutbox{Comment: *comment, AuthorID: video.AuthorID})
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	// 计数由事件消费者更新
+	relayOutbox(outbox)
+	return nil
 }
 
-// DeleteComment 删除评论，弱redis修改失败则mysql回滚
+// DeleteComment 删除评论，删除顶层评论时一并删除其回复，缓存操作与删除在同一事务中写入发件箱，提交后执行
 func DeleteComment(userID uint64, videoID uint64, commentID uint64) error {
-	var comment model.Comment
-	comment.CommentID = commentID
-	return global.DB.Transaction(func(tx *gorm.DB) error {
-		// user_id与video_id用来确保有权限删除（用户只能删除自己的视频）
-		if result := tx.Where("user_id = ? and video_id = ?", userID, videoID).Delete(&comment); result.Error != nil || result.RowsAffected == 0 {
+	var (
+		replyIDList []uint64
+		outbox      *model.Outbox
+	)
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		// user_id与video_id用来确保有权限删除（用户只能删除自己的评论）
+		var comment model.Comment
+		if result := tx.Where("comment_id = ? and user_id = ? and video_id = ?", commentID, userID, videoID).
+			Limit(1).Find(&comment); result.Error != nil || result.RowsAffected == 0 {
 			return errors.New("invalid delete")
 		}
-		if err := DeleteCommentInRedis(videoID, commentID); err != nil {
+		if comment.ParentID == 0 {
+			// 顶层评论：删除全部回复
+			if err := tx.Model(&model.Comment{}).Where("parent_id = ?", commentID).
+				Pluck("comment_id", &replyIDList).Error; err != nil {
+				return err
+			}
+			if len(replyIDList) > 0 {
+				if err := tx.Where("parent_id = ?", commentID).Delete(&model.Comment{}).Error; err != nil {
+					return err
+				}
+			}
+		} else {
+			// 回复：顶层评论回复数目减1
+			if err := tx.Model(&model.Comment{}).Where("comment_id = ?", comment.ParentID).
+				Update("reply_count", gorm.Expr("reply_count - 1")).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&comment).Error; err != nil {
 			return err
 		}
-		return nil
+		// 删除评论及其回复中的提及
+		if err := tx.Where("comment_id in ?", append(replyIDList, commentID)).Delete(&model.Mention{}).Error; err != nil {
+			return err
+		}
+		var err error
+		outbox, err = addOutbox(tx, EventCommentDeleted, commentOutbox{Comment: comment, ReplyIDList: replyIDList})
+		return err
 	})
+	if err != nil {
+		return err
+	}
+	// 计数由事件消费者更新，回复随顶层评论一并删除
+	relayOutbox(outbox)
+	return nil
 }
 
-// GetCommentListAndUserListRedis 获取评论列表和对应的用户列表
-func GetCommentListAndUserListRedis(videoID uint64, commentList *[]model.Comment, userList *[]model.User) error {
+// GetCommentListAndUserListRedis 获取顶层评论列表和对应的用户列表，byLike 为 true 时按点赞数排序，否则按时间排序
+// limit 不大于 0 时返回全部评论，否则从 cursor 之后分页返回，没有更多时返回的游标为 nil
+func GetCommentListAndUserListRedis(videoID uint64, byLike bool, cursor *util.Cursor, limit int,
+	commentList *[]model.Comment, userList *[]model.User) (*util.Cursor, error) {
 	keyCommentsOfVideo := fmt.Sprintf(VideoCommentsPattern, videoID)
+	goCommentsOfVideo := GoCommentsOfVideo
+	order := "created_at desc"
+	if byLike {
+		keyCommentsOfVideo = fmt.Sprintf(VideoTopCommentsPattern, videoID)
+		goCommentsOfVideo = GoTopCommentsOfVideo
+		order = "like_count desc, created_at desc"
+	}
+	// 多取一条用于判断是否还有更多
+	count := limit
+	if limit > 0 {
+		count = limit + 1
+	}
+	listZ, err := getCommentIDPageRedis(videoID, keyCommentsOfVideo, goCommentsOfVideo, order, cursor, count)
+	if err == ErrCacheUnavailable {
+		// Redis 不可用，直接查表
+		listZ, err = getCommentIDPageSql(videoID, byLike, cursor, count)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var nextCursor *util.Cursor
+	if limit > 0 && len(listZ) > limit {
+		listZ = listZ[:limit]
+		nextCursor = &util.Cursor{Score: listZ[limit-1].Score, ID: listZ[limit-1].Member.(uint64)}
+	}
+	commentIDList := make([]uint64, len(listZ))
+	for i, z := range listZ {
+		commentIDList[i] = z.Member.(uint64)
+	}
+	if *commentList, err = GetCommentListByIDs(commentIDList); err != nil {
+		return nil, err
+	}
+	authorIDList := make([]uint64, len(*commentList))
+	for i, comment := range *commentList {
+		authorIDList[i] = comment.UserID
+	}
+	return nextCursor, GetUserListByUserIDs(authorIDList, userList)
+}
+
+// getCommentIDPageRedis 从缓存读取一页顶层评论 ID，评论列表不在缓存中时查表重建
+func getCommentIDPageRedis(videoID uint64, keyCommentsOfVideo string, goCommentsOfVideo func([]model.Comment, string) error,
+	order string, cursor *util.Cursor, count int) ([]redis.Z, error) {
 	n, err := global.REDIS.Exists(global.CONTEXT, keyCommentsOfVideo).Result()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if n <= 0 {
 		//	CommentsOfVideo:id 不存在
 		// 先去 keyVideo 中check comment_count是否为0
 		numComments, err := GetCommentCountOfVideo(videoID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		// 当视频没有评论时提前返回，省去查表操作
 		if numComments == 0 {
-			return nil
+			return nil, nil
 		}
-		// 不止一条comment且key不存在的话查表
-		result := global.DB.Where("video_id = ?", videoID).Find(commentList)
-		if result.Error != nil {
-			return err
-		}
-		if result.RowsAffected == 0 {
-			return nil
+		// 并发请求只由一个调用方查表重建，返回是否有评论
+		hasComments, err := rebuildCache(keyCommentsOfVideo, func() (interface{}, error) {
+			return goCommentListOfVideo(videoID, keyCommentsOfVideo, goCommentsOfVideo, order)
+		}, func() (interface{}, bool, error) {
+			n, err := global.REDIS.Exists(global.CONTEXT, keyCommentsOfVideo).Result()
+			return true, n > 0, err
+		})
+		if err != nil {
+			return nil, err
 		}
-		// 成功
-		numComments = int(result.RowsAffected)
-		// 将此次查表得到的数据写入redis
-		if err = GoCommentsOfVideo(*commentList, keyCommentsOfVideo); err != nil {
-			return err
+		if !hasComments.(bool) {
+			return nil, nil
 		}
-		for _, comment := range *commentList {
-			if err = GoComment(comment); err != nil {
-				return err
-			}
+	}
+	//	CommentsOfVideo:id 存在
+	return GetCommentIDPageFromRedis(keyCommentsOfVideo, cursor, count)
+}
+
+// goCommentListOfVideo 查询视频的全部顶层评论并写入缓存，返回是否有评论
+func goCommentListOfVideo(videoID uint64, keyCommentsOfVideo string, goCommentsOfVideo func([]model.Comment, string) error, order string) (bool, error) {
+	// 只查询顶层评论
+	var allCommentList []model.Comment
+	result := global.DB.Where("video_id = ? and parent_id = ?", videoID, 0).Order(order).Find(&allCommentList)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	// 将此次查表得到的数据写入redis
+	if err := goCommentsOfVideo(allCommentList, keyCommentsOfVideo); err != nil {
+		return false, err
+	}
+	for _, comment := range allCommentList {
+		if err := GoComment(comment); err != nil {
+			return false, err
 		}
-		// 得到评论作者id
-		authorIDList := make([]uint64, numComments)
-		for i, comment := range *commentList {
-			authorIDList[i] = comment.CommentID
+	}
+	return true, nil
+}
+
+// getCommentIDPageSql Redis 不可用时按与缓存相同的顺序查询一页顶层评论 ID，分数为发布时间（秒）或点赞数
+func getCommentIDPageSql(videoID uint64, byLike bool, cursor *util.Cursor, count int) ([]redis.Z, error) {
+	scoreExpr := "unix_timestamp(created_at)"
+	if byLike {
+		scoreExpr = "like_count"
+	}
+	db := global.DB.Model(&model.Comment{}).Select("comment_id as id, "+scoreExpr+" as score").
+		Where("video_id = ? and parent_id = ?", videoID, 0)
+	if cursor != nil {
+		db = db.Where("("+scoreExpr+" < ? or ("+scoreExpr+" = ? and comment_id < ?))", cursor.Score, cursor.Score, cursor.ID)
+	}
+	if count > 0 {
+		db = db.Limit(count)
+	}
+	var hitList []scoreHit
+	if err := db.Order("score desc, comment_id desc").Scan(&hitList).Error; err != nil {
+		return nil, err
+	}
+	listZ := make([]redis.Z, len(hitList))
+	for i, hit := range hitList {
+		listZ[i] = redis.Z{Score: hit.Score, Member: hit.ID}
+	}
+	return listZ, nil
+}
+
+// GetReplyListAndUserListRedis 获取顶层评论在 lastReplyID 之后的一页回复以及对应的用户列表，返回是否还有更多
+func GetReplyListAndUserListRedis(commentID uint64, lastReplyID uint64, count int, replyList *[]model.Comment, userList *[]model.User) (bool, error) {
+	// 确认是顶层评论
+	parentList, err := GetCommentListByIDs([]uint64{commentID})
+	if err != nil {
+		return false, err
+	}
+	// 评论不存在，或读取缓存时被跳过
+	if len(parentList) == 0 {
+		return false, errors.New("comment does not exist")
+	}
+	if parentList[0].ParentID != 0 {
+		return false, errors.New("comment is a reply")
+	}
+	// 没有回复时提前返回，省去查表操作
+	if parentList[0].ReplyCount <= 0 {
+		return false, nil
+	}
+	// 多取一条用于判断是否还有更多
+	replyIDList, err := GetReplyIDListFromRedis(commentID, lastReplyID, int64(count+1))
+	if err != nil && err.Error() == "not found in cache" {
+		// 回复列表不在缓存中，查表重建后再读一次；Redis 不可用时直接查表
+		if err = GoRepliesOfComment(commentID); err == nil {
+			replyIDList, err = GetReplyIDListFromRedis(commentID, lastReplyID, int64(count+1))
 		}
-		return GetUserListByUserIDs(authorIDList, userList)
 	}
-	//	CommentsOfVideo:id 存在
-	if err = global.REDIS.Expire(global.CONTEXT, keyCommentsOfVideo, global.VIDEO_EXPIRE).Err(); err != nil {
-		return err
+	if err != nil && isCacheMiss(err) {
+		// lastReplyID 已被删除，按 ID 查表
+		err = global.DB.Model(&model.Comment{}).Where("parent_id = ? and comment_id > ?", commentID, lastReplyID).
+			Order("comment_id").Limit(count+1).Pluck("comment_id", &replyIDList).Error
 	}
-	commentIDStrList, err := global.REDIS.ZRevRange(global.CONTEXT, keyCommentsOfVideo, 0, -1).Result()
 	if err != nil {
+		return false, err
+	}
+	hasMore := len(replyIDList) > count
+	if hasMore {
+		replyIDList = replyIDList[:count]
+	}
+	if *replyList, err = GetCommentListByIDs(replyIDList); err != nil {
+		return false, err
+	}
+	authorIDList := make([]uint64, len(*replyList))
+	for i, reply := range *replyList {
+		authorIDList[i] = reply.UserID
+	}
+	return hasMore, GetUserListByUserIDs(authorIDList, userList)
+}
+
+// GoRepliesOfComment 将顶层评论的全部回复写入缓存，若已在缓存中则什么都不做
+func GoRepliesOfComment(commentID uint64) error {
+	keyReplies := fmt.Sprintf(CommentRepliesPattern, commentID)
+	n, err := global.REDIS.Exists(global.CONTEXT, keyReplies).Result()
+	if err != nil || n > 0 {
 		return err
 	}
-	numComments := len(commentIDStrList)
-	*commentList = make([]model.Comment, 0, numComments)
-	authorIDList := make([]uint64, 0, numComments)
-	// 查询评论信息
-	for _, commentIDStr := range commentIDStrList {
-		commentID, err := strconv.ParseUint(commentIDStr, 10, 64)
+	var replyList []model.Comment
+	if err = global.DB.Where("parent_id = ?", commentID).Find(&replyList).Error; err != nil {
+		return err
+	}
+	if len(replyList) == 0 {
+		return nil
+	}
+	return GoCommentsOfVideo(replyList, keyReplies)
+}
+
+// GetCommentListByIDs 给定评论ID列表得到对应的评论信息
+func GetCommentListByIDs(commentIDList []uint64) ([]model.Comment, error) {
+	commentList := make([]model.Comment, 0, len(commentIDList))
+	for _, commentID := range commentIDList {
 		keyComment := fmt.Sprintf(CommentPattern, commentID)
-		if err != nil {
-			continue
-		}
-		n, err = global.REDIS.Exists(global.CONTEXT, keyComment).Result()
-		if err != nil {
-			return err
+		n, err := global.REDIS.Exists(global.CONTEXT, keyComment).Result()
+		if err != nil && err != ErrCacheUnavailable {
+			return nil, err
 		}
 		var comment model.Comment
 		if n <= 0 {
-			// "comment_id"不存在
+			// "comment_id"不存在或 Redis 不可用
 			result := global.DB.Where("comment_id = ?", commentID).Limit(1).Find(&comment)
 			if result.Error != nil || result.RowsAffected == 0 {
-				return errors.New("get Comment fail")
+				return nil, errors.New("get Comment fail")
 			}
-			if err = GoComment(comment); err != nil {
+			if err = GoComment(comment); err != nil && err != ErrCacheUnavailable {
 				continue
 			}
-			*commentList = append(*commentList, comment)
-			authorIDList = append(authorIDList, comment.CommentID)
+			commentList = append(commentList, comment)
 			continue
 		}
 		if err = global.REDIS.Expire(global.CONTEXT, keyComment, global.VIDEO_EXPIRE).Err(); err != nil {
@@ -136,10 +354,9 @@ func GetCommentListAndUserListRedis(videoID uint64, commentList *[]model.Comment
 		}
 		comment.CreatedAt = time.UnixMilli(timeUnixMilli)
 
-		*commentList = append(*commentList, comment)
-		authorIDList = append(authorIDList, comment.CommentID)
+		commentList = append(commentList, comment)
 	}
-	return GetUserListByUserIDs(authorIDList, userList)
+	return commentList, nil
 }
 
 // GetCommentCountListByVideoIDList 被调用当我们不知道videoID是否在redis中
@@ -152,28 +369,13 @@ func GetCommentCountListByVideoIDList(videoIDList []uint64, commentCountList *[]
 	for i, videoID := range videoIDList {
 		keyVideo := fmt.Sprintf(VideoPattern, videoID)
 		n, err := global.REDIS.Exists(global.CONTEXT, keyVideo).Result()
-		if err != nil {
+		if err != nil && err != ErrCacheUnavailable {
 			return err
 		}
 		if n <= 0 {
-			keyCommentsOfVideo := fmt.Sprintf(VideoCommentsPattern, videoID)
-			n, err = global.REDIS.Exists(global.CONTEXT, keyCommentsOfVideo).Result()
-			if err != nil {
-				return err
-			}
-			// Video与CommentsOfVideo都不存在
-			if n <= 0 {
-				notInCacheIDList = append(notInCacheIDList, videoID)
-				inCache[i] = false
-				continue
-			}
-			// Video不存在但是CommentsOfVideo存在
-			commentCount, err := global.REDIS.ZCard(global.CONTEXT, keyCommentsOfVideo).Uint64()
-			if err != nil {
-				return err
-			}
-			(*commentCountList)[i] = int64(commentCount)
-			inCache[i] = true
+			// Video不存在或 Redis 不可用，CommentsOfVideo只包含顶层评论，无法得到包含回复的评论数目
+			notInCacheIDList = append(notInCacheIDList, videoID)
+			inCache[i] = false
 			continue
 		}
 		// 缓存存在
@@ -202,20 +404,29 @@ func GetCommentCountListByVideoIDList(videoIDList []uint64, commentCountList *[]
 	return nil
 }
 
-// GetCommentCountListByVideoIDListSql 被调用当且仅当VideoID不在cache中，不得不通过sql查询
+// GetCommentCountListByVideoIDListSql 被调用当且仅当VideoID不在cache中，不得不通过sql查询计数列
 func GetCommentCountListByVideoIDListSql(videoIDList []uint64, commentCountList *[]int64) error {
 	var uniqueVideoList []model.VideoCount
-	result := global.DB.Model(&model.Comment{}).Select("video_id", "COUNT(video_id) as comment_count").
-		Where("video_id in ?", videoIDList).Group("video_id").Find(&uniqueVideoList)
+	result := global.DB.Model(&model.Video{}).Select("video_id", "comment_count").
+		Where("video_id in ?", videoIDList).Find(&uniqueVideoList)
 	if result.Error != nil {
 		return result.Error
 	}
 	numVideos := result.RowsAffected
+	// 加上尚未写回的增量
+	foundIDList := make([]uint64, numVideos)
+	for i, each := range uniqueVideoList {
+		foundIDList[i] = each.VideoID
+	}
+	deltaList, err := getPendingCounterDeltaList(videoCommentCounter, foundIDList)
+	if err != nil {
+		return err
+	}
 	// 针对查询结果建立映射关系
 	*commentCountList = make([]int64, 0, numVideos)
 	mapVideoIDToCommentCount := make(map[uint64]int64, numVideos)
-	for _, each := range uniqueVideoList {
-		mapVideoIDToCommentCount[each.VideoID] = each.CommentCount
+	for i, each := range uniqueVideoList {
+		mapVideoIDToCommentCount[each.VideoID] = each.CommentCount + deltaList[i]
 	}
 	for _, videoID := range videoIDList {
 		*commentCountList = append(*commentCountList, mapVideoIDToCommentCount[videoID])
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// errCommentLikeUnchanged 点赞状态已被并发请求修改
+var errCommentLikeUnchanged = errors.New("comment like status unchanged")
+
+// GetCommentLikeStatusForUpdate 获取评论点赞状态，此处是针对 AddCommentLike 和 CancelCommentLike
+func GetCommentLikeStatusForUpdate(userID, commentID uint64) (bool, error) {
+	// 查询缓存
+	likeStatus, err := GetCommentLikeStatusFromRedis(userID, commentID)
+	if err == nil {
+		return likeStatus, nil
+	} else if !isCacheMiss(err) {
+		return false, err
+	}
+	// 缓存不存在，查询数据库
+	commentLikeList, err := goCommentLikeList(userID)
+	if err != nil {
+		return false, err
+	}
+	// 根据查询结果判断，Redis 不可用时同样适用
+	for _, each := range commentLikeList {
+		if each.CommentID == commentID {
+			return each.IsLike, nil
+		}
+	}
+	return false, errors.New("no tracking information")
+}
+
+// AddCommentLike 点赞评论
+func AddCommentLike(userID, commentID uint64) error {
+	// 获取当前点赞状态
+	isLike, err := GetCommentLikeStatusForUpdate(userID, commentID)
+	if err == nil && isLike {
+		return nil
+	} else if err != nil && err.Error() != "no tracking information" {
+		return err
+	}
+	var comment model.Comment
+	err = global.DB.Transaction(func(tx *gorm.DB) error {
+		// 查询评论
+		if result := tx.Where("comment_id = ?", commentID).Limit(1).Find(&comment); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errors.New("comment 表中 comment_id 不存在")
+		}
+		// 写入或修改数据库，记录由唯一索引保证只有一条；已经点赞时影响行数为 0，并发请求只计数一次
+		var commentLike model.CommentLike
+		commentLike.CommentLikeID, _ = global.ID_GENERATOR.NextID()
+		commentLike.CommentID = commentID
+		commentLike.UserID = userID
+		commentLike.IsLike = true
+		result := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "comment_id"}},
+			DoUpdates: clause.Set{
+				{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("if(is_like, updated_at, values(updated_at))")},
+				{Column: clause.Column{Name: "is_like"}, Value: true},
+			},
+		}).Create(&commentLike)
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errCommentLikeUnchanged
+		}
+		return tx.Model(&model.Comment{}).Where("comment_id = ?", commentID).
+			Update("like_count", gorm.Expr("like_count + 1")).Error
+	})
+	if err == errCommentLikeUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(UpdateCommentLikeForRedis(&comment, userID, 1))
+}
+
+// CancelCommentLike 取消点赞评论
+func CancelCommentLike(userID, commentID uint64) error {
+	// 获取当前点赞状态
+	if isLike, err := GetCommentLikeStatusForUpdate(userID, commentID); err != nil {
+		return err
+	} else if !isLike {
+		return nil
+	}
+	var comment model.Comment
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		// 查询评论
+		if result := tx.Where("comment_id = ?", commentID).Limit(1).Find(&comment); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errors.New("comment 表中 comment_id 不存在")
+		}
+		// 修改数据库；并发请求已修改时不再重复计数
+		result := tx.Model(&model.CommentLike{}).Where("user_id = ? and comment_id = ? and is_like = ?", userID, commentID, true).
+			Update("is_like", false)
+		if result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errCommentLikeUnchanged
+		}
+		return tx.Model(&model.Comment{}).Where("comment_id = ?", commentID).
+			Update("like_count", gorm.Expr("like_count - 1")).Error
+	})
+	if err == errCommentLikeUnchanged {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(UpdateCommentLikeForRedis(&comment, userID, -1))
+}
+
+// GetCommentLikeIDListByUserID 通过用户 ID 查询点赞的评论 ID 列表
+func GetCommentLikeIDListByUserID(userID uint64) ([]uint64, error) {
+	// 查询缓存
+	commentIDList, err := GetCommentLikeIDListByUserIDFromRedis(userID)
+	if err == nil {
+		return commentIDList, nil
+	} else if !isCacheMiss(err) {
+		return nil, err
+	}
+	// 缓存不存在，查询数据库
+	commentLikeList, err := goCommentLikeList(userID)
+	if err != nil {
+		return nil, err
+	}
+	// 后续操作，返回点赞评论 ID 列表
+	commentIDList = make([]uint64, 0, len(commentLikeList))
+	for _, each := range commentLikeList {
+		if each.IsLike {
+			commentIDList = append(commentIDList, each.CommentID)
+		}
+	}
+	return commentIDList, nil
+}
+
+// GetCommentLikeStatusList 根据 userID 和 commentIDList 返回点赞状态列表
+func GetCommentLikeStatusList(userID uint64, commentIDList []uint64) ([]bool, error) {
+	// 通过用户 ID 查询点赞评论 ID 列表
+	likeCommentIDList, err := GetCommentLikeIDListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	// 后续处理，返回点赞状态列表
+	mapCommentIDToLike := make(map[uint64]void, len(likeCommentIDList))
+	for _, each := range likeCommentIDList {
+		mapCommentIDToLike[each] = member
+	}
+	isLikeList := make([]bool, len(commentIDList))
+	for i, each := range commentIDList {
+		if _, ok := mapCommentIDToLike[each]; ok {
+			isLikeList[i] = true
+		}
+	}
+	return isLikeList, nil
+}
+
+// goCommentLikeList 查询用户的评论点赞记录并写入缓存
+func goCommentLikeList(userID uint64) ([]model.CommentLike, error) {
+	var commentLikeList []model.CommentLike
+	if result := global.DB.Select("comment_id", "is_like").Model(&model.CommentLike{}).
+		Where("user_id = ?", userID).Find(&commentLikeList); result.Error != nil {
+		return nil, result.Error
+	}
+	// 更新缓存
+	if err := AddCommentLikeIDListByUserIDToRedis(userID, commentLikeList); err != nil && err != ErrCacheUnavailable {
+		return nil, err
+	}
+	return commentLikeList, nil
+}
+
+// DedupCommentLikes 删除同一用户对同一评论的重复记录并重新计算点赞数目，用于建立唯一索引前清理已有数据
+// 保留的记录优先是点赞状态，其次是最近修改的一条
+func DedupCommentLikes() error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Exec("delete l1 from comment_likes l1 join comment_likes l2 " +
+			"on l1.user_id = l2.user_id and l1.comment_id = l2.comment_id and l1.comment_like_id <> l2.comment_like_id " +
+			"and (l2.is_like > l1.is_like or (l2.is_like = l1.is_like and (l2.updated_at > l1.updated_at " +
+			"or (l2.updated_at = l1.updated_at and l2.comment_like_id > l1.comment_like_id))))")
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return tx.Exec("update comments set like_count = (select count(*) from comment_likes " +
+			"where comment_likes.comment_id = comments.comment_id and comment_likes.is_like = true)").Error
+	})
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"math/rand"
+	"time"
+)
+
+func GetCommentLikeStatusFromRedis(userID, commentID uint64) (bool, error) {
+	// 定义 key
+	userCommentLikeRedis := fmt.Sprintf(UserCommentLikePattern, userID)
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) <= 0 then
+				return false
+			end
+			redis.call("Expire", KEYS[1], ARGV[2])
+			local tmp = redis.call("ZScore", KEYS[1], ARGV[1])
+			if not tmp then
+				return {err = "no tracking information"}
+			end
+			return tmp
+			`)
+	keys := []string{userCommentLikeRedis}
+	values := []interface{}{commentID, global.COMMENT_LIKE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return false, errors.New("not found in cache")
+	} else {
+		return false, err
+	}
+}
+
+func AddCommentLikeIDListByUserIDToRedis(userID uint64, commentLikeList []model.CommentLike) error {
+	// 定义 key
+	userCommentLikeRedis := fmt.Sprintf(UserCommentLikePattern, userID)
+	// 使用 pipeline
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		// 初始化
+		pipe.ZAdd(global.CONTEXT, userCommentLikeRedis, &redis.Z{Score: 2, Member: Header})
+		// 增加点赞关系
+		for _, each := range commentLikeList {
+			if each.IsLike {
+				pipe.ZAdd(global.CONTEXT, userCommentLikeRedis, &redis.Z{Score: 1, Member: each.CommentID})
+			} else {
+				pipe.ZAdd(global.CONTEXT, userCommentLikeRedis, &redis.Z{Score: 0, Member: each.CommentID})
+			}
+		}
+		//设置过期时间
+		pipe.Expire(global.CONTEXT, userCommentLikeRedis, global.COMMENT_LIKE_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
+		return nil
+	})
+	return err
+}
+
+// UpdateCommentLikeForRedis 点赞或取消点赞评论后的缓存操作，delta 为 1 表示点赞，-1 表示取消
+func UpdateCommentLikeForRedis(comment *model.Comment, userID uint64, delta int64) error {
+	// 设置管道
+	ch := make(chan error, 3)
+	defer close(ch)
+
+	// 更新 userCommentLikeRedis 缓存
+	go func() {
+		// 定义 key
+		userCommentLikeRedis := fmt.Sprintf(UserCommentLikePattern, userID)
+		lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("ZAdd", KEYS[1], ARGV[1], ARGV[2])
+					redis.call("Expire", KEYS[1], ARGV[3])
+					return true
+				end
+				return false
+			`)
+		var status int64 = 0
+		if delta > 0 {
+			status = 1
+		}
+		keys := []string{userCommentLikeRedis}
+		values := []interface{}{status, comment.CommentID, global.COMMENT_LIKE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+		ch <- err
+	}()
+
+	// 更新 commentRedis 缓存
+	go func() {
+		// 定义 key
+		commentRedis := fmt.Sprintf(CommentPattern, comment.CommentID)
+		lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("HIncrBy", KEYS[1], "like_count", ARGV[1])
+					redis.call("Expire", KEYS[1], ARGV[2])
+					return true
+				end
+				return false
+			`)
+		keys := []string{commentRedis}
+		values := []interface{}{delta, global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+		ch <- err
+	}()
+
+	// 更新 topCommentsOfVideoRedis 缓存，只包含顶层评论
+	go func() {
+		if comment.ParentID != 0 {
+			ch <- nil
+			return
+		}
+		// 定义 key
+		topCommentsOfVideoRedis := fmt.Sprintf(VideoTopCommentsPattern, comment.VideoID)
+		lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("ZIncrBy", KEYS[1], ARGV[1], ARGV[2])
+					redis.call("Expire", KEYS[1], ARGV[3])
+					return true
+				end
+				return false
+			`)
+		keys := []string{topCommentsOfVideoRedis}
+		values := []interface{}{delta, comment.CommentID, global.VIDEO_COMMENTS_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+		ch <- err
+	}()
+
+	var err error
+	for i := 0; i < 3; i++ {
+		errTmp := <-ch
+		if errTmp != nil && errTmp != redis.Nil {
+			err = errTmp
+		}
+	}
+	return err
+}
+
+func GetCommentLikeIDListByUserIDFromRedis(userID uint64) ([]uint64, error) {
+	// 定义 key
+	userCommentLikeRedis := fmt.Sprintf(UserCommentLikePattern, userID)
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) <= 0 then
+				return false
+			end
+			redis.call("Expire", KEYS[1], ARGV[1])
+			return redis.call("ZRangeByScore", KEYS[1], 1, 1)
+			`)
+	keys := []string{userCommentLikeRedis}
+	values := []interface{}{global.COMMENT_LIKE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Uint64Slice()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return nil, errors.New("not found in cache")
+	} else {
+		return nil, err
+	}
+}
+
+// GoTopCommentsOfVideo 将顶层评论按点赞数写入缓存
+func GoTopCommentsOfVideo(commentList []model.Comment, keyTopCommentsOfVideo string) error {
+	var listZ = make([]*redis.Z, 0, len(commentList))
+	for _, comment := range commentList {
+		listZ = append(listZ, &redis.Z{Score: float64(comment.LikeCount), Member: comment.CommentID})
+	}
+	pipe := global.REDIS.TxPipeline()
+	pipe.ZAdd(global.CONTEXT, keyTopCommentsOfVideo, listZ...)
+	pipe.Expire(global.CONTEXT, keyTopCommentsOfVideo, global.VIDEO_COMMENTS_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
+	_, err := pipe.Exec(global.CONTEXT)
+	return err
+}
@@ -1,9 +1,11 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
 	"github.com/go-redis/redis/v8"
 	"math"
 	"math/rand"
@@ -17,6 +19,13 @@ func AddCommentInRedis(comment *model.Comment) error {
 	keyCommentsOfVideo := fmt.Sprintf(VideoCommentsPattern, comment.VideoID)
 	keyComment := fmt.Sprintf(CommentPattern, comment.CommentID)
 	keyVideo := fmt.Sprintf(VideoPattern, comment.VideoID)
+	if comment.ParentID != 0 {
+		// 回复只加入所属顶层评论的回复列表
+		keyCommentsOfVideo = fmt.Sprintf(CommentRepliesPattern, comment.ParentID)
+		if err := IncrReplyCountInRedis(comment.ParentID, 1); err != nil {
+			return err
+		}
+	}
 	// 判断 keyCommentsOfVideo 是否存在 存在则加入comment
 	lua := redis.NewScript(`
 				local key = KEYS[1]
@@ -54,23 +63,42 @@ func AddCommentInRedis(comment *model.Comment) error {
 	if err != nil {
 		return err
 	}
+	// 顶层评论加入按点赞数排序的评论列表
+	if comment.ParentID == 0 {
+		if err = addTopCommentInRedis(comment.VideoID, comment.CommentID); err != nil {
+			return err
+		}
+	}
+	// 更新热榜
+	if err = IncrHotScore(comment.VideoID, global.HOT_COMMENT_WEIGHT); err != nil {
+		return err
+	}
 	// 加入comment，无需判断key是否存在
 	userIDStr := strconv.FormatUint(comment.UserID, 10)
 	videoIDStr := strconv.FormatUint(comment.VideoID, 10)
 	pipe := global.REDIS.TxPipeline()
+	pipe.HSet(global.CONTEXT, keyComment, "content", comment.Content, "user_id", userIDStr, "video_id", videoIDStr,
+		"parent_id", comment.ParentID, "reply_to_user_id", comment.ReplyToUserID, "reply_count", comment.ReplyCount,
+		"like_count", comment.LikeCount, "created_at", comment.CreatedAt.UnixMilli())
 	pipe.Expire(global.CONTEXT, keyComment, global.COMMENT_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
-	pipe.HSet(global.CONTEXT, keyComment, "content", comment.Content, "user_id", userIDStr, "video_id", videoIDStr, "created_at", time.Now().UnixMilli())
 	_, err = pipe.Exec(global.CONTEXT)
 	return err
 }
 
-// DeleteCommentInRedis 删除评论的redis相关操作
-func DeleteCommentInRedis(videoID uint64, commentID uint64) error {
+// DeleteCommentInRedis 删除评论的redis相关操作，删除顶层评论时一并删除其回复
+func DeleteCommentInRedis(comment *model.Comment, replyIDList []uint64) error {
 	//定义 key
-	keyCommentsOfVideo := fmt.Sprintf(VideoCommentsPattern, videoID)
-	keyComment := fmt.Sprintf(CommentPattern, commentID)
-	keyVideo := fmt.Sprintf(VideoPattern, videoID)
-	CommentIDStr := strconv.FormatUint(commentID, 10)
+	keyCommentsOfVideo := fmt.Sprintf(VideoCommentsPattern, comment.VideoID)
+	keyComment := fmt.Sprintf(CommentPattern, comment.CommentID)
+	keyVideo := fmt.Sprintf(VideoPattern, comment.VideoID)
+	CommentIDStr := strconv.FormatUint(comment.CommentID, 10)
+	if comment.ParentID != 0 {
+		// 回复只存在于所属顶层评论的回复列表中
+		keyCommentsOfVideo = fmt.Sprintf(CommentRepliesPattern, comment.ParentID)
+		if err := IncrReplyCountInRedis(comment.ParentID, -1); err != nil {
+			return err
+		}
+	}
 	// 判断keyCommentsOfVideo是否存在 存在则从有序集合中移除comment
 	lua := redis.NewScript(`
 				local key = KEYS[1]
@@ -89,22 +117,97 @@ func DeleteCommentInRedis(videoID uint64, commentID uint64) error {
 	if err != nil {
 		return err
 	}
-	// 判断keyVideo是否存在，存在则comment_count减1
+	// 判断keyVideo是否存在，存在则comment_count减去删除的评论数
+	numDeleted := int64(1 + len(replyIDList))
 	lua = redis.NewScript(`
 				local key = KEYS[1]
-				local expire_time = ARGV[1]
+				local delta = ARGV[1]
+				local expire_time = ARGV[2]
 				if redis.call("Exists", key) > 0 then
-					redis.call("HIncrBy", key, "comment_count", -1)
+					redis.call("HIncrBy", key, "comment_count", delta)
 					redis.call("Expire", key, expire_time)
 					return 1
 				end
 				return 0
 			`)
 	keys = []string{keyVideo}
-	values = []interface{}{global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	values = []interface{}{-numDeleted, global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
 	_, err = lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-	// 删除comment，无需判断key是否存在
-	return global.REDIS.Del(global.CONTEXT, keyComment).Err()
+	// 更新热榜
+	if err = IncrHotScore(comment.VideoID, -global.HOT_COMMENT_WEIGHT*float64(numDeleted)); err != nil {
+		return err
+	}
+	// 删除comment及其回复，无需判断key是否存在
+	delKeys := make([]string, 0, len(replyIDList)+2)
+	delKeys = append(delKeys, keyComment)
+	if comment.ParentID == 0 {
+		delKeys = append(delKeys, fmt.Sprintf(CommentRepliesPattern, comment.CommentID))
+		keyTopCommentsOfVideo := fmt.Sprintf(VideoTopCommentsPattern, comment.VideoID)
+		if err = global.REDIS.ZRem(global.CONTEXT, keyTopCommentsOfVideo, CommentIDStr).Err(); err != nil {
+			return err
+		}
+	}
+	for _, replyID := range replyIDList {
+		delKeys = append(delKeys, fmt.Sprintf(CommentPattern, replyID))
+	}
+	return global.REDIS.Del(global.CONTEXT, delKeys...).Err()
+}
+
+// InvalidateCommentInRedis 删除添加或删除评论涉及的缓存，由下次读取时从数据库重建，重复执行结果一致
+func InvalidateCommentInRedis(comment *model.Comment, replyIDList []uint64) error {
+	delKeys := make([]string, 0, len(replyIDList)+4)
+	delKeys = append(delKeys, fmt.Sprintf(VideoPattern, comment.VideoID), fmt.Sprintf(CommentPattern, comment.CommentID))
+	if comment.ParentID != 0 {
+		// 回复：所属顶层评论的回复列表和回复数目
+		delKeys = append(delKeys, fmt.Sprintf(CommentRepliesPattern, comment.ParentID), fmt.Sprintf(CommentPattern, comment.ParentID))
+	} else {
+		delKeys = append(delKeys, fmt.Sprintf(VideoCommentsPattern, comment.VideoID),
+			fmt.Sprintf(VideoTopCommentsPattern, comment.VideoID), fmt.Sprintf(CommentRepliesPattern, comment.CommentID))
+	}
+	for _, replyID := range replyIDList {
+		delKeys = append(delKeys, fmt.Sprintf(CommentPattern, replyID))
+	}
+	return global.REDIS.Del(global.CONTEXT, delKeys...).Err()
+}
+
+// addTopCommentInRedis 新的顶层评论以 0 个点赞加入按点赞数排序的评论列表
+func addTopCommentInRedis(videoID uint64, commentID uint64) error {
+	keyTopCommentsOfVideo := fmt.Sprintf(VideoTopCommentsPattern, videoID)
+	lua := redis.NewScript(`
+				local key = KEYS[1]
+				local comment_id = ARGV[1]
+				local expire_time = ARGV[2]
+				if redis.call("Exists", key) > 0 then
+					redis.call("ZAdd", key, 0, comment_id)
+					redis.call("Expire", key, expire_time)
+					return 1
+				end
+				return 0
+			`)
+	keys := []string{keyTopCommentsOfVideo}
+	values := []interface{}{commentID, global.VIDEO_COMMENTS_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+	return err
+}
+
+// IncrReplyCountInRedis 修改顶层评论缓存中的回复数目
+func IncrReplyCountInRedis(commentID uint64, delta int64) error {
+	keyComment := fmt.Sprintf(CommentPattern, commentID)
+	lua := redis.NewScript(`
+				local key = KEYS[1]
+				local delta = ARGV[1]
+				local expire_time = ARGV[2]
+				if redis.call("Exists", key) > 0 then
+					redis.call("HIncrBy", key, "reply_count", delta)
+					redis.call("Expire", key, expire_time)
+					return 1
+				end
+				return 0
+			`)
+	keys := []string{keyComment}
+	values := []interface{}{delta, global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
+	return err
 }
 
 // GoComment 函数用来将给定comment写入redis，若已在redis中则什么都不做
@@ -117,8 +220,13 @@ func GoComment(comment model.Comment) error {
 				local content = ARGV[3]
 				local created_at = ARGV[4]
 				local expire_time = ARGV[5]
+				local parent_id = ARGV[6]
+				local reply_to_user_id = ARGV[7]
+				local reply_count = ARGV[8]
+				local like_count = ARGV[9]
 				if redis.call("Exists", key) <= 0 then
-					redis.call("HSet", key, "video_id", video_id, "user_id", user_id, "content", content, "created_at", created_at)
+					redis.call("HSet", key, "video_id", video_id, "user_id", user_id, "content", content, "created_at", created_at,
+						"parent_id", parent_id, "reply_to_user_id", reply_to_user_id, "reply_count", reply_count, "like_count", like_count)
 					redis.call("Expire", key, expire_time)
 					return 1
 				end
@@ -126,7 +234,110 @@ func GoComment(comment model.Comment) error {
 			`)
 	keys := []string{keyComment}
 	values := []interface{}{comment.VideoID, comment.UserID, comment.Content, comment.CreatedAt.UnixMilli(),
-		global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+		global.COMMENT_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds()),
+		comment.ParentID, comment.ReplyToUserID, comment.ReplyCount, comment.LikeCount}
 	_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
 	return err
 }
+
+// GetReplyIDListFromRedis 获取顶层评论在 lastReplyID 之后的回复 ID，按时间正序返回
+func GetReplyIDListFromRedis(commentID uint64, lastReplyID uint64, count int64) ([]uint64, error) {
+	keyReplies := fmt.Sprintf(CommentRepliesPattern, commentID)
+	lua := redis.NewScript(`
+				local key = KEYS[1]
+				local last_id = ARGV[1]
+				local count = tonumber(ARGV[2])
+				local expire_time = ARGV[3]
+				if redis.call("Exists", key) <= 0 then
+					return false
+				end
+				local start = 0
+				if last_id ~= "0" then
+					local rank = redis.call("ZRank", key, last_id)
+					if not rank then
+						return false
+					end
+					start = rank + 1
+				end
+				redis.call("Expire", key, expire_time)
+				return redis.call("ZRange", key, start, start + count - 1)
+			`)
+	keys := []string{keyReplies}
+	values := []interface{}{lastReplyID, count, global.VIDEO_COMMENTS_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Uint64Slice()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return nil, errors.New("not found in cache")
+	} else {
+		return nil, err
+	}
+}
+
+// GetCommentIDPageFromRedis 按分数倒序读取评论列表中 cursor 之后的 count 条评论 ID，count 不大于 0 时读取全部
+func GetCommentIDPageFromRedis(keyCommentsOfVideo string, cursor *util.Cursor, count int) ([]redis.Z, error) {
+	lua := redis.NewScript(`
+				local key = KEYS[1]
+				local has_cursor = ARGV[1]
+				local score = ARGV[2]
+				local id = ARGV[3]
+				local count = tonumber(ARGV[4])
+				local expire_time = ARGV[5]
+				if redis.call("Exists", key) <= 0 then
+					return false
+				end
+				redis.call("Expire", key, expire_time)
+				if has_cursor == "0" then
+					return redis.call("ZRevRange", key, 0, count - 1, "WithScores")
+				end
+				local rank = redis.call("ZRevRank", key, id)
+				if rank then
+					return redis.call("ZRevRange", key, rank + 1, rank + count, "WithScores")
+				end
+				-- 游标指向的评论已被删除，按 (score, id) 继续：先取分数相同且排在游标之后的评论，再取分数更低的评论
+				-- 分数相同的成员按成员字符串倒序排列，与 ZRevRange 的顺序一致
+				local result = {}
+				local ties = redis.call("ZRevRangeByScore", key, score, score, "WithScores")
+				for i = 1, #ties, 2 do
+					if #result >= count * 2 then
+						break
+					end
+					if ties[i] < id then
+						table.insert(result, ties[i])
+						table.insert(result, ties[i + 1])
+					end
+				end
+				if #result < count * 2 then
+					local rest = redis.call("ZRevRangeByScore", key, "(" .. score, "-inf", "WithScores", "Limit", 0, count - #result / 2)
+					for _, each in ipairs(rest) do
+						table.insert(result, each)
+					end
+				end
+				return result
+			`)
+	hasCursor, score, id := 0, "0", "0"
+	if cursor != nil {
+		hasCursor, score, id = 1, strconv.FormatFloat(cursor.Score, 'f', -1, 64), strconv.FormatUint(cursor.ID, 10)
+	}
+	keys := []string{keyCommentsOfVideo}
+	values := []interface{}{hasCursor, score, id, count, global.VIDEO_COMMENTS_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).StringSlice()
+	if err == redis.Nil {
+		return nil, errors.New("not found in cache")
+	} else if err != nil {
+		return nil, err
+	}
+	listZ := make([]redis.Z, 0, len(result)/2)
+	for i := 0; i+1 < len(result); i += 2 {
+		commentID, err := strconv.ParseUint(result[i], 10, 64)
+		if err != nil {
+			continue
+		}
+		score, err := strconv.ParseFloat(result[i+1], 64)
+		if err != nil {
+			continue
+		}
+		listZ = append(listZ, redis.Z{Score: score, Member: commentID})
+	}
+	return listZ, nil
+}
@@ -1,21 +1,48 @@
 package service
 
+import (
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"time"
+)
+
 type void struct{}
 
 var member void
 
 // Redis 中 key 的模板
 var (
-	Header               = ""
-	UserPattern          = "user:%d"
-	UserFavoritePattern  = "favorite:%d"
-	CelebrityPattern     = "celebrity:%d"
-	FollowerPattern      = "follower:%d"
-	VideoPattern         = "Video:%d"
-	CommentPattern       = "Comment:%d"
-	VideoCommentsPattern = "CommentsOfVideo:%d"
-	PublishPattern       = "Publish:%d"
-	EmptyPattern         = "Empty:%d"
+	Header                  = ""
+	UserPattern             = "user:%d"
+	UserFavoritePattern     = "favorite:%d"
+	UserCommentLikePattern  = "commentLike:%d"
+	CelebrityPattern        = "celebrity:%d"
+	FollowerPattern         = "follower:%d"
+	UserBlockPattern        = "block:%d"
+	UserMutePattern         = "mute:%d"
+	VideoPattern            = "Video:%d"
+	CommentPattern          = "Comment:%d"
+	VideoCommentsPattern    = "CommentsOfVideo:%d"
+	CommentRepliesPattern   = "RepliesOfComment:%d"
+	VideoTopCommentsPattern = "TopCommentsOfVideo:%d"
+	PublishPattern          = "Publish:%d"
+	EmptyPattern            = "Empty:%d"
+	InboxPattern            = "Inbox:%d"
+	BigAuthorKey            = "BigAuthors"
+	HotKey                  = "hot"
+	HotDecayLockPattern     = "HotDecayLock:%d"
+	TrendingTagsKey         = "TrendingTags"
+	TokenVersionPattern     = "TokenVersion:%d"
+	RevokedTokenPattern     = "RevokedToken:%s"
+	RebuildLockPattern      = "RebuildLock:%s"
+	CounterDeltaPattern     = "CounterDelta:%s:%s"
+	CounterFlushPattern     = "CounterFlush:%s:%s"
+	CounterFlushIDPattern   = "CounterFlushID:%s:%s"
+	CounterFlushLockKey     = "CounterFlushLock"
+	EventStreamKey          = "Events"
+	DeadEventStreamKey      = "DeadEvents"
+	OutboxEventPattern      = "OutboxEvent:%d"
+	CounterEventPattern     = "CounterEvent:%s"
 )
 
 // VideoFavoriteCountAPI 接收视频喜欢数目的 api 结构体
@@ -23,3 +50,45 @@ type VideoFavoriteCountAPI struct {
 	VideoID       uint64
 	FavoriteCount int64
 }
+
+// keysetPage 按 (updated_at, idColumn) 倒序分页，多取一条用于判断是否还有更多
+func keysetPage(db *gorm.DB, idColumn string, cursor *util.Cursor, limit int) *gorm.DB {
+	if cursor != nil {
+		updatedAt := time.UnixMilli(int64(cursor.Score))
+		db = db.Where("(updated_at < ? or (updated_at = ? and "+idColumn+" < ?))", updatedAt, updatedAt, cursor.ID)
+	}
+	return db.Order("updated_at desc, " + idColumn + " desc").Limit(limit + 1)
+}
+
+// keysetNextCursor 根据本页最后一条记录生成下一页的游标
+func keysetNextCursor(updatedAt time.Time, id uint64) *util.Cursor {
+	return &util.Cursor{Score: float64(updatedAt.UnixMilli()), ID: id}
+}
+
+// scoreHit 按分数排序的查询结果
+type scoreHit struct {
+	ID    uint64
+	Score float64
+}
+
+// scoreKeysetPage 按 (scoreExpr, idColumn) 倒序分页查询 ID 和分数，scoreArgs 为 scoreExpr 中占位符对应的参数
+// scoreExpr 需要在相同数据上得到完全相同的结果，保证游标中的分数可以与重新计算的结果精确比较；没有更多时返回的游标为 nil
+func scoreKeysetPage(db *gorm.DB, idColumn string, scoreExpr string, scoreArgs []interface{}, cursor *util.Cursor, limit int) ([]scoreHit, *util.Cursor, error) {
+	db = db.Select(idColumn+" as id, "+scoreExpr+" as score", scoreArgs...)
+	if cursor != nil {
+		args := append(append([]interface{}{}, scoreArgs...), cursor.Score)
+		args = append(append(args, scoreArgs...), cursor.Score, cursor.ID)
+		db = db.Where("("+scoreExpr+" < ? or ("+scoreExpr+" = ? and "+idColumn+" < ?))", args...)
+	}
+	var hitList []scoreHit
+	if err := db.Order("score desc, " + idColumn + " desc").Limit(limit + 1).Scan(&hitList).Error; err != nil {
+		return nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(hitList) > limit {
+		hitList = hitList[:limit]
+		last := hitList[limit-1]
+		nextCursor = &util.Cursor{Score: last.Score, ID: last.ID}
+	}
+	return hitList, nextCursor, nil
+}
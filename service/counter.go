@@ -0,0 +1,277 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// counter 数据库中的计数列，计数变化先累加到 Redis 的增量哈希中，由写回协程批量写回数据库
+type counter struct {
+	table    string // 表名
+	idColumn string // 主键列名
+	column   string // 计数列名
+}
+
+var (
+	videoFavoriteCounter      = counter{table: "videos", idColumn: "video_id", column: "favorite_count"}
+	videoCommentCounter       = counter{table: "videos", idColumn: "video_id", column: "comment_count"}
+	userFollowCounter         = counter{table: "users", idColumn: "id", column: "follow_count"}
+	userFollowerCounter       = counter{table: "users", idColumn: "id", column: "follower_count"}
+	userFavoriteCounter       = counter{table: "users", idColumn: "id", column: "favorite_count"}
+	userTotalFavoritedCounter = counter{table: "users", idColumn: "id", column: "total_favorited"}
+	counterList               = []counter{videoFavoriteCounter, videoCommentCounter, userFollowCounter,
+		userFollowerCounter, userFavoriteCounter, userTotalFavoritedCounter}
+)
+
+// counterDelta 某一行计数列的增量
+type counterDelta struct {
+	counter counter
+	id      uint64
+	delta   int64
+}
+
+// incrCounters 记录计数增量，Redis 已熔断时直接写入数据库
+// 请求发出后才失败时无法确定 Redis 是否已执行，不再写入数据库，避免重复计数
+func incrCounters(deltas ...counterDelta) error {
+	if cacheBreaker.State() == util.BreakerClosed {
+		err := IncrCounterDeltasInRedis(deltas)
+		if err == ErrCacheUnavailable {
+			log.Println("counter deltas may be lost:", err)
+			return nil
+		}
+		return err
+	}
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		for _, each := range deltas {
+			if err := applyCounterDelta(tx, each.counter, each.id, each.delta); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// handleCounterEvent 根据点赞、评论和关注事件更新计数，重复投递的事件只计数一次
+// 熔断期间直接调用时事件没有 ID，事件未写入事件流，由 incrCounters 写入数据库
+func handleCounterEvent(event *Event) error {
+	var deltas []counterDelta
+	switch event.Type {
+	case EventFavoriteAdded, EventFavoriteRemoved:
+		delta := int64(1)
+		if event.Type == EventFavoriteRemoved {
+			delta = -1
+		}
+		deltas = []counterDelta{{videoFavoriteCounter, event.VideoID, delta}, {userFavoriteCounter, event.ActorID, delta},
+			{userTotalFavoritedCounter, event.UserID, delta}}
+	case EventCommentAdded:
+		deltas = []counterDelta{{videoCommentCounter, event.VideoID, 1}}
+	case EventCommentDeleted:
+		// 回复随顶层评论一并删除
+		deltas = []counterDelta{{videoCommentCounter, event.VideoID, -event.Count}}
+	case EventFollowAdded, EventFollowRemoved:
+		delta := int64(1)
+		if event.Type == EventFollowRemoved {
+			delta = -1
+		}
+		deltas = []counterDelta{{userFollowCounter, event.ActorID, delta}, {userFollowerCounter, event.UserID, delta}}
+	default:
+		return nil
+	}
+	if event.ID == "" {
+		return incrCounters(deltas...)
+	}
+	// 执行结果不确定时返回错误，重新投递后根据去重标记判断是否已经计数
+	return IncrCounterDeltasOnceInRedis(event.ID, deltas)
+}
+
+// applyCounterDelta 将增量写入数据库中的计数列，计数不会小于 0
+func applyCounterDelta(tx *gorm.DB, c counter, id uint64, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	return tx.Table(c.table).Where(c.idColumn+" = ?", id).
+		UpdateColumn(c.column, gorm.Expr("greatest("+c.column+" + ?, 0)", delta)).Error
+}
+
+// getPendingCounterDeltaList 返回尚未写回数据库的计数增量，Redis 不可用时增量视为 0
+func getPendingCounterDeltaList(c counter, idList []uint64) ([]int64, error) {
+	if len(idList) == 0 {
+		return nil, nil
+	}
+	deltaList, err := GetCounterDeltaListFromRedis(c, idList)
+	if err == ErrCacheUnavailable {
+		return make([]int64, len(idList)), nil
+	}
+	return deltaList, err
+}
+
+// addPendingVideoCounts 在数据库查出的视频计数上加上尚未写回的增量
+func addPendingVideoCounts(videoList []model.Video) error {
+	videoIDList := make([]uint64, len(videoList))
+	for i, video := range videoList {
+		videoIDList[i] = video.VideoID
+	}
+	favoriteDeltaList, err := getPendingCounterDeltaList(videoFavoriteCounter, videoIDList)
+	if err != nil {
+		return err
+	}
+	commentDeltaList, err := getPendingCounterDeltaList(videoCommentCounter, videoIDList)
+	if err != nil {
+		return err
+	}
+	for i := range videoList {
+		videoList[i].FavoriteCount += favoriteDeltaList[i]
+		videoList[i].CommentCount += commentDeltaList[i]
+	}
+	return nil
+}
+
+// addPendingUserCounts 在数据库查出的用户计数上加上尚未写回的增量
+func addPendingUserCounts(userList []model.User) error {
+	userIDList := make([]uint64, len(userList))
+	for i, user := range userList {
+		userIDList[i] = user.UserID
+	}
+	followDeltaList, err := getPendingCounterDeltaList(userFollowCounter, userIDList)
+	if err != nil {
+		return err
+	}
+	followerDeltaList, err := getPendingCounterDeltaList(userFollowerCounter, userIDList)
+	if err != nil {
+		return err
+	}
+	favoriteDeltaList, err := getPendingCounterDeltaList(userFavoriteCounter, userIDList)
+	if err != nil {
+		return err
+	}
+	totalFavoritedDeltaList, err := getPendingCounterDeltaList(userTotalFavoritedCounter, userIDList)
+	if err != nil {
+		return err
+	}
+	for i := range userList {
+		userList[i].FollowCount += followDeltaList[i]
+		userList[i].FollowerCount += followerDeltaList[i]
+		userList[i].FavoriteCount += favoriteDeltaList[i]
+		userList[i].TotalFavorited += totalFavoritedDeltaList[i]
+	}
+	return nil
+}
+
+// StartCounterFlush 启动计数写回协程，周期性将 Redis 中的计数增量写回数据库
+func StartCounterFlush() {
+	go func() {
+		ticker := time.NewTicker(global.COUNTER_FLUSH_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := FlushCounters(); err != nil && err != ErrCacheUnavailable {
+				log.Println("flush counters failed:", err)
+			}
+		}
+	}()
+}
+
+// FlushCounters 将全部计数增量写回数据库，多个实例同时运行时通过锁保证每次只由一个实例写回
+func FlushCounters() error {
+	token, _ := global.ID_GENERATOR.NextID()
+	ok, err := AcquireCounterFlushLock(token)
+	if err != nil || !ok {
+		return err
+	}
+	defer func() {
+		if err := ReleaseCounterFlushLock(token); err != nil && err != ErrCacheUnavailable {
+			log.Println("release counter flush lock failed:", err)
+		}
+	}()
+	for _, c := range counterList {
+		if err = flushCounter(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flushCounter 分批将一个计数列的增量写回数据库，每批在一个事务中更新，成功后删除对应的增量
+// 已写回的行与计数列在同一事务中记录，删除增量前异常退出时，重试会跳过这些行
+func flushCounter(c counter) error {
+	newFlushID, _ := global.ID_GENERATOR.NextID()
+	flushID, err := PrepareCounterFlushInRedis(c, newFlushID)
+	if err != nil || flushID == 0 {
+		return err
+	}
+	var cursor uint64
+	for {
+		idList, deltaList, nextCursor, err := ScanCounterFlushFromRedis(c, cursor, int64(global.COUNTER_FLUSH_BATCH))
+		if err != nil {
+			return err
+		}
+		if err = global.DB.Transaction(func(tx *gorm.DB) error {
+			return applyCounterFlush(tx, c, flushID, idList, deltaList)
+		}); err != nil {
+			return err
+		}
+		if err = DeleteCounterFlushFromRedis(c, idList); err != nil {
+			return err
+		}
+		if nextCursor == 0 {
+			break
+		}
+		cursor = nextCursor
+	}
+	// 增量已全部删除，不会再重试，清理写回记录
+	if err = global.DB.Where("flush_id = ?", flushID).Delete(&model.CounterFlush{}).Error; err != nil {
+		log.Printf("delete counter flush %d failed: %v\n", flushID, err)
+	}
+	return nil
+}
+
+// applyCounterFlush 写回一批增量并记录已写回的行，跳过本次写回中已经写回的行
+func applyCounterFlush(tx *gorm.DB, c counter, flushID uint64, idList []uint64, deltaList []int64) error {
+	if len(idList) == 0 {
+		return nil
+	}
+	var appliedIDList []uint64
+	if err := tx.Model(&model.CounterFlush{}).Where("flush_id = ? and row_id in ?", flushID, idList).
+		Pluck("row_id", &appliedIDList).Error; err != nil {
+		return err
+	}
+	appliedSet := make(map[uint64]void, len(appliedIDList))
+	for _, id := range appliedIDList {
+		appliedSet[id] = member
+	}
+	flushList := make([]model.CounterFlush, 0, len(idList))
+	for i, id := range idList {
+		if _, ok := appliedSet[id]; ok {
+			continue
+		}
+		if err := applyCounterDelta(tx, c, id, deltaList[i]); err != nil {
+			return err
+		}
+		flushList = append(flushList, model.CounterFlush{FlushID: flushID, RowID: id})
+	}
+	if len(flushList) == 0 {
+		return nil
+	}
+	return tx.Create(&flushList).Error
+}
+
+// BackfillCounters 根据已有数据重新计算全部计数列，用于新增计数列后初始化
+func BackfillCounters() error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("update videos set " +
+			"favorite_count = (select count(*) from favorites where favorites.video_id = videos.video_id and favorites.is_favorite = true), " +
+			"comment_count = (select count(*) from comments where comments.video_id = videos.video_id and comments.deleted_at is null)").
+			Error; err != nil {
+			return err
+		}
+		return tx.Exec("update users set " +
+			"follow_count = (select count(*) from follows where follows.follower_id = users.id and follows.is_follow = true), " +
+			"follower_count = (select count(*) from follows where follows.celebrity_id = users.id and follows.is_follow = true), " +
+			"favorite_count = (select count(*) from favorites where favorites.user_id = users.id and favorites.is_favorite = true), " +
+			"total_favorited = (select count(*) from favorites join videos on videos.video_id = favorites.video_id " +
+			"where videos.author_id = users.id and videos.deleted_at is null and favorites.is_favorite = true)").Error
+	})
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+)
+
+// IncrCounterDeltasInRedis 将计数增量累加到增量哈希中，等待写回数据库
+func IncrCounterDeltasInRedis(deltas []counterDelta) error {
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		for _, each := range deltas {
+			deltaRedis := fmt.Sprintf(CounterDeltaPattern, each.counter.table, each.counter.column)
+			pipe.HIncrBy(global.CONTEXT, deltaRedis, strconv.FormatUint(each.id, 10), each.delta)
+		}
+		return nil
+	})
+	return err
+}
+
+// IncrCounterDeltasOnceInRedis 将事件引起的计数增量累加到增量哈希中，同一事件只累加一次
+func IncrCounterDeltasOnceInRedis(eventID string, deltas []counterDelta) error {
+	keys := []string{fmt.Sprintf(CounterEventPattern, eventID)}
+	values := []interface{}{int64(global.EVENT_HANDLED_EXPIRE.Seconds())}
+	for _, each := range deltas {
+		keys = append(keys, fmt.Sprintf(CounterDeltaPattern, each.counter.table, each.counter.column))
+		values = append(values, strconv.FormatUint(each.id, 10), each.delta)
+	}
+	lua := redis.NewScript(`
+				if not redis.call("Set", KEYS[1], 1, "NX", "EX", ARGV[1]) then
+					return 0
+				end
+				for i = 2, #KEYS do
+					redis.call("HIncrBy", KEYS[i], ARGV[2 * i - 2], ARGV[2 * i - 1])
+				end
+				return 1
+			`)
+	return lua.Run(global.CONTEXT, global.REDIS, keys, values...).Err()
+}
+
+// GetCounterDeltaListFromRedis 返回尚未写回数据库的计数增量，包括正在写回的部分
+func GetCounterDeltaListFromRedis(c counter, idList []uint64) ([]int64, error) {
+	fields := make([]string, len(idList))
+	for i, id := range idList {
+		fields[i] = strconv.FormatUint(id, 10)
+	}
+	cmds, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		pipe.HMGet(global.CONTEXT, fmt.Sprintf(CounterDeltaPattern, c.table, c.column), fields...)
+		pipe.HMGet(global.CONTEXT, fmt.Sprintf(CounterFlushPattern, c.table, c.column), fields...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	deltaList := make([]int64, len(idList))
+	for _, cmd := range cmds {
+		for i, value := range cmd.(*redis.SliceCmd).Val() {
+			if str, ok := value.(string); ok {
+				delta, _ := strconv.ParseInt(str, 10, 64)
+				deltaList[i] += delta
+			}
+		}
+	}
+	return deltaList, nil
+}
+
+// PrepareCounterFlushInRedis 将增量哈希改名为写回哈希，之后的增量写入新的增量哈希，返回本次写回的编号
+// 上次写回未完成时继续写回剩余部分，沿用上次的编号；没有需要写回的增量时返回 0
+func PrepareCounterFlushInRedis(c counter, flushID uint64) (uint64, error) {
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[2]) > 0 then
+					local flush_id = redis.call("Get", KEYS[3])
+					if flush_id then
+						return flush_id
+					end
+					redis.call("Set", KEYS[3], ARGV[1])
+					return ARGV[1]
+				end
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("Rename", KEYS[1], KEYS[2])
+					redis.call("Set", KEYS[3], ARGV[1])
+					return ARGV[1]
+				end
+				return false
+			`)
+	keys := []string{fmt.Sprintf(CounterDeltaPattern, c.table, c.column), fmt.Sprintf(CounterFlushPattern, c.table, c.column),
+		fmt.Sprintf(CounterFlushIDPattern, c.table, c.column)}
+	values := []interface{}{flushID}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values...).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return result, err
+}
+
+// ScanCounterFlushFromRedis 从 cursor 开始读取一批待写回的计数增量，返回的游标为 0 时表示读取完毕
+func ScanCounterFlushFromRedis(c counter, cursor uint64, count int64) ([]uint64, []int64, uint64, error) {
+	flushRedis := fmt.Sprintf(CounterFlushPattern, c.table, c.column)
+	kvList, nextCursor, err := global.REDIS.HScan(global.CONTEXT, flushRedis, cursor, "", count).Result()
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	idList := make([]uint64, 0, len(kvList)/2)
+	deltaList := make([]int64, 0, len(kvList)/2)
+	for i := 0; i+1 < len(kvList); i += 2 {
+		id, err := strconv.ParseUint(kvList[i], 10, 64)
+		if err != nil {
+			continue
+		}
+		delta, err := strconv.ParseInt(kvList[i+1], 10, 64)
+		if err != nil {
+			continue
+		}
+		idList = append(idList, id)
+		deltaList = append(deltaList, delta)
+	}
+	return idList, deltaList, nextCursor, nil
+}
+
+// DeleteCounterFlushFromRedis 删除已写回数据库的计数增量
+func DeleteCounterFlushFromRedis(c counter, idList []uint64) error {
+	if len(idList) == 0 {
+		return nil
+	}
+	fields := make([]string, len(idList))
+	for i, id := range idList {
+		fields[i] = strconv.FormatUint(id, 10)
+	}
+	return global.REDIS.HDel(global.CONTEXT, fmt.Sprintf(CounterFlushPattern, c.table, c.column), fields...).Err()
+}
+
+// AcquireCounterFlushLock 尝试获取写回锁，token 用于释放时确认锁仍由自己持有
+func AcquireCounterFlushLock(token uint64) (bool, error) {
+	return global.REDIS.SetNX(global.CONTEXT, CounterFlushLockKey, token, global.COUNTER_FLUSH_LOCK_EXPIRE).Result()
+}
+
+// ReleaseCounterFlushLock 释放写回锁，锁已过期并被其它实例获取时不删除
+func ReleaseCounterFlushLock(token uint64) error {
+	lua := redis.NewScript(`
+				if redis.call("Get", KEYS[1]) == ARGV[1] then
+					return redis.call("Del", KEYS[1])
+				end
+				return 0
+			`)
+	keys := []string{CounterFlushLockKey}
+	values := []interface{}{token}
+	return lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// 领域事件类型
+const (
+	EventVideoPublished  = "VideoPublished"  // 视频转码完成或到达定时发布时间，出现在视频流中
+	EventFavoriteAdded   = "FavoriteAdded"   // 点赞
+	EventFavoriteRemoved = "FavoriteRemoved" // 取消点赞
+	EventCommentAdded    = "CommentAdded"    // 评论或回复
+	EventCommentDeleted  = "CommentDeleted"  // 删除评论或回复
+	EventFollowAdded     = "FollowAdded"     // 关注
+	EventFollowRemoved   = "FollowRemoved"   // 取消关注
+)
+
+// Event 领域事件，与事件类型无关的字段为 0
+type Event struct {
+	Type          string `json:"type"`
+	ActorID       uint64 `json:"actor_id"`         // 触发事件的用户
+	UserID        uint64 `json:"user_id"`          // 被关注的用户，或视频作者
+	VideoID       uint64 `json:"video_id"`         // 相关视频
+	CommentID     uint64 `json:"comment_id"`       // 相关评论
+	ParentID      uint64 `json:"parent_id"`        // 回复所属的顶层评论，顶层评论为 0
+	ReplyToUserID uint64 `json:"reply_to_user_id"` // 被回复的用户
+	Count         int64  `json:"count"`            // 删除评论时一并删除的评论数目，包括回复
+	CreatedAt     int64  `json:"created_at"`       // 事件发生的毫秒时间戳
+	ID            string `json:"-"`                // 事件流中的消息 ID，用于消费者去重；直接调用消费者时为空
+}
+
+// EventHandler 事件处理函数，返回错误时事件会被重新投递，因此需要保证重复处理的结果一致
+type EventHandler func(event *Event) error
+
+// eventConsumer 事件消费者，每个消费者对应一个消费者组，独立确认、重试和进入死信队列
+type eventConsumer struct {
+	group   string
+	start   string // 首次创建消费者组时开始消费的位置，"0" 为保留的最早事件，"$" 为之后的新事件
+	types   map[string]bool
+	handler EventHandler
+}
+
+var eventConsumerList []*eventConsumer
+
+// newEventConsumer 创建只处理指定类型事件的消费者
+func newEventConsumer(group string, start string, handler EventHandler, types ...string) *eventConsumer {
+	consumer := &eventConsumer{group: group, start: start, types: make(map[string]bool, len(types)), handler: handler}
+	for _, each := range types {
+		consumer.types[each] = true
+	}
+	return consumer
+}
+
+// Emit 业务操作成功后发出领域事件，由各消费者组异步处理
+// 熔断期间在当前协程中直接调用各消费者，处理失败只记录日志，不影响业务操作
+func Emit(event Event) {
+	emitEvent(event, 0)
+}
+
+// emitOutboxEvent 发出发件箱记录对应的领域事件，按 outboxID 去重
+func emitOutboxEvent(outboxID uint64, event Event) {
+	emitEvent(event, outboxID)
+}
+
+// emitEvent 发出领域事件，outboxID 不为 0 时同一条发件箱记录只发出一次
+// 只有发送前已熔断才直接调用各消费者：发送超时时事件可能已写入事件流，再直接处理会因没有事件 ID 无法去重而重复处理
+func emitEvent(event Event, outboxID uint64) {
+	event.CreatedAt = time.Now().UnixMilli()
+	if cacheBreaker.State() == util.BreakerClosed {
+		payload, err := json.Marshal(&event)
+		if err != nil {
+			log.Printf("marshal event %s failed: %v\n", event.Type, err)
+			return
+		}
+		if outboxID != 0 {
+			err = AddOutboxEventToRedis(outboxID, event.Type, string(payload))
+		} else {
+			err = AddEventToRedis(event.Type, string(payload))
+		}
+		if err != nil {
+			log.Printf("emit event %s failed, event may be lost: %v\n", event.Type, err)
+		}
+		return
+	}
+	for _, consumer := range eventConsumerList {
+		if !consumer.types[event.Type] {
+			continue
+		}
+		if err := consumer.handler(&event); err != nil {
+			log.Printf("handle event %s in %s failed: %v\n", event.Type, consumer.group, err)
+		}
+	}
+}
+
+// StartEventConsumers 注册事件消费者并启动消费协程，多个实例的同名消费者组共同分担事件
+func StartEventConsumers() {
+	eventConsumerList = []*eventConsumer{
+		newEventConsumer("notification", "0", handleNotificationEvent, EventFavoriteAdded, EventCommentAdded, EventFollowAdded),
+		// 计数此前在业务操作中同步更新，首次创建时不处理已有的事件，避免重复计数
+		newEventConsumer("counter", "$", handleCounterEvent, EventFavoriteAdded, EventFavoriteRemoved,
+			EventCommentAdded, EventCommentDeleted, EventFollowAdded, EventFollowRemoved),
+	}
+	hostname, _ := os.Hostname()
+	name := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	for _, consumer := range eventConsumerList {
+		go consumer.consume(name)
+		go consumer.retry(name)
+	}
+}
+
+// consume 循环读取并处理新事件，Redis 不可用时等待恢复
+func (c *eventConsumer) consume(name string) {
+	groupReady := false
+	start := c.start
+	for {
+		if !groupReady {
+			if err := CreateEventGroupInRedis(c.group, start); err != nil {
+				if err != ErrCacheUnavailable {
+					log.Printf("create event group %s failed: %v\n", c.group, err)
+				}
+				time.Sleep(global.EVENT_READ_BLOCK)
+				continue
+			}
+			groupReady = true
+		}
+		msgList, err := ReadEventsFromRedis(c.group, name)
+		if err != nil {
+			if err != ErrCacheUnavailable {
+				log.Printf("read events of %s failed: %v\n", c.group, err)
+			}
+			// 事件流被删除后重新创建消费者组，从新事件流的开头消费
+			if strings.HasPrefix(err.Error(), "NOGROUP") {
+				groupReady = false
+				start = "0"
+			}
+			time.Sleep(global.EVENT_READ_BLOCK)
+			continue
+		}
+		for _, msg := range msgList {
+			c.handle(msg)
+		}
+	}
+}
+
+// retry 周期性接管超时未确认的事件并重新处理，并清理过期的去重记录
+func (c *eventConsumer) retry(name string) {
+	ticker := time.NewTicker(global.EVENT_RETRY_INTERVAL)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := global.DB.Where("group_name = ? and created_at < ?", c.group, time.Now().Add(-global.EVENT_HANDLED_EXPIRE)).
+			Delete(&model.HandledEvent{}).Error; err != nil {
+			log.Printf("delete handled events of %s failed: %v\n", c.group, err)
+		}
+		msgList, err := ClaimStaleEventsFromRedis(c.group, name)
+		if err != nil {
+			if err != ErrCacheUnavailable && !strings.HasPrefix(err.Error(), "NOGROUP") {
+				log.Printf("claim stale events of %s failed: %v\n", c.group, err)
+			}
+			continue
+		}
+		for _, msg := range msgList {
+			c.handle(msg)
+		}
+	}
+}
+
+// handle 处理一条事件，成功或与当前消费者无关时确认；处理失败时不确认，等待重新投递
+func (c *eventConsumer) handle(msg redis.XMessage) {
+	event, err := parseEvent(msg)
+	if err != nil {
+		if err = MoveEventToDeadLetterInRedis(c.group, msg, err.Error()); err != nil {
+			log.Printf("move event %s of %s to dead letter failed: %v\n", msg.ID, c.group, err)
+		}
+		return
+	}
+	if c.types[event.Type] {
+		if err = c.handler(event); err != nil {
+			log.Printf("handle event %s in %s failed: %v\n", msg.ID, c.group, err)
+			return
+		}
+	}
+	if err = AckEventInRedis(c.group, msg.ID); err != nil {
+		log.Printf("ack event %s of %s failed: %v\n", msg.ID, c.group, err)
+	}
+}
+
+// parseEvent 解析事件流中的消息
+func parseEvent(msg redis.XMessage) (*Event, error) {
+	payload, ok := msg.Values["payload"].(string)
+	if !ok {
+		return nil, errors.New("event payload is missing")
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(payload), &event); err != nil {
+		return nil, err
+	}
+	event.ID = msg.ID
+	return &event, nil
+}
+
+// handleEventOnce 在事务中处理事件并记录事件已处理，重复投递的事件直接跳过；事件没有 ID 时不去重
+func handleEventOnce(group string, event *Event, fn func(tx *gorm.DB) error) error {
+	return global.DB.Transaction(func(tx *gorm.DB) error {
+		if event.ID != "" {
+			result := tx.Clauses(clause.Insert{Modifier: "IGNORE"}).
+				Create(&model.HandledEvent{GroupName: group, EventID: event.ID})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+		}
+		return fn(tx)
+	})
+}
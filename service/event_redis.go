@@ -0,0 +1,131 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/go-redis/redis/v8"
+	"strings"
+)
+
+// AddEventToRedis 将事件追加到事件流，超出保留数目时裁剪最早的事件
+func AddEventToRedis(eventType string, payload string) error {
+	return global.REDIS.XAdd(global.CONTEXT, &redis.XAddArgs{
+		Stream: EventStreamKey,
+		MaxLen: global.EVENT_STREAM_MAX_LEN,
+		Approx: true,
+		Values: []interface{}{"type", eventType, "payload", payload},
+	}).Err()
+}
+
+// AddOutboxEventToRedis 将发件箱记录对应的事件追加到事件流，同一条记录只追加一次，重放时不会重复发出
+func AddOutboxEventToRedis(outboxID uint64, eventType string, payload string) error {
+	keyOutboxEvent := fmt.Sprintf(OutboxEventPattern, outboxID)
+	lua := redis.NewScript(`
+				if redis.call("Set", KEYS[2], 1, "NX", "EX", ARGV[1]) then
+					redis.call("XAdd", KEYS[1], "MAXLEN", "~", ARGV[2], "*", "type", ARGV[3], "payload", ARGV[4])
+					return 1
+				end
+				return 0
+			`)
+	keys := []string{EventStreamKey, keyOutboxEvent}
+	values := []interface{}{int64(global.OUTBOX_EVENT_EXPIRE.Seconds()), global.EVENT_STREAM_MAX_LEN, eventType, payload}
+	return lua.Run(global.CONTEXT, global.REDIS, keys, values...).Err()
+}
+
+// CreateEventGroupInRedis 创建消费者组，从 start 开始消费；消费者组已存在时忽略
+func CreateEventGroupInRedis(group string, start string) error {
+	err := global.REDIS.XGroupCreateMkStream(global.CONTEXT, EventStreamKey, group, start).Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// ReadEventsFromRedis 读取投递给消费者组的新事件，没有新事件时阻塞等待
+func ReadEventsFromRedis(group string, consumer string) ([]redis.XMessage, error) {
+	streams, err := global.REDIS.XReadGroup(global.CONTEXT, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{EventStreamKey, ">"},
+		Count:    global.EVENT_READ_COUNT,
+		Block:    global.EVENT_READ_BLOCK,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var msgList []redis.XMessage
+	for _, stream := range streams {
+		msgList = append(msgList, stream.Messages...)
+	}
+	return msgList, nil
+}
+
+// AckEventInRedis 确认事件已处理
+func AckEventInRedis(group string, id string) error {
+	return global.REDIS.XAck(global.CONTEXT, EventStreamKey, group, id).Err()
+}
+
+// ClaimStaleEventsFromRedis 将超时未确认的事件转交给当前消费者重新处理
+// 投递次数达到上限的事件移入死信队列并确认，不再重试
+func ClaimStaleEventsFromRedis(group string, consumer string) ([]redis.XMessage, error) {
+	pendingList, err := global.REDIS.XPendingExt(global.CONTEXT, &redis.XPendingExtArgs{
+		Stream: EventStreamKey,
+		Group:  group,
+		Start:  "-",
+		End:    "+",
+		Count:  global.EVENT_READ_COUNT,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	var retryIDList []string
+	for _, pending := range pendingList {
+		if pending.Idle < global.EVENT_RETRY_IDLE {
+			continue
+		}
+		if pending.RetryCount < global.EVENT_MAX_DELIVERY {
+			retryIDList = append(retryIDList, pending.ID)
+			continue
+		}
+		msgList, err := global.REDIS.XRangeN(global.CONTEXT, EventStreamKey, pending.ID, pending.ID, 1).Result()
+		if err != nil {
+			return nil, err
+		}
+		// 事件已被裁剪时只确认
+		msg := redis.XMessage{ID: pending.ID, Values: map[string]interface{}{}}
+		if len(msgList) > 0 {
+			msg = msgList[0]
+		}
+		if err = MoveEventToDeadLetterInRedis(group, msg, "too many deliveries"); err != nil {
+			return nil, err
+		}
+	}
+	if len(retryIDList) == 0 {
+		return nil, nil
+	}
+	return global.REDIS.XClaim(global.CONTEXT, &redis.XClaimArgs{
+		Stream:   EventStreamKey,
+		Group:    group,
+		Consumer: consumer,
+		MinIdle:  global.EVENT_RETRY_IDLE,
+		Messages: retryIDList,
+	}).Result()
+}
+
+// MoveEventToDeadLetterInRedis 将无法处理的事件连同消费者组和原因写入死信队列，并确认原事件
+func MoveEventToDeadLetterInRedis(group string, msg redis.XMessage, reason string) error {
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(global.CONTEXT, &redis.XAddArgs{
+			Stream: DeadEventStreamKey,
+			MaxLen: global.EVENT_STREAM_MAX_LEN,
+			Approx: true,
+			Values: []interface{}{"group", group, "event_id", msg.ID, "type", msg.Values["type"],
+				"payload", msg.Values["payload"], "reason", reason},
+		})
+		pipe.XAck(global.CONTEXT, EventStreamKey, group, msg.ID)
+		return nil
+	})
+	return err
+}
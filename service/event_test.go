@@ -0,0 +1,89 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/go-redis/redis/v8"
+	"testing"
+)
+
+// findEventID 返回消息列表中指定视频的测试事件的 ID
+func findEventID(msgList []redis.XMessage, videoID uint64) string {
+	for _, msg := range msgList {
+		payload, _ := msg.Values["payload"].(string)
+		var event service.Event
+		if json.Unmarshal([]byte(payload), &event) == nil && event.Type == "Test" && event.VideoID == videoID {
+			return msg.ID
+		}
+	}
+	return ""
+}
+
+func TestEventRetryAndDeadLetter(t *testing.T) {
+	videoID, _ := global.ID_GENERATOR.NextID()
+	group := fmt.Sprintf("test-%d", videoID)
+	// 只消费之后写入的事件
+	if err := service.CreateEventGroupInRedis(group, "$"); err != nil {
+		t.Fatal(err)
+	}
+	defer global.REDIS.XGroupDestroy(global.CONTEXT, service.EventStreamKey, group)
+	retryIdle := global.EVENT_RETRY_IDLE
+	global.EVENT_RETRY_IDLE = 0
+	defer func() { global.EVENT_RETRY_IDLE = retryIdle }()
+
+	payload, _ := json.Marshal(service.Event{Type: "Test", VideoID: videoID})
+	if err := service.AddEventToRedis("Test", string(payload)); err != nil {
+		t.Fatal(err)
+	}
+	// 首次投递后不确认，模拟处理失败或消费者异常退出
+	msgList, err := service.ReadEventsFromRedis(group, "consumer-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	eventID := findEventID(msgList, videoID)
+	if eventID == "" {
+		t.Fatal("event is not delivered")
+	}
+	// 未确认的事件被其它消费者接管并重新投递
+	for delivery := int64(1); delivery < global.EVENT_MAX_DELIVERY; delivery++ {
+		msgList, err = service.ClaimStaleEventsFromRedis(group, "consumer-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if findEventID(msgList, videoID) != eventID {
+			t.Fatalf("event is not claimed after %d deliveries", delivery)
+		}
+	}
+	// 投递次数达到上限后移入死信队列，不再重新投递
+	msgList, err = service.ClaimStaleEventsFromRedis(group, "consumer-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findEventID(msgList, videoID) != "" {
+		t.Fatal("event is claimed after too many deliveries")
+	}
+	pendingList, err := global.REDIS.XPendingExt(global.CONTEXT, &redis.XPendingExtArgs{
+		Stream: service.EventStreamKey, Group: group, Start: eventID, End: eventID, Count: 1,
+	}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pendingList) != 0 {
+		t.Fatal("dead event is still pending")
+	}
+	deadList, err := global.REDIS.XRevRangeN(global.CONTEXT, service.DeadEventStreamKey, "+", "-", 100).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, msg := range deadList {
+		if msg.Values["group"] == group && msg.Values["event_id"] == eventID {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("event is not moved to dead letter")
+	}
+}
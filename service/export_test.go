@@ -0,0 +1,6 @@
+package service
+
+// SetRebuildLoadHook 设置重建缓存查询数据库前的回调，仅用于测试
+func SetRebuildLoadHook(hook func(key string)) {
+	onRebuildLoad = hook
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
 )
 
 // GetFavoriteStatusForUpdate 获取点赞状态，此处是针对 AddFavorite 和 CancelFavorite
@@ -12,7 +13,7 @@ func GetFavoriteStatusForUpdate(userID, videoID uint64) (bool, error) {
 	favoriteStatus, err := GetFavoriteStatusFromRedis(userID, videoID)
 	if err == nil {
 		return favoriteStatus, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return false, err
 	}
 	// 缓存不存在，查询数据库
@@ -22,10 +23,16 @@ func GetFavoriteStatusForUpdate(userID, videoID uint64) (bool, error) {
 		return false, result.Error
 	}
 	// 更新缓存
-	if err = AddFavoriteVideoIDListByUserIDToRedis(userID, favoriteList); err != nil {
+	if err = AddFavoriteVideoIDListByUserIDToRedis(userID, favoriteList); err != nil && err != ErrCacheUnavailable {
 		return false, err
 	}
-	return GetFavoriteStatusFromRedis(userID, videoID)
+	// 根据查询结果判断，Redis 不可用时同样适用
+	for _, each := range favoriteList {
+		if each.VideoID == videoID {
+			return each.IsFavorite, nil
+		}
+	}
+	return false, errors.New("no tracking information")
 }
 
 // AddFavorite 点赞
@@ -62,8 +69,10 @@ func AddFavorite(userID, videoID uint64) error {
 	} else if result.RowsAffected == 0 {
 		return errors.New("video 表中 video_id 不存在")
 	}
+	// 发出事件，计数由事件消费者更新
+	Emit(Event{Type: EventFavoriteAdded, ActorID: userID, UserID: video.AuthorID, VideoID: videoID})
 	// 更新缓存
-	if err := AddFavoriteForRedis(videoID, userID, video.AuthorID); err != nil {
+	if err := AddFavoriteForRedis(videoID, userID, video.AuthorID); err != nil && err != ErrCacheUnavailable {
 		return err
 	}
 	return nil
@@ -92,8 +101,10 @@ func CancelFavorite(userID, videoID uint64) error {
 	} else if result.RowsAffected == 0 {
 		return errors.New("video 表中 video_id 不存在")
 	}
+	// 发出事件，计数由事件消费者更新
+	Emit(Event{Type: EventFavoriteRemoved, ActorID: userID, UserID: video.AuthorID, VideoID: videoID})
 	// 更新缓存
-	if err := CancelFavoriteForRedis(videoID, userID, video.AuthorID); err != nil {
+	if err := CancelFavoriteForRedis(videoID, userID, video.AuthorID); err != nil && err != ErrCacheUnavailable {
 		return err
 	}
 	return nil
@@ -105,7 +116,7 @@ func GetFavoriteVideoIDListByUserID(userID uint64) ([]uint64, error) {
 	favoriteVideoIDList, err := GetFavoriteVideoIDListByUserIDFromRedis(userID)
 	if err == nil {
 		return favoriteVideoIDList, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return nil, err
 	}
 	// 缓存不存在，查询数据库
@@ -115,7 +126,7 @@ func GetFavoriteVideoIDListByUserID(userID uint64) ([]uint64, error) {
 		return nil, result.Error
 	}
 	// 更新缓存
-	if err = AddFavoriteVideoIDListByUserIDToRedis(userID, favoriteList); err != nil {
+	if err = AddFavoriteVideoIDListByUserIDToRedis(userID, favoriteList); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
 	// 后续操作，返回点赞视频 ID 列表
@@ -142,6 +153,32 @@ func GetFavoriteListByUserID(userID uint64) ([]model.Video, error) {
 	return videoList, nil
 }
 
+// GetFavoriteListByUserIDPage 按点赞时间倒序分页获取用户点赞视频列表，没有更多时返回的游标为 nil
+func GetFavoriteListByUserIDPage(userID uint64, cursor *util.Cursor, limit int) ([]model.Video, *util.Cursor, error) {
+	var favoriteList []model.Favorite
+	db := global.DB.Model(&model.Favorite{}).Select("favorite_id", "video_id", "updated_at").
+		Where("user_id = ? and is_favorite = ?", userID, true)
+	if err := keysetPage(db, "favorite_id", cursor, limit).Find(&favoriteList).Error; err != nil {
+		return nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(favoriteList) > limit {
+		favoriteList = favoriteList[:limit]
+		last := favoriteList[limit-1]
+		nextCursor = keysetNextCursor(last.UpdatedAt, last.FavoriteID)
+	}
+	favoriteVideoIDList := make([]uint64, len(favoriteList))
+	for i, each := range favoriteList {
+		favoriteVideoIDList[i] = each.VideoID
+	}
+	// 后续处理，返回点赞视频列表
+	var videoList []model.Video
+	if err := GetVideoListByIDsRedis(&videoList, favoriteVideoIDList); err != nil {
+		return nil, nil, err
+	}
+	return videoList, nextCursor, nil
+}
+
 // GetFavoriteStatusList 根据 userID 和 videoIDList 返回点赞状态列表
 func GetFavoriteStatusList(userID uint64, videoIDList []uint64) ([]bool, error) {
 	// 通过用户 ID 查询点赞视频 ID 列表
@@ -169,18 +206,29 @@ func GetFavoriteCountListByVideoIDList(videoIDList []uint64) ([]int64, error) {
 	favoriteCountList, notInCache, err := GetFavoriteCountListByVideoIDListFromRedis(videoIDList)
 	if err == nil {
 		return favoriteCountList, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return nil, err
 	}
-	// 缓存没有找到，数据库查询
+	// 缓存没有找到，查询数据库中的计数列，并加上尚未写回的增量
 	var uniqueVideoList []VideoFavoriteCountAPI
-	result := global.DB.Model(&model.Favorite{}).Select("video_id", "COUNT(video_id) as favorite_count").
-		Where("video_id in ? and is_favorite = ?", notInCache, true).Group("video_id").Find(&uniqueVideoList)
+	result := global.DB.Model(&model.Video{}).Select("video_id", "favorite_count").
+		Where("video_id in ?", notInCache).Find(&uniqueVideoList)
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	foundIDList := make([]uint64, len(uniqueVideoList))
+	for i, each := range uniqueVideoList {
+		foundIDList[i] = each.VideoID
+	}
+	deltaList, err := getPendingCounterDeltaList(videoFavoriteCounter, foundIDList)
+	if err != nil {
+		return nil, err
+	}
+	for i := range uniqueVideoList {
+		uniqueVideoList[i].FavoriteCount += deltaList[i]
+	}
 	// 更新缓存
-	if err = AddFavoriteCountListByUVideoIDListToCache(uniqueVideoList); err != nil {
+	if err = AddFavoriteCountListByUVideoIDListToCache(uniqueVideoList); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
 	// 后续操作，返回点赞数量列表
@@ -60,175 +60,59 @@ func AddFavoriteVideoIDListByUserIDToRedis(userID uint64, favoriteList []model.F
 }
 
 func AddFavoriteForRedis(videoID, userID, authorID uint64) error {
-	// 设置管道
-	ch := make(chan error, 2)
-	defer close(ch)
-
-	// 更新 userFavoriteRedis 缓存
-	go func() {
-		// 定义 key
-		userFavoriteRedis := fmt.Sprintf(UserFavoritePattern, userID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("ZAdd", KEYS[1], 1, ARGV[1])
-					redis.call("Expire", KEYS[1], ARGV[2])
-					return true
-				end
-				return false
-			`)
-		keys := []string{userFavoriteRedis}
-		values := []interface{}{videoID, global.FAVORITE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 userRedis 缓存
-	go func() {
-		// 定义 key
-		userRedis := fmt.Sprintf(UserPattern, userID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "favorite_count", 1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
-				end
-				return false
-			`)
-		keys := []string{userRedis}
-		values := []interface{}{global.USER_INFO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 authorRedis 缓存
-	go func() {
-		// 定义 key
-		authorRedis := fmt.Sprintf(UserPattern, authorID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "total_favorited", 1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
-				end
-				return false
-			`)
-		keys := []string{authorRedis}
-		values := []interface{}{global.USER_INFO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 videoRedis 缓存
-	go func() {
-		// 定义 key
-		videoRedis := fmt.Sprintf(VideoPattern, videoID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "favorite_count", 1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
-				end
-				return false
-			`)
-		keys := []string{videoRedis}
-		values := []interface{}{global.VIDEO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	var err error
-	for i := 0; i < 4; i++ {
-		errTmp := <-ch
-		if errTmp != nil && errTmp != redis.Nil {
-			err = errTmp
-		}
+	if err := updateFavoriteInRedis(videoID, userID, authorID, true); err != nil {
+		return err
 	}
-	return err
+	// 更新热榜
+	return IncrHotScore(videoID, global.HOT_FAVORITE_WEIGHT)
 }
 
 func CancelFavoriteForRedis(videoID, userID, authorID uint64) error {
-	// 设置管道
-	ch := make(chan error, 2)
-	defer close(ch)
+	if err := updateFavoriteInRedis(videoID, userID, authorID, false); err != nil {
+		return err
+	}
+	// 更新热榜
+	return IncrHotScore(videoID, -global.HOT_FAVORITE_WEIGHT)
+}
 
-	// 更新 userFavoriteRedis 缓存
-	go func() {
-		// 定义 key
-		userFavoriteRedis := fmt.Sprintf(UserFavoritePattern, userID)
-		lua := redis.NewScript(`
+// updateFavoriteInRedis 在一个脚本中修改点赞集合、用户点赞数目、作者获赞总数和视频点赞数目，不存在的缓存跳过
+func updateFavoriteInRedis(videoID, userID, authorID uint64, isFavorite bool) error {
+	// 定义 key
+	userFavoriteRedis := fmt.Sprintf(UserFavoritePattern, userID)
+	userRedis := fmt.Sprintf(UserPattern, userID)
+	authorRedis := fmt.Sprintf(UserPattern, authorID)
+	videoRedis := fmt.Sprintf(VideoPattern, videoID)
+	lua := redis.NewScript(`
 				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("ZAdd", KEYS[1], 0, ARGV[1])
-					redis.call("Expire", KEYS[1], ARGV[2])
-					return true
+					redis.call("ZAdd", KEYS[1], ARGV[2], ARGV[1])
+					redis.call("Expire", KEYS[1], ARGV[4])
 				end
-				return false
-			`)
-		keys := []string{userFavoriteRedis}
-		values := []interface{}{videoID, global.FAVORITE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 userRedis 缓存
-	go func() {
-		// 定义 key
-		userRedis := fmt.Sprintf(UserPattern, userID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "favorite_count", -1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
+				if redis.call("Exists", KEYS[2]) > 0 then
+					redis.call("HIncrBy", KEYS[2], "favorite_count", ARGV[3])
+					redis.call("Expire", KEYS[2], ARGV[5])
 				end
-				return false
-			`)
-		keys := []string{userRedis}
-		values := []interface{}{global.USER_INFO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 authorRedis 缓存
-	go func() {
-		// 定义 key
-		authorRedis := fmt.Sprintf(UserPattern, authorID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "total_favorited", -1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
+				if redis.call("Exists", KEYS[3]) > 0 then
+					redis.call("HIncrBy", KEYS[3], "total_favorited", ARGV[3])
+					redis.call("Expire", KEYS[3], ARGV[5])
 				end
-				return false
-			`)
-		keys := []string{authorRedis}
-		values := []interface{}{global.USER_INFO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	// 更新 videoRedis 缓存
-	go func() {
-		// 定义 key
-		videoRedis := fmt.Sprintf(VideoPattern, videoID)
-		lua := redis.NewScript(`
-				if redis.call("Exists", KEYS[1]) > 0 then
-					redis.call("HIncrBy", KEYS[1], "favorite_count", -1)
-					redis.call("Expire", KEYS[1], ARGV[1])
-					return true
+				if redis.call("Exists", KEYS[4]) > 0 then
+					redis.call("HIncrBy", KEYS[4], "favorite_count", ARGV[3])
+					redis.call("Expire", KEYS[4], ARGV[6])
 				end
-				return false
+				return true
 			`)
-		keys := []string{videoRedis}
-		values := []interface{}{global.VIDEO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
-		_, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Bool()
-		ch <- err
-	}()
-
-	var err error
-	for i := 0; i < 4; i++ {
-		errTmp := <-ch
-		if errTmp != nil && errTmp != redis.Nil {
-			err = errTmp
-		}
+	score, delta := 0, -1
+	if isFavorite {
+		score, delta = 1, 1
+	}
+	keys := []string{userFavoriteRedis, userRedis, authorRedis, videoRedis}
+	values := []interface{}{videoID, score, delta,
+		global.FAVORITE_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds()),
+		global.USER_INFO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds()),
+		global.VIDEO_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
 	}
 	return err
 }
@@ -304,7 +188,7 @@ func GetFavoriteCountListByVideoIDListFromRedis(videoIDList []uint64) (favoriteC
 	notInCache = make([]uint64, 0, userNum)
 	for _, each := range videoIDList {
 		favoriteCount, err2 := GetFavoriteCountByVideoIDFromRedis(each)
-		if err2 != nil && err2.Error() != "not found in cache" {
+		if err2 != nil && !isCacheMiss(err2) {
 			return nil, nil, err2
 		} else if err2 == nil {
 			favoriteCountList = append(favoriteCountList, favoriteCount)
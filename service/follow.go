@@ -1,8 +1,11 @@
 package service
 
 import (
+	"errors"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"sort"
 )
 
 // GetFollowStatusForUpdate 获取关注状态，此处是针对 AddFollow 和 CancelFollow
@@ -11,7 +14,7 @@ func GetFollowStatusForUpdate(followerID, celebrityID uint64) (bool, error) {
 	followStatus, err := GetFollowStatusFromRedis(followerID, celebrityID)
 	if err == nil {
 		return followStatus, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return false, err
 	}
 	// 缓存不存在，查询数据库
@@ -21,10 +24,16 @@ func GetFollowStatusForUpdate(followerID, celebrityID uint64) (bool, error) {
 		return false, result.Error
 	}
 	// 更新缓存
-	if err = AddFollowIDListByUserIDToRedis(followerID, followList); err != nil {
+	if err = AddFollowIDListByUserIDToRedis(followerID, followList); err != nil && err != ErrCacheUnavailable {
 		return false, err
 	}
-	return GetFollowStatusFromRedis(followerID, celebrityID)
+	// 根据查询结果判断，Redis 不可用时同样适用
+	for _, each := range followList {
+		if each.CelebrityID == celebrityID {
+			return each.IsFollow, nil
+		}
+	}
+	return false, errors.New("no tracking information")
 }
 
 // GetFollowStatus 获取关注状态，此处是针对非更新操作
@@ -38,6 +47,10 @@ func GetFollowStatus(followerID, celebrityID uint64) (bool, error) {
 
 // AddFollow 关注
 func AddFollow(followerID, celebrityID uint64) error {
+	// 存在拉黑关系时不能关注
+	if err := CheckBlocked(followerID, celebrityID); err != nil {
+		return err
+	}
 	// 获取当前关注状态
 	if isFollow, err := GetFollowStatusForUpdate(followerID, celebrityID); err == nil {
 		if isFollow {
@@ -61,15 +74,22 @@ func AddFollow(followerID, celebrityID uint64) error {
 	} else {
 		return err
 	}
-	//更新缓存
-	if err := AddFollowForRedis(followerID, celebrityID); err != nil {
+	// 发出事件，计数由事件消费者更新
+	Emit(Event{Type: EventFollowAdded, ActorID: followerID, UserID: celebrityID})
+	// 更新缓存
+	if err := AddFollowForRedis(followerID, celebrityID); err != nil && err != ErrCacheUnavailable {
 		return err
 	}
-	return nil
+	// 关注列表变化，重建关注收件箱
+	return skipCacheUnavailable(DeleteInbox(followerID))
 }
 
 // CancelFollow 取消关注
 func CancelFollow(followerID, celebrityID uint64) error {
+	// 一并撤回尚未处理的关注申请
+	if err := CancelFollowRequest(followerID, celebrityID); err != nil {
+		return err
+	}
 	// 获取当前关注状态
 	if isFollow, err := GetFollowStatusForUpdate(followerID, celebrityID); err == nil {
 		if !isFollow {
@@ -80,14 +100,31 @@ func CancelFollow(followerID, celebrityID uint64) error {
 			Update("is_follow", false).Error; err != nil {
 			return err
 		}
+	} else if err.Error() == "no tracking information" {
+		return nil
 	} else {
 		return err
 	}
-	//更新缓存
-	if err := CancelFollowForRedis(followerID, celebrityID); err != nil {
+	// 发出事件，计数由事件消费者更新
+	Emit(Event{Type: EventFollowRemoved, ActorID: followerID, UserID: celebrityID})
+	// 更新缓存
+	if err := CancelFollowForRedis(followerID, celebrityID); err != nil && err != ErrCacheUnavailable {
 		return err
 	}
-	return nil
+	// 关注列表变化，重建关注收件箱
+	return skipCacheUnavailable(DeleteInbox(followerID))
+}
+
+// removeFollow 关注记录已从数据库中删除后，发出事件并更新缓存
+func removeFollow(followerID, celebrityID uint64) error {
+	// 发出事件，计数由事件消费者更新
+	Emit(Event{Type: EventFollowRemoved, ActorID: followerID, UserID: celebrityID})
+	// 更新缓存
+	if err := RemoveFollowForRedis(followerID, celebrityID); err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	// 关注列表变化，重建关注收件箱
+	return skipCacheUnavailable(DeleteInbox(followerID))
 }
 
 // GetFollowIDListByUserID 通过用户 ID 查询关注 ID 列表
@@ -96,7 +133,7 @@ func GetFollowIDListByUserID(followerID uint64) ([]uint64, error) {
 	celebrityIDList, err := GetFollowIDListByUserIDFromRedis(followerID)
 	if err == nil {
 		return celebrityIDList, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return nil, err
 	}
 	// 缓存不存在，查询数据库
@@ -106,13 +143,15 @@ func GetFollowIDListByUserID(followerID uint64) ([]uint64, error) {
 		return nil, result.Error
 	}
 	// 更新缓存
-	if err = AddFollowIDListByUserIDToRedis(followerID, followList); err != nil {
+	if err = AddFollowIDListByUserIDToRedis(followerID, followList); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
 	// 后续操作，返回关注 ID 列表
 	celebrityIDList = make([]uint64, 0, len(followList))
 	for _, each := range followList {
-		celebrityIDList = append(celebrityIDList, each.CelebrityID)
+		if each.IsFollow {
+			celebrityIDList = append(celebrityIDList, each.CelebrityID)
+		}
 	}
 	return celebrityIDList, nil
 
@@ -139,7 +178,7 @@ func GetFollowerIDListByUserID(celebrityID uint64) ([]uint64, error) {
 	followerIDList, err := GetFollowerIDListByUserIDFromRedis(celebrityID)
 	if err == nil {
 		return followerIDList, nil
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return nil, err
 	}
 	// 缓存不存在，查询数据库
@@ -150,7 +189,7 @@ func GetFollowerIDListByUserID(celebrityID uint64) ([]uint64, error) {
 		return nil, result.Error
 	}
 	// 更新缓存
-	if err = AddFollowerIDListByUserIDToRedis(celebrityID, followerList); err != nil {
+	if err = AddFollowerIDListByUserIDToRedis(celebrityID, followerList); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
 	// 后续操作，返回粉丝 ID 列表
@@ -176,6 +215,58 @@ func GetFollowerListByUserID(celebrityID uint64) ([]model.User, error) {
 	return followerList, nil
 }
 
+// GetFollowListByUserIDPage 按关注时间倒序分页获取用户关注列表，没有更多时返回的游标为 nil
+func GetFollowListByUserIDPage(followerID uint64, cursor *util.Cursor, limit int) ([]model.User, *util.Cursor, error) {
+	var followList []model.Follow
+	db := global.DB.Model(&model.Follow{}).Select("follow_id", "celebrity_id", "updated_at").
+		Where("follower_id = ? and is_follow = ?", followerID, true)
+	if err := keysetPage(db, "follow_id", cursor, limit).Find(&followList).Error; err != nil {
+		return nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(followList) > limit {
+		followList = followList[:limit]
+		last := followList[limit-1]
+		nextCursor = keysetNextCursor(last.UpdatedAt, last.FollowID)
+	}
+	celebrityIDList := make([]uint64, len(followList))
+	for i, each := range followList {
+		celebrityIDList[i] = each.CelebrityID
+	}
+	// 后续处理，返回用户关注列表
+	celebrityList, err := GetUserListByUserIDList(celebrityIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	return celebrityList, nextCursor, nil
+}
+
+// GetFollowerListByUserIDPage 按关注时间倒序分页获取用户粉丝列表，没有更多时返回的游标为 nil
+func GetFollowerListByUserIDPage(celebrityID uint64, cursor *util.Cursor, limit int) ([]model.User, *util.Cursor, error) {
+	var followList []model.Follow
+	db := global.DB.Model(&model.Follow{}).Select("follow_id", "follower_id", "updated_at").
+		Where("celebrity_id = ? and is_follow = ?", celebrityID, true)
+	if err := keysetPage(db, "follow_id", cursor, limit).Find(&followList).Error; err != nil {
+		return nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(followList) > limit {
+		followList = followList[:limit]
+		last := followList[limit-1]
+		nextCursor = keysetNextCursor(last.UpdatedAt, last.FollowID)
+	}
+	followerIDList := make([]uint64, len(followList))
+	for i, each := range followList {
+		followerIDList[i] = each.FollowerID
+	}
+	// 后续处理，返回用户粉丝列表
+	followerList, err := GetUserListByUserIDList(followerIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	return followerList, nextCursor, nil
+}
+
 // GetFollowStatusList 返回关注状态列表
 func GetFollowStatusList(followerID uint64, celebrityIDList []uint64) ([]bool, error) {
 	// 通过用户 ID 查询粉丝 ID 列表
@@ -196,3 +287,87 @@ func GetFollowStatusList(followerID uint64, celebrityIDList []uint64) ([]bool, e
 	}
 	return isFollowStatusList, nil
 }
+
+// GetFriendIDListByUserID 通过用户 ID 查询互相关注的好友 ID 列表
+func GetFriendIDListByUserID(userID uint64) ([]uint64, error) {
+	// 查询缓存
+	friendIDList, err := GetFriendIDListByUserIDFromRedis(userID)
+	if err == nil {
+		return friendIDList, nil
+	} else if !isCacheMiss(err) {
+		return nil, err
+	}
+	// 缓存不存在，分别查询关注与粉丝列表，同时写入缓存
+	celebrityIDList, err := GetFollowIDListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	followerIDList, err := GetFollowerIDListByUserID(userID)
+	if err != nil {
+		return nil, err
+	}
+	// 后续处理，取交集
+	mapFollowerID := make(map[uint64]void, len(followerIDList))
+	for _, each := range followerIDList {
+		mapFollowerID[each] = member
+	}
+	friendIDList = make([]uint64, 0, len(celebrityIDList))
+	for _, each := range celebrityIDList {
+		if _, ok := mapFollowerID[each]; ok {
+			friendIDList = append(friendIDList, each)
+		}
+	}
+	return friendIDList, nil
+}
+
+// GetFriendListByUserID 获取用户好友列表以及与每个好友的最新一条私信，有私信的好友按私信时间倒序排在前面
+func GetFriendListByUserID(userID uint64) ([]model.User, []*model.Message, error) {
+	friendIDList, err := GetFriendIDListByUserID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapFriendIDToMessage, err := GetLatestMessageMap(userID, friendIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(friendIDList, func(i, j int) bool {
+		mi, mj := mapFriendIDToMessage[friendIDList[i]], mapFriendIDToMessage[friendIDList[j]]
+		if mi == nil || mj == nil {
+			return mi != nil
+		}
+		return mi.MessageID > mj.MessageID
+	})
+	friendList, err := GetUserListByUserIDList(friendIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	messageList := make([]*model.Message, len(friendIDList))
+	for i, each := range friendIDList {
+		messageList[i] = mapFriendIDToMessage[each]
+	}
+	return friendList, messageList, nil
+}
+
+// GetFollowAndFriendStatusList 返回关注状态列表和好友状态列表，好友即互相关注
+func GetFollowAndFriendStatusList(userID uint64, userIDList []uint64) ([]bool, []bool, error) {
+	isFollowList, err := GetFollowStatusList(userID, userIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	// 通过用户 ID 查询粉丝 ID 列表
+	followerIDList, err := GetFollowerIDListByUserID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	mapFollowerID := make(map[uint64]void, len(followerIDList))
+	for _, each := range followerIDList {
+		mapFollowerID[each] = member
+	}
+	isFriendList := make([]bool, len(userIDList))
+	for i, each := range userIDList {
+		if _, ok := mapFollowerID[each]; ok && isFollowList[i] {
+			isFriendList[i] = true
+		}
+	}
+	return isFollowList, isFriendList, nil
+}
@@ -233,6 +233,25 @@ func CancelFollowForRedis(followerID, celebrityID uint64) error {
 	return err
 }
 
+// RemoveFollowForRedis 关注记录被删除后，从双方的关注关系中移除对方并减少关注数和粉丝数
+func RemoveFollowForRedis(followerID, celebrityID uint64) error {
+	lua := redis.NewScript(`
+				redis.call("ZRem", KEYS[1], ARGV[1])
+				redis.call("ZRem", KEYS[2], ARGV[2])
+				if redis.call("Exists", KEYS[3]) > 0 then
+					redis.call("HIncrBy", KEYS[3], "follow_count", -1)
+				end
+				if redis.call("Exists", KEYS[4]) > 0 then
+					redis.call("HIncrBy", KEYS[4], "follower_count", -1)
+				end
+				return true
+			`)
+	keys := []string{fmt.Sprintf(FollowerPattern, followerID), fmt.Sprintf(CelebrityPattern, celebrityID),
+		fmt.Sprintf(UserPattern, followerID), fmt.Sprintf(UserPattern, celebrityID)}
+	values := []interface{}{celebrityID, followerID}
+	return lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+}
+
 func GetFollowIDListByUserIDFromRedis(followerID uint64) ([]uint64, error) {
 	// 定义 key
 	followerRelationRedis := fmt.Sprintf(FollowerPattern, followerID)
@@ -277,6 +296,37 @@ func GetFollowerIDListByUserIDFromRedis(celebrityID uint64) ([]uint64, error) {
 	}
 }
 
+// GetFriendIDListByUserIDFromRedis 从关注与粉丝两个集合中取交集，得到互相关注的好友 ID 列表
+func GetFriendIDListByUserIDFromRedis(userID uint64) ([]uint64, error) {
+	// 定义 key
+	followerRelationRedis := fmt.Sprintf(FollowerPattern, userID)
+	celebrityRelationRedis := fmt.Sprintf(CelebrityPattern, userID)
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) <= 0 or redis.call("Exists", KEYS[2]) <= 0 then
+				return false
+			end
+			redis.call("Expire", KEYS[1], ARGV[1])
+			redis.call("Expire", KEYS[2], ARGV[1])
+			local friends = {}
+			for _, id in ipairs(redis.call("ZRangeByScore", KEYS[1], 1, 1)) do
+				if redis.call("ZScore", KEYS[2], id) == "1" then
+					table.insert(friends, id)
+				end
+			end
+			return friends
+			`)
+	keys := []string{followerRelationRedis, celebrityRelationRedis}
+	values := []interface{}{global.FOLLOW_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	result, err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Uint64Slice()
+	if err == nil {
+		return result, nil
+	} else if err == redis.Nil {
+		return nil, errors.New("not found in cache")
+	} else {
+		return nil, err
+	}
+}
+
 func AddFollowerIDListByUserIDToRedis(celebrityID uint64, followerList []model.Follow) error {
 	// 定义 key
 	celebrityRelationRedis := fmt.Sprintf(CelebrityPattern, celebrityID)
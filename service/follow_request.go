@@ -0,0 +1,169 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"gorm.io/gorm/clause"
+)
+
+// RequestFollow 关注用户，目标为私密账号时创建等待处理的关注申请，返回是否为等待处理状态
+func RequestFollow(followerID, celebrityID uint64) (bool, error) {
+	// 存在拉黑关系时不能关注
+	if err := CheckBlocked(followerID, celebrityID); err != nil {
+		return false, err
+	}
+	celebrity, err := UserInfoByUserID(celebrityID)
+	if err != nil {
+		return false, err
+	}
+	if !celebrity.IsPrivate {
+		return false, AddFollow(followerID, celebrityID)
+	}
+	// 已经关注的私密账号无需再次申请
+	if isFollow, err := GetFollowStatus(followerID, celebrityID); err != nil {
+		return false, err
+	} else if isFollow {
+		return false, nil
+	}
+	// 已有申请记录时重新置为等待处理，并发申请由唯一索引合并为一条
+	var request model.FollowRequest
+	request.RequestID, _ = global.ID_GENERATOR.NextID()
+	request.FollowerID = followerID
+	request.CelebrityID = celebrityID
+	request.Status = model.FollowRequestPending
+	return true, global.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "follower_id"}, {Name: "celebrity_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"status", "updated_at"}),
+	}).Create(&request).Error
+}
+
+// ApproveFollowRequest 同意关注申请，建立关注关系
+func ApproveFollowRequest(celebrityID, followerID uint64) error {
+	var count int64
+	if err := global.DB.Model(&model.FollowRequest{}).
+		Where("follower_id = ? and celebrity_id = ? and status = ?", followerID, celebrityID, model.FollowRequestPending).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return errors.New("follow request does not exist")
+	}
+	if err := AddFollow(followerID, celebrityID); err != nil {
+		return err
+	}
+	return global.DB.Model(&model.FollowRequest{}).
+		Where("follower_id = ? and celebrity_id = ? and status = ?", followerID, celebrityID, model.FollowRequestPending).
+		Update("status", model.FollowRequestApproved).Error
+}
+
+// RejectFollowRequest 拒绝关注申请
+func RejectFollowRequest(celebrityID, followerID uint64) error {
+	result := global.DB.Model(&model.FollowRequest{}).
+		Where("follower_id = ? and celebrity_id = ? and status = ?", followerID, celebrityID, model.FollowRequestPending).
+		Update("status", model.FollowRequestRejected)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("follow request does not exist")
+	}
+	return nil
+}
+
+// CancelFollowRequest 撤回尚未处理的关注申请
+func CancelFollowRequest(followerID, celebrityID uint64) error {
+	return global.DB.Where("follower_id = ? and celebrity_id = ? and status = ?", followerID, celebrityID, model.FollowRequestPending).
+		Delete(&model.FollowRequest{}).Error
+}
+
+// GetPendingFollowRequestList 获取用户待处理的关注申请，按申请时间倒序返回申请者列表
+func GetPendingFollowRequestList(celebrityID uint64) ([]model.User, error) {
+	var followerIDList []uint64
+	if err := global.DB.Model(&model.FollowRequest{}).
+		Where("celebrity_id = ? and status = ?", celebrityID, model.FollowRequestPending).
+		Order("updated_at desc").Pluck("follower_id", &followerIDList).Error; err != nil {
+		return nil, err
+	}
+	return GetUserListByUserIDList(followerIDList)
+}
+
+// SetUserPrivacy 修改账号隐私设置，改为公开账号时同意全部待处理的关注申请
+func SetUserPrivacy(userID uint64, isPrivate bool) error {
+	if err := global.DB.Model(&model.User{}).Where("id = ?", userID).Update("is_private", isPrivate).Error; err != nil {
+		return err
+	}
+	// 更新缓存
+	if err := SetUserPrivacyInRedis(userID, isPrivate); err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	if isPrivate {
+		return nil
+	}
+	var followerIDList []uint64
+	if err := global.DB.Model(&model.FollowRequest{}).
+		Where("celebrity_id = ? and status = ?", userID, model.FollowRequestPending).
+		Pluck("follower_id", &followerIDList).Error; err != nil {
+		return err
+	}
+	for _, followerID := range followerIDList {
+		if err := ApproveFollowRequest(userID, followerID); err != nil && err != ErrUserBlocked {
+			return err
+		}
+	}
+	return nil
+}
+
+// CanViewUserContent 判断当前用户能否查看指定用户的作品、点赞和关注列表，未登录时 viewerID 为 0
+func CanViewUserContent(viewerID, ownerID uint64) (bool, error) {
+	if viewerID == ownerID {
+		return true, nil
+	}
+	owner, err := UserInfoByUserID(ownerID)
+	if err != nil {
+		return false, err
+	}
+	if !owner.IsPrivate {
+		return true, nil
+	}
+	if viewerID == 0 {
+		return false, nil
+	}
+	return GetFollowStatus(viewerID, ownerID)
+}
+
+// authorVisibility 判断作者的视频对当前用户是否可见：未被拉黑或屏蔽，且不是未关注的私密账号
+type authorVisibility struct {
+	hiddenSet map[uint64]void
+	followSet map[uint64]void
+	viewerID  uint64
+}
+
+func newAuthorVisibility(viewerID uint64) (*authorVisibility, error) {
+	hiddenSet, err := GetHiddenUserIDSet(viewerID)
+	if err != nil {
+		return nil, err
+	}
+	followSet := make(map[uint64]void)
+	if viewerID != 0 {
+		celebrityIDList, err := GetFollowIDListByUserID(viewerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, each := range celebrityIDList {
+			followSet[each] = member
+		}
+	}
+	return &authorVisibility{hiddenSet: hiddenSet, followSet: followSet, viewerID: viewerID}, nil
+}
+
+func (v *authorVisibility) visible(author *model.User) bool {
+	if _, ok := v.hiddenSet[author.UserID]; ok {
+		return false
+	}
+	if !author.IsPrivate || author.UserID == v.viewerID {
+		return true
+	}
+	_, ok := v.followSet[author.UserID]
+	return ok
+}
@@ -0,0 +1,143 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+// hotScore 根据点赞数、评论数和发布时间估算视频热度，用于重建热榜和 Redis 不可用时的降级
+// 这里全部互动都从发布时间开始衰减，而增量更新中互动从发生时才开始衰减，因此结果低于缓存中的热度
+func hotScore(favoriteCount, commentCount int64, createdAt time.Time) float64 {
+	score := global.HOT_PUBLISH_WEIGHT +
+		float64(favoriteCount)*global.HOT_FAVORITE_WEIGHT +
+		float64(commentCount)*global.HOT_COMMENT_WEIGHT
+	periods := math.Floor(float64(time.Since(createdAt)) / float64(global.HOT_DECAY_INTERVAL))
+	return score * math.Pow(global.HOT_DECAY_FACTOR, periods)
+}
+
+// GoHot 确保热榜在缓存中，不存在时根据近期发布的视频重建
+func GoHot() error {
+	n, err := global.REDIS.Exists(global.CONTEXT, HotKey).Result()
+	if err != nil || n > 0 {
+		return err
+	}
+	listZ, err := getHotListSql()
+	if err != nil {
+		return err
+	}
+	return GoHotList(listZ...)
+}
+
+// getHotListSql 根据近期发布的视频计算热榜
+func getHotListSql() ([]*redis.Z, error) {
+	var videoList []model.Video
+	if err := global.DB.Where("status = ? and visibility = ? and created_at > ?",
+		model.VideoStatusReady, model.VideoVisibilityPublic, time.Now().Add(-global.HOT_WINDOW)).
+		Find(&videoList).Error; err != nil {
+		return nil, err
+	}
+	if len(videoList) == 0 {
+		return nil, nil
+	}
+	// 点赞数与评论数加上尚未写回的增量
+	if err := addPendingVideoCounts(videoList); err != nil {
+		return nil, err
+	}
+	listZ := make([]*redis.Z, 0, len(videoList))
+	for _, video := range videoList {
+		score := hotScore(video.FavoriteCount, video.CommentCount, video.CreatedAt)
+		if score < global.HOT_MIN_SCORE {
+			continue
+		}
+		listZ = append(listZ, &redis.Z{Score: score, Member: video.VideoID})
+	}
+	return listZ, nil
+}
+
+// getHotVideoIDListSql Redis 不可用时根据数据库计算热榜，按热度从高到低返回 [offset, offset+count) 的视频ID
+func getHotVideoIDListSql(offset int64, count int64) ([]uint64, error) {
+	listZ, err := getHotListSql()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(listZ, func(i, j int) bool {
+		return listZ[i].Score > listZ[j].Score
+	})
+	videoIDList := make([]uint64, 0, count)
+	for i := offset; i < int64(len(listZ)) && i < offset+count; i++ {
+		videoIDList = append(videoIDList, listZ[i].Member.(uint64))
+	}
+	return videoIDList, nil
+}
+
+// GetHotVideosAndAuthorsRedis 按热度获取视频以及其作者，返回本次消耗的热榜条目数和是否还有更多
+func GetHotVideosAndAuthorsRedis(viewerID uint64, videoList *[]model.Video, authors *[]model.User, offset int64, count int64) (int64, bool, error) {
+	// 确保热榜在 redis 中
+	if err := GoHot(); err != nil && err != ErrCacheUnavailable {
+		return 0, false, err
+	}
+	// 多取一条用于判断是否还有更多
+	videoIDList, err := GetHotVideoIDListFromRedis(offset, count+1)
+	if err == ErrCacheUnavailable {
+		// Redis 不可用，直接根据数据库计算
+		videoIDList, err = getHotVideoIDListSql(offset, count+1)
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	hasMore := int64(len(videoIDList)) > count
+	if hasMore {
+		videoIDList = videoIDList[:count]
+	}
+	consumed := int64(len(videoIDList))
+	if consumed == 0 {
+		*videoList = nil
+		*authors = nil
+		return 0, false, nil
+	}
+	var candidateList []model.Video
+	if err = GetVideoListByIDsRedis(&candidateList, videoIDList); err != nil {
+		return 0, false, err
+	}
+	// 批量获取视频作者
+	authorIDList := make([]uint64, len(candidateList))
+	for i, video := range candidateList {
+		authorIDList[i] = video.AuthorID
+	}
+	var candidateAuthorList []model.User
+	if err = GetUserListByUserIDs(authorIDList, &candidateAuthorList); err != nil {
+		return 0, false, err
+	}
+	// 过滤已不存在或非公开的视频，以及当前用户拉黑或屏蔽的作者和未关注的私密账号
+	visibility, err := newAuthorVisibility(viewerID)
+	if err != nil {
+		return 0, false, err
+	}
+	*videoList = make([]model.Video, 0, len(candidateList))
+	*authors = make([]model.User, 0, len(candidateList))
+	for i, video := range candidateList {
+		if video.VideoID != 0 && video.Visibility == model.VideoVisibilityPublic && visibility.visible(&candidateAuthorList[i]) {
+			*videoList = append(*videoList, video)
+			*authors = append(*authors, candidateAuthorList[i])
+		}
+	}
+	return consumed, hasMore, nil
+}
+
+// StartHotDecay 启动热度衰减协程，每半个周期检查一次，保证每个周期至少有一个实例执行衰减
+func StartHotDecay() {
+	go func() {
+		ticker := time.NewTicker(global.HOT_DECAY_INTERVAL / 2)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := DecayHotScoresInRedis(); err != nil {
+				log.Println("decay hot scores failed:", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+// IncrHotScore 增加视频的热度，热榜不在缓存中时跳过，由下次读取时重建
+func IncrHotScore(videoID uint64, delta float64) error {
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("ZIncrBy", KEYS[1], ARGV[1], ARGV[2])
+					return true
+				end
+				return false
+			`)
+	keys := []string{HotKey}
+	values := []interface{}{delta, videoID}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// AddHotScoreNX 将新发布的视频以初始热度加入热榜，已在热榜中时不修改，重复调用结果一致
+// 热榜超出长度上限时移除热度最低的视频
+func AddHotScoreNX(videoID uint64, score float64) error {
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("ZAdd", KEYS[1], "NX", ARGV[1], ARGV[2])
+					redis.call("ZRemRangeByRank", KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+					return true
+				end
+				return false
+			`)
+	keys := []string{HotKey}
+	values := []interface{}{score, videoID, global.HOT_MAX_LENGTH}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// GoHotList 将视频热度批量写入热榜，只保留热度最高的视频，并设置过期时间
+// 热榜正常情况下由衰减协程周期性续期，不再衰减时随过期删除
+func GoHotList(listZ ...*redis.Z) error {
+	if len(listZ) == 0 {
+		return nil
+	}
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(global.CONTEXT, HotKey, listZ...)
+		pipe.ZRemRangeByRank(global.CONTEXT, HotKey, 0, -int64(global.HOT_MAX_LENGTH)-1)
+		pipe.Expire(global.CONTEXT, HotKey, global.HOT_WINDOW)
+		return nil
+	})
+	return err
+}
+
+// GetHotVideoIDListFromRedis 按热度从高到低返回热榜中 [offset, offset+count) 的视频ID
+func GetHotVideoIDListFromRedis(offset int64, count int64) ([]uint64, error) {
+	videoIDStrList, err := global.REDIS.ZRevRange(global.CONTEXT, HotKey, offset, offset+count-1).Result()
+	if err != nil {
+		return nil, err
+	}
+	videoIDList := make([]uint64, 0, len(videoIDStrList))
+	for _, videoIDStr := range videoIDStrList {
+		videoID, err := strconv.ParseUint(videoIDStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		videoIDList = append(videoIDList, videoID)
+	}
+	return videoIDList, nil
+}
+
+// DecayHotScoresInRedis 热榜整体乘以衰减系数，移除热度过低和超出长度上限的视频，并续期
+// 多个实例同时运行时，通过按周期编号加的锁保证每个周期只衰减一次，锁保留到周期结束之后
+func DecayHotScoresInRedis() (bool, error) {
+	period := time.Now().UnixNano() / int64(global.HOT_DECAY_INTERVAL)
+	lockRedis := fmt.Sprintf(HotDecayLockPattern, period)
+	ok, err := global.REDIS.SetNX(global.CONTEXT, lockRedis, 1, 2*global.HOT_DECAY_INTERVAL).Result()
+	if err != nil || !ok {
+		return false, err
+	}
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) <= 0 then
+					return false
+				end
+				redis.call("ZUnionStore", KEYS[1], 1, KEYS[1], "Weights", ARGV[1])
+				redis.call("ZRemRangeByScore", KEYS[1], "-inf", "(" .. ARGV[2])
+				redis.call("ZRemRangeByRank", KEYS[1], 0, -tonumber(ARGV[3]) - 1)
+				redis.call("Expire", KEYS[1], ARGV[4])
+				return true
+			`)
+	keys := []string{HotKey}
+	values := []interface{}{global.HOT_DECAY_FACTOR, global.HOT_MIN_SCORE, global.HOT_MAX_LENGTH, int64(global.HOT_WINDOW.Seconds())}
+	err = lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return true, nil
+	}
+	return false, err
+}
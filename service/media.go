@@ -0,0 +1,61 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
+	"log"
+	"path"
+	"time"
+)
+
+// StartMediaGC 启动媒体文件回收协程
+func StartMediaGC() {
+	go func() {
+		ticker := time.NewTicker(global.MEDIA_GC_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := CollectDeletedVideoMedia(); err != nil {
+				log.Println("collect deleted video media failed:", err)
+			}
+		}
+	}()
+}
+
+// CollectDeletedVideoMedia 删除已删除超过 MEDIA_GC_DELAY 的视频的播放文件和封面，删除后清空文件名避免重复处理
+func CollectDeletedVideoMedia() error {
+	var videoList []model.Video
+	if err := global.DB.Unscoped().Select("video_id", "play_name", "cover_name").
+		Where("deleted_at < ? and (play_name <> '' or cover_name <> '')", time.Now().Add(-global.MEDIA_GC_DELAY)).
+		Find(&videoList).Error; err != nil {
+		return err
+	}
+	for _, video := range videoList {
+		if err := deleteVideoMedia(&video); err != nil {
+			log.Printf("delete media of video %d failed: %v\n", video.VideoID, err)
+			continue
+		}
+		if err := global.DB.Unscoped().Model(&model.Video{}).Where("video_id = ?", video.VideoID).
+			Updates(map[string]interface{}{"play_name": "", "cover_name": ""}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteVideoMedia 删除视频的播放文件和封面，HLS 视频删除其整个目录
+func deleteVideoMedia(video *model.Video) error {
+	if video.PlayName != "" {
+		if dir := path.Dir(video.PlayName); dir != "." {
+			if err := global.STORAGE.DeletePrefix(storage.VideoKey(dir)); err != nil {
+				return err
+			}
+		} else if err := global.STORAGE.Delete(storage.VideoKey(video.PlayName)); err != nil {
+			return err
+		}
+	}
+	if video.CoverName != "" {
+		return global.STORAGE.Delete(storage.CoverKey(video.CoverName))
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+)
+
+// saveMentions 解析文本中的 @用户名 并记录提及和通知，需在写入视频或评论的事务中调用
+func saveMentions(tx *gorm.DB, fromUserID uint64, videoID uint64, commentID uint64, text string) error {
+	userList, err := findMentionedUsers(tx, fromUserID, text)
+	if err != nil {
+		return err
+	}
+	return addMentions(tx, fromUserID, videoID, commentID, userList)
+}
+
+// updateTitleMentions 修改视频标题后更新标题中的提及，需在修改标题的事务中调用
+// 只为新提及的用户记录提及和通知，仍被提及的用户不重复通知，不再被提及的用户删除提及
+func updateTitleMentions(tx *gorm.DB, fromUserID uint64, videoID uint64, title string) error {
+	userList, err := findMentionedUsers(tx, fromUserID, title)
+	if err != nil {
+		return err
+	}
+	var oldList []model.Mention
+	if err = tx.Where("video_id = ? and comment_id = ?", videoID, 0).Find(&oldList).Error; err != nil {
+		return err
+	}
+	mentionedSet := make(map[uint64]void, len(userList))
+	for _, user := range userList {
+		mentionedSet[user.UserID] = member
+	}
+	oldSet := make(map[uint64]void, len(oldList))
+	var removedIDList []uint64
+	for _, mention := range oldList {
+		oldSet[mention.UserID] = member
+		if _, ok := mentionedSet[mention.UserID]; !ok {
+			removedIDList = append(removedIDList, mention.MentionID)
+		}
+	}
+	if len(removedIDList) > 0 {
+		if err = tx.Where("mention_id in ?", removedIDList).Delete(&model.Mention{}).Error; err != nil {
+			return err
+		}
+	}
+	addedList := make([]model.User, 0, len(userList))
+	for _, user := range userList {
+		if _, ok := oldSet[user.UserID]; !ok {
+			addedList = append(addedList, user)
+		}
+	}
+	return addMentions(tx, fromUserID, videoID, 0, addedList)
+}
+
+// findMentionedUsers 解析文本中的 @用户名 并查询被提及的用户
+// 不存在的用户名、提及自己以及与提及者存在拉黑关系的用户会被忽略
+func findMentionedUsers(tx *gorm.DB, fromUserID uint64, text string) ([]model.User, error) {
+	nameList := util.ParseMentions(text, global.MAX_MENTIONS)
+	if len(nameList) == 0 {
+		return nil, nil
+	}
+	var userList []model.User
+	if err := tx.Select("id", "name").Where("name in ?", nameList).Find(&userList).Error; err != nil {
+		return nil, err
+	}
+	mentionedList := make([]model.User, 0, len(userList))
+	for _, user := range userList {
+		if user.UserID == fromUserID {
+			continue
+		}
+		if err := CheckBlocked(fromUserID, user.UserID); err == ErrUserBlocked {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		mentionedList = append(mentionedList, user)
+	}
+	return mentionedList, nil
+}
+
+// addMentions 记录对指定用户的提及并通知被提及的用户
+func addMentions(tx *gorm.DB, fromUserID uint64, videoID uint64, commentID uint64, userList []model.User) error {
+	mentionList := make([]model.Mention, 0, len(userList))
+	for _, user := range userList {
+		mentionID, _ := global.ID_GENERATOR.NextID()
+		mentionList = append(mentionList, model.Mention{
+			MentionID:  mentionID,
+			UserID:     user.UserID,
+			UserName:   user.Name,
+			FromUserID: fromUserID,
+			VideoID:    videoID,
+			CommentID:  commentID,
+		})
+	}
+	if len(mentionList) == 0 {
+		return nil
+	}
+	if err := tx.Create(&mentionList).Error; err != nil {
+		return err
+	}
+	// 通知被提及的用户，与提及一并提交；屏蔽了提及者的用户不通知
+	for _, mention := range mentionList {
+		isMute, err := GetMuteStatusForUpdate(mention.UserID, fromUserID)
+		if err != nil && err.Error() != "no tracking information" {
+			return err
+		}
+		if isMute {
+			continue
+		}
+		if err = addNotification(tx, mention.UserID, fromUserID, model.NotificationTypeMention, mention.MentionID, videoID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTitleMentionMap 批量查询视频标题中提及的用户，返回视频 ID 到提及列表的映射
+func GetTitleMentionMap(videoIDList []uint64) (map[uint64][]model.Mention, error) {
+	mapVideoIDToMention := make(map[uint64][]model.Mention)
+	if len(videoIDList) == 0 {
+		return mapVideoIDToMention, nil
+	}
+	var mentionList []model.Mention
+	if err := global.DB.Where("video_id in ? and comment_id = ?", videoIDList, 0).
+		Order("mention_id").Find(&mentionList).Error; err != nil {
+		return nil, err
+	}
+	for _, mention := range mentionList {
+		mapVideoIDToMention[mention.VideoID] = append(mapVideoIDToMention[mention.VideoID], mention)
+	}
+	return mapVideoIDToMention, nil
+}
+
+// GetCommentMentionMap 批量查询评论中提及的用户，返回评论 ID 到提及列表的映射
+func GetCommentMentionMap(commentIDList []uint64) (map[uint64][]model.Mention, error) {
+	mapCommentIDToMention := make(map[uint64][]model.Mention)
+	if len(commentIDList) == 0 {
+		return mapCommentIDToMention, nil
+	}
+	var mentionList []model.Mention
+	if err := global.DB.Where("comment_id in ?", commentIDList).
+		Order("mention_id").Find(&mentionList).Error; err != nil {
+		return nil, err
+	}
+	for _, mention := range mentionList {
+		mapCommentIDToMention[mention.CommentID] = append(mapCommentIDToMention[mention.CommentID], mention)
+	}
+	return mapCommentIDToMention, nil
+}
+
+// GetMentionListByUserID 按时间倒序分页获取提及当前用户的通知及提及者，没有更多时返回的游标为 nil
+// 提及者已被当前用户拉黑或屏蔽，以及所在视频尚未发布或当前用户无权查看的通知不返回
+func GetMentionListByUserID(userID uint64, cursor *util.Cursor, limit int) ([]model.Mention, []model.User, *util.Cursor, error) {
+	db := global.DB.Where("user_id = ?", userID)
+	if cursor != nil {
+		db = db.Where("mention_id < ?", cursor.ID)
+	}
+	var mentionList []model.Mention
+	if err := db.Order("mention_id desc").Limit(limit + 1).Find(&mentionList).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(mentionList) > limit {
+		mentionList = mentionList[:limit]
+		nextCursor = &util.Cursor{ID: mentionList[limit-1].MentionID}
+	}
+	// 查询所在视频，过滤未发布、已删除和无权查看的视频
+	videoIDList := make([]uint64, len(mentionList))
+	for i, mention := range mentionList {
+		videoIDList[i] = mention.VideoID
+	}
+	var videoList []model.Video
+	if err := global.DB.Select("video_id", "author_id", "visibility").
+		Where("video_id in ? and status = ?", videoIDList, model.VideoStatusReady).Find(&videoList).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	videoList, err := FilterVisibleVideoList(userID, videoList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	visibleVideoSet := make(map[uint64]void, len(videoList))
+	for _, video := range videoList {
+		visibleVideoSet[video.VideoID] = member
+	}
+	hiddenSet, err := GetHiddenUserIDSet(userID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	visibleList := make([]model.Mention, 0, len(mentionList))
+	fromUserIDList := make([]uint64, 0, len(mentionList))
+	for _, mention := range mentionList {
+		if _, ok := visibleVideoSet[mention.VideoID]; !ok {
+			continue
+		}
+		if _, ok := hiddenSet[mention.FromUserID]; ok {
+			continue
+		}
+		visibleList = append(visibleList, mention)
+		fromUserIDList = append(fromUserIDList, mention.FromUserID)
+	}
+	fromUserList, err := GetUserListByUserIDList(fromUserIDList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return visibleList, fromUserList, nextCursor, nil
+}
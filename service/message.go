@@ -1,73 +1,242 @@
 package service
 
 import (
-	"encoding/json"
-	"fmt"
-	"github.com/RaymondCode/simple-demo/controller"
-	"io"
-	"net"
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/gorilla/websocket"
 	"sync"
+	"time"
 )
 
-var chatConnMap = sync.Map{}
-
-func RunMessageServer() {
-	listen, err := net.Listen("tcp", "127.0.0.1:9090")
+// SendMessage 发送私信，写入数据库
+func SendMessage(fromUserID, toUserID uint64, content string) (*model.Message, error) {
+	if fromUserID == toUserID {
+		return nil, errors.New("can not send message to yourself")
+	}
+	// 检查接收方是否存在
+	var count int64
+	if err := global.DB.Model(&model.User{}).Where("id = ?", toUserID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, errors.New("user does not exist")
+	}
+	// 存在拉黑关系时不能发送私信
+	if err := CheckBlocked(fromUserID, toUserID); err != nil {
+		return nil, err
+	}
+	messageID, err := global.ID_GENERATOR.NextID()
 	if err != nil {
-		fmt.Printf("Run message sever failed: %v\n", err)
-		return
+		return nil, err
+	}
+	message := model.Message{
+		MessageID:  messageID,
+		ToUserID:   toUserID,
+		FromUserID: fromUserID,
+		Content:    content,
+		CreatedAt:  time.Now(),
+	}
+	if err = global.DB.Create(&message).Error; err != nil {
+		return nil, err
 	}
+	return &message, nil
+}
 
-	for {
-		conn, err := listen.Accept()
-		if err != nil {
-			fmt.Printf("Accept conn failed: %v\n", err)
-			continue
-		}
+// GetMessageList 获取两个用户之间在 (preMsgTime, preMsgID) 之后的聊天记录，按时间和消息 ID 正序返回
+// 同一毫秒内可能有多条消息，只按时间比较会遗漏与上次最新消息同一毫秒的消息
+// 未提供 preMsgID 时返回与 preMsgTime 同一毫秒的全部消息，由客户端按消息 ID 去重
+func GetMessageList(userID, toUserID uint64, preMsgTime int64, preMsgID uint64) ([]model.Message, error) {
+	var messageList []model.Message
+	preTime := time.UnixMilli(preMsgTime)
+	db := global.DB.Where("(from_user_id = ? and to_user_id = ?) or (from_user_id = ? and to_user_id = ?)",
+		userID, toUserID, toUserID, userID)
+	if preMsgID != 0 {
+		db = db.Where("created_at > ? or (created_at = ? and id > ?)", preTime, preTime, preMsgID)
+	} else {
+		db = db.Where("created_at >= ?", preTime)
+	}
+	result := db.Order("created_at, id").Limit(global.MESSAGE_NUM).Find(&messageList)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return messageList, nil
+}
+
+// GetOfflineMessageList 获取用户在 lastMessageID 之后尚未送达的一页私信，按消息 ID 正序返回
+func GetOfflineMessageList(userID uint64, lastMessageID uint64, limit int) ([]model.Message, error) {
+	var messageList []model.Message
+	result := global.DB.Where("to_user_id = ? and is_delivered = ? and id > ?", userID, false, lastMessageID).
+		Order("id").Limit(limit).Find(&messageList)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return messageList, nil
+}
+
+// MarkMessageListDelivered 将私信标记为已送达
+func MarkMessageListDelivered(messageIDList []uint64) error {
+	if len(messageIDList) == 0 {
+		return nil
+	}
+	return global.DB.Model(&model.Message{}).Where("id in ?", messageIDList).
+		Update("is_delivered", true).Error
+}
+
+// MessageClient 用户一个设备上的 WebSocket 连接
+type MessageClient struct {
+	UserID  uint64
+	conn    *websocket.Conn
+	mu      sync.Mutex      // websocket 连接不支持并发写
+	flushed map[uint64]void // 连接建立时作为离线消息推送过的私信
+}
+
+// Push 向当前设备推送 json 数据
+func (client *MessageClient) Push(v interface{}) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.write(v)
+}
+
+// pushMessage 向当前设备推送私信，已作为离线消息推送过的私信不再重复推送
+func (client *MessageClient) pushMessage(messageID uint64, v interface{}) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if _, ok := client.flushed[messageID]; ok {
+		return nil
+	}
+	return client.write(v)
+}
 
-		go process(conn)
+// write 写入 json 数据，调用方需持有写锁
+func (client *MessageClient) write(v interface{}) error {
+	if err := client.conn.SetWriteDeadline(time.Now().Add(global.WS_WRITE_WAIT)); err != nil {
+		return err
 	}
+	return client.conn.WriteJSON(v)
 }
 
-func process(conn net.Conn) {
-	defer conn.Close()
+// Ping 向当前设备发送心跳
+func (client *MessageClient) Ping() error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(global.WS_WRITE_WAIT))
+}
+
+// 在线设备表 userID -> 该用户所有在线设备
+var (
+	messageClientMap   = make(map[uint64]map[*MessageClient]void)
+	messageClientMutex sync.RWMutex
+)
 
-	var buf [256]byte
+// RegisterMessageClient 登记用户的一个在线设备，并分页推送离线消息，newEvent 生成推送的数据
+// 推送离线消息期间持有设备的写锁，期间到达的实时私信排在离线消息之后，已推送过的不再重复推送
+// 推送失败时返回的设备已登记，由调用方注销
+func RegisterMessageClient(userID uint64, conn *websocket.Conn, newEvent func(message *model.Message) interface{}) (*MessageClient, error) {
+	client := &MessageClient{UserID: userID, conn: conn, flushed: make(map[uint64]void)}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	messageClientMutex.Lock()
+	if _, ok := messageClientMap[userID]; !ok {
+		messageClientMap[userID] = make(map[*MessageClient]void)
+	}
+	messageClientMap[userID][client] = member
+	messageClientMutex.Unlock()
+
+	var lastMessageID uint64
 	for {
-		n, err := conn.Read(buf[:])
-		if n == 0 {
-			if err == io.EOF {
+		messageList, err := GetOfflineMessageList(userID, lastMessageID, global.MESSAGE_NUM)
+		if err != nil {
+			return client, err
+		}
+		deliveredIDList := make([]uint64, 0, len(messageList))
+		for i := range messageList {
+			if err = client.write(newEvent(&messageList[i])); err != nil {
 				break
 			}
-			fmt.Printf("Read message failed: %v\n", err)
-			continue
+			client.flushed[messageList[i].MessageID] = member
+			deliveredIDList = append(deliveredIDList, messageList[i].MessageID)
 		}
+		if markErr := MarkMessageListDelivered(deliveredIDList); markErr != nil {
+			return client, markErr
+		}
+		if err != nil || len(messageList) < global.MESSAGE_NUM {
+			return client, err
+		}
+		lastMessageID = messageList[len(messageList)-1].MessageID
+	}
+}
 
-		var event = controller.MessageSendEvent{}
-		_ = json.Unmarshal(buf[:n], &event)
-		fmt.Printf("Receive Message：%+v\n", event)
-
-		fromChatKey := fmt.Sprintf("%d_%d", event.UserId, event.ToUserId)
-		if len(event.MsgContent) == 0 {
-			chatConnMap.Store(fromChatKey, conn)
-			continue
+// UnregisterMessageClient 注销设备并关闭连接
+func UnregisterMessageClient(client *MessageClient) {
+	messageClientMutex.Lock()
+	if clients, ok := messageClientMap[client.UserID]; ok {
+		delete(clients, client)
+		if len(clients) == 0 {
+			delete(messageClientMap, client.UserID)
 		}
+	}
+	messageClientMutex.Unlock()
+	_ = client.conn.Close()
+}
+
+// PushToUser 向用户所有在线设备推送私信，返回推送成功的设备数
+func PushToUser(userID uint64, messageID uint64, v interface{}) int {
+	messageClientMutex.RLock()
+	clientList := make([]*MessageClient, 0, len(messageClientMap[userID]))
+	for client := range messageClientMap[userID] {
+		clientList = append(clientList, client)
+	}
+	messageClientMutex.RUnlock()
 
-		toChatKey := fmt.Sprintf("%d_%d", event.ToUserId, event.UserId)
-		writeConn, exist := chatConnMap.Load(toChatKey)
-		if !exist {
-			fmt.Printf("User %d offline\n", event.ToUserId)
+	numPushed := 0
+	for _, client := range clientList {
+		if err := client.pushMessage(messageID, v); err != nil {
+			// 推送失败，视为设备已离线
+			UnregisterMessageClient(client)
 			continue
 		}
+		numPushed++
+	}
+	return numPushed
+}
 
-		pushEvent := controller.MessagePushEvent{
-			FromUserId: event.UserId,
-			MsgContent: event.MsgContent,
-		}
-		pushData, _ := json.Marshal(pushEvent)
-		_, err = writeConn.(net.Conn).Write(pushData)
-		if err != nil {
-			fmt.Printf("Push message failed: %v\n", err)
+// GetLatestMessageMap 查询用户与每个好友之间最新的一条私信，没有私信的好友不在结果中
+func GetLatestMessageMap(userID uint64, friendIDList []uint64) (map[uint64]*model.Message, error) {
+	mapFriendIDToMessage := make(map[uint64]*model.Message, len(friendIDList))
+	if len(friendIDList) == 0 {
+		return mapFriendIDToMessage, nil
+	}
+	// 消息 ID 随时间递增，每个会话中最大的 ID 即为最新私信
+	var latestList []struct {
+		MessageID uint64
+		FriendID  uint64
+	}
+	if err := global.DB.Model(&model.Message{}).
+		Select("max(id) as message_id, if(from_user_id = ?, to_user_id, from_user_id) as friend_id", userID).
+		Where("(from_user_id = ? and to_user_id in ?) or (to_user_id = ? and from_user_id in ?)",
+			userID, friendIDList, userID, friendIDList).
+		Group("friend_id").
+		Scan(&latestList).Error; err != nil {
+		return nil, err
+	}
+	messageIDList := make([]uint64, len(latestList))
+	for i, latest := range latestList {
+		messageIDList[i] = latest.MessageID
+	}
+	if len(messageIDList) == 0 {
+		return mapFriendIDToMessage, nil
+	}
+	var messageList []model.Message
+	if err := global.DB.Where("id in ?", messageIDList).Find(&messageList).Error; err != nil {
+		return nil, err
+	}
+	for i, message := range messageList {
+		friendID := message.FromUserID
+		if friendID == userID {
+			friendID = message.ToUserID
 		}
+		mapFriendIDToMessage[friendID] = &messageList[i]
 	}
+	return mapFriendIDToMessage, nil
 }
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// addNotification 写入通知，已有同一类型、同一对象的未读通知时聚合到该通知上
+// 触发者记录在 notification_actor 表中，同一用户多次触发只计数一次
+func addNotification(db *gorm.DB, userID, actorID uint64, notificationType int8, targetID, videoID uint64) error {
+	unread := true
+	notification := model.Notification{
+		UserID:      userID,
+		Type:        notificationType,
+		TargetID:    targetID,
+		Unread:      &unread,
+		VideoID:     videoID,
+		LastActorID: actorID,
+		ActorCount:  1,
+	}
+	notification.NotificationID, _ = global.ID_GENERATOR.NextID()
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&notification)
+	if result.Error != nil {
+		return result.Error
+	}
+	notificationID := notification.NotificationID
+	if result.RowsAffected == 0 {
+		// 已有未读通知，聚合到该通知上
+		var notificationIDList []uint64
+		if err := db.Model(&model.Notification{}).Where("user_id = ? and type = ? and target_id = ? and unread = ?",
+			userID, notificationType, targetID, true).Limit(1).Pluck("notification_id", &notificationIDList).Error; err != nil {
+			return err
+		} else if len(notificationIDList) == 0 {
+			// 通知在此期间被标记为已读，返回错误使事件重新投递
+			return gorm.ErrRecordNotFound
+		}
+		notificationID = notificationIDList[0]
+	}
+	result = db.Clauses(clause.Insert{Modifier: "IGNORE"}).
+		Create(&model.NotificationActor{NotificationID: notificationID, ActorID: actorID})
+	if result.Error != nil || result.RowsAffected == 0 {
+		// 该用户已经计数
+		return result.Error
+	}
+	return db.Model(&model.Notification{}).Where("notification_id = ?", notificationID).Updates(map[string]interface{}{
+		"actor_count":   db.Model(&model.NotificationActor{}).Select("count(*)").Where("notification_id = ?", notificationID),
+		"last_actor_id": actorID,
+		"updated_at":    time.Now(),
+	}).Error
+}
+
+// isNotificationHidden 接收者与触发者存在拉黑关系，或接收者屏蔽了触发者时不通知
+func isNotificationHidden(userID, actorID uint64) (bool, error) {
+	if err := CheckBlocked(userID, actorID); err == ErrUserBlocked {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	isMute, err := GetMuteStatusForUpdate(userID, actorID)
+	if err != nil && err.Error() != "no tracking information" {
+		return false, err
+	}
+	return isMute, nil
+}
+
+// notify 通知相关用户，自己触发的事件不通知
+func notify(db *gorm.DB, userID, actorID uint64, notificationType int8, targetID, videoID uint64) error {
+	if userID == 0 || userID == actorID {
+		return nil
+	}
+	hidden, err := isNotificationHidden(userID, actorID)
+	if err != nil || hidden {
+		return err
+	}
+	return addNotification(db, userID, actorID, notificationType, targetID, videoID)
+}
+
+// handleNotificationEvent 根据点赞、评论和关注事件通知相关用户
+// 重复投递的事件不再处理，避免已读的通知因重新投递再次出现
+func handleNotificationEvent(event *Event) error {
+	return handleEventOnce("notification", event, func(tx *gorm.DB) error {
+		switch event.Type {
+		case EventFavoriteAdded:
+			return notify(tx, event.UserID, event.ActorID, model.NotificationTypeFavorite, event.VideoID, event.VideoID)
+		case EventCommentAdded:
+			if event.ParentID != 0 {
+				if err := notify(tx, event.ReplyToUserID, event.ActorID, model.NotificationTypeReply, event.ParentID, event.VideoID); err != nil {
+					return err
+				}
+				// 被回复者就是视频作者时只通知一次
+				if event.ReplyToUserID == event.UserID {
+					return nil
+				}
+			}
+			return notify(tx, event.UserID, event.ActorID, model.NotificationTypeComment, event.VideoID, event.VideoID)
+		case EventFollowAdded:
+			return notify(tx, event.UserID, event.ActorID, model.NotificationTypeFollow, 0, 0)
+		}
+		return nil
+	})
+}
+
+// GetNotificationListByUserID 按通知 ID 倒序分页获取用户的通知及每条通知最近的触发者，没有更多时返回的游标为 nil
+// 通知 ID 在聚合时不变，翻页期间有新的触发者也不会导致重复或遗漏
+// 触发者已被拉黑或屏蔽，以及相关视频、评论或提及已被删除的通知不返回
+func GetNotificationListByUserID(userID uint64, cursor *util.Cursor, limit int) ([]model.Notification, []model.User, *util.Cursor, error) {
+	var notificationList []model.Notification
+	db := global.DB.Where("user_id = ?", userID)
+	if cursor != nil {
+		db = db.Where("notification_id < ?", cursor.ID)
+	}
+	if err := db.Order("notification_id desc").Limit(limit + 1).Find(&notificationList).Error; err != nil {
+		return nil, nil, nil, err
+	}
+	var nextCursor *util.Cursor
+	if len(notificationList) > limit {
+		notificationList = notificationList[:limit]
+		nextCursor = &util.Cursor{ID: notificationList[limit-1].NotificationID}
+	}
+	visibleList, err := filterVisibleNotifications(userID, notificationList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	actorIDList := make([]uint64, 0, len(visibleList))
+	for _, notification := range visibleList {
+		actorIDList = append(actorIDList, notification.LastActorID)
+	}
+	actorList, err := GetUserListByUserIDList(actorIDList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return visibleList, actorList, nextCursor, nil
+}
+
+// filterVisibleNotifications 过滤触发者已被拉黑或屏蔽，以及相关视频、评论或提及已被删除或不可见的通知
+func filterVisibleNotifications(userID uint64, notificationList []model.Notification) ([]model.Notification, error) {
+	// 查询仍然存在的视频、评论和提及
+	var videoIDList, commentIDList, mentionIDList []uint64
+	for _, notification := range notificationList {
+		if notification.VideoID != 0 {
+			videoIDList = append(videoIDList, notification.VideoID)
+		}
+		switch notification.Type {
+		case model.NotificationTypeReply:
+			commentIDList = append(commentIDList, notification.TargetID)
+		case model.NotificationTypeMention:
+			mentionIDList = append(mentionIDList, notification.TargetID)
+		}
+	}
+	var videoList []model.Video
+	if len(videoIDList) > 0 {
+		if err := global.DB.Select("video_id", "author_id", "visibility").
+			Where("video_id in ? and status = ?", videoIDList, model.VideoStatusReady).Find(&videoList).Error; err != nil {
+			return nil, err
+		}
+	}
+	videoList, err := FilterVisibleVideoList(userID, videoList)
+	if err != nil {
+		return nil, err
+	}
+	existSet := make(map[uint64]void, len(videoList))
+	for _, video := range videoList {
+		existSet[video.VideoID] = member
+	}
+	var existIDList []uint64
+	if len(commentIDList) > 0 {
+		if err = global.DB.Model(&model.Comment{}).Where("comment_id in ?", commentIDList).
+			Pluck("comment_id", &existIDList).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(mentionIDList) > 0 {
+		var existMentionIDList []uint64
+		if err = global.DB.Model(&model.Mention{}).Where("mention_id in ?", mentionIDList).
+			Pluck("mention_id", &existMentionIDList).Error; err != nil {
+			return nil, err
+		}
+		existIDList = append(existIDList, existMentionIDList...)
+	}
+	for _, id := range existIDList {
+		existSet[id] = member
+	}
+	hiddenSet, err := GetHiddenUserIDSet(userID)
+	if err != nil {
+		return nil, err
+	}
+	visibleList := make([]model.Notification, 0, len(notificationList))
+	for _, notification := range notificationList {
+		if _, ok := hiddenSet[notification.LastActorID]; ok {
+			continue
+		}
+		if _, ok := existSet[notification.VideoID]; notification.VideoID != 0 && !ok {
+			continue
+		}
+		if _, ok := existSet[notification.TargetID]; !ok &&
+			(notification.Type == model.NotificationTypeReply || notification.Type == model.NotificationTypeMention) {
+			continue
+		}
+		visibleList = append(visibleList, notification)
+	}
+	return visibleList, nil
+}
+
+// GetUnreadNotificationCount 获取用户的未读通知数目，聚合的通知计为一条，与通知列表一样不计入被过滤的通知
+func GetUnreadNotificationCount(userID uint64) (int64, error) {
+	var notificationList []model.Notification
+	if err := global.DB.Select("notification_id", "type", "target_id", "video_id", "last_actor_id").
+		Where("user_id = ? and unread = ?", userID, true).Find(&notificationList).Error; err != nil {
+		return 0, err
+	}
+	visibleList, err := filterVisibleNotifications(userID, notificationList)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(visibleList)), nil
+}
+
+// MarkNotificationsRead 将用户的通知标记为已读，notificationID 为 0 时标记全部通知
+func MarkNotificationsRead(userID uint64, notificationID uint64) error {
+	db := global.DB.Model(&model.Notification{}).Where("user_id = ? and unread = ?", userID, true)
+	if notificationID != 0 {
+		db = db.Where("notification_id = ?", notificationID)
+	}
+	return db.Update("unread", nil).Error
+}
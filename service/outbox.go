@@ -0,0 +1,141 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// commentOutbox 添加或删除评论后的缓存操作参数
+type commentOutbox struct {
+	Comment     model.Comment `json:"comment"`
+	AuthorID    uint64        `json:"author_id"`     // 视频作者
+	ReplyIDList []uint64      `json:"reply_id_list"` // 随顶层评论一并删除的回复
+}
+
+// addOutbox 在业务事务中写入发件箱记录，outboxType 与提交后发出的领域事件类型一致
+func addOutbox(tx *gorm.DB, outboxType string, payload interface{}) (*model.Outbox, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	outbox := model.Outbox{Type: outboxType, Payload: string(data)}
+	outbox.OutboxID, _ = global.ID_GENERATOR.NextID()
+	if err = tx.Create(&outbox).Error; err != nil {
+		return nil, err
+	}
+	return &outbox, nil
+}
+
+// relayOutbox 业务事务提交后立即执行发件箱记录，成功后删除；失败时保留记录，由 StartOutboxRelay 重放
+func relayOutbox(outbox *model.Outbox) {
+	if err := applyOutbox(outbox, false); err != nil {
+		if err != ErrCacheUnavailable {
+			log.Printf("relay outbox %d failed: %v\n", outbox.OutboxID, err)
+		}
+		return
+	}
+	if err := global.DB.Delete(&model.Outbox{}, outbox.OutboxID).Error; err != nil {
+		log.Printf("delete outbox %d failed: %v\n", outbox.OutboxID, err)
+	}
+}
+
+// applyOutbox 执行发件箱记录中的缓存操作，成功后发出领域事件，事件按 outbox_id 去重
+// 首次执行时增量修改缓存；重放时不确定之前执行到哪一步，改为删除涉及的缓存，保证重复执行结果一致
+func applyOutbox(outbox *model.Outbox, replay bool) error {
+	switch outbox.Type {
+	case EventCommentAdded, EventCommentDeleted:
+		var payload commentOutbox
+		if err := json.Unmarshal([]byte(outbox.Payload), &payload); err != nil {
+			return err
+		}
+		comment := &payload.Comment
+		var err error
+		if replay {
+			err = InvalidateCommentInRedis(comment, payload.ReplyIDList)
+		} else if outbox.Type == EventCommentAdded {
+			err = AddCommentInRedis(comment)
+		} else {
+			err = DeleteCommentInRedis(comment, payload.ReplyIDList)
+		}
+		if err != nil {
+			return err
+		}
+		if outbox.Type == EventCommentAdded {
+			emitOutboxEvent(outbox.OutboxID, Event{Type: EventCommentAdded, ActorID: comment.UserID, UserID: payload.AuthorID, VideoID: comment.VideoID,
+				CommentID: comment.CommentID, ParentID: comment.ParentID, ReplyToUserID: comment.ReplyToUserID})
+		} else {
+			emitOutboxEvent(outbox.OutboxID, Event{Type: EventCommentDeleted, ActorID: comment.UserID, UserID: payload.AuthorID, VideoID: comment.VideoID,
+				CommentID: comment.CommentID, ParentID: comment.ParentID, Count: int64(len(payload.ReplyIDList) + 1)})
+		}
+	case EventVideoPublished:
+		var video model.Video
+		if err := json.Unmarshal([]byte(outbox.Payload), &video); err != nil {
+			return err
+		}
+		if replay {
+			// 记录中的视频可能已被删除或修改，跳过已删除和未发布的视频，其余按当前的可见范围重放
+			if result := global.DB.Where("video_id = ?", video.VideoID).Limit(1).Find(&video); result.Error != nil {
+				return result.Error
+			} else if result.RowsAffected == 0 || video.Status != model.VideoStatusReady {
+				return nil
+			}
+		}
+		if err := GoPublishVideo(video); err != nil {
+			return err
+		}
+		emitOutboxEvent(outbox.OutboxID, Event{Type: EventVideoPublished, ActorID: video.AuthorID, UserID: video.AuthorID, VideoID: video.VideoID})
+	default:
+		return errors.New("unknown outbox type " + outbox.Type)
+	}
+	return nil
+}
+
+// StartOutboxRelay 启动发件箱中继协程，周期性重放提交后未能执行的记录
+func StartOutboxRelay() {
+	go func() {
+		ticker := time.NewTicker(global.OUTBOX_RELAY_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := RelayPendingOutbox(); err != nil && err != ErrCacheUnavailable {
+				log.Println("relay outbox failed:", err)
+			}
+		}
+	}()
+}
+
+// RelayPendingOutbox 按写入顺序重放超时未删除的发件箱记录，Redis 不可用时等待下个周期
+// 多个实例可能重放同一条记录，重放本身是幂等的，事件也只发出一次
+func RelayPendingOutbox() error {
+	var outboxList []model.Outbox
+	if err := global.DB.Where("created_at < ?", time.Now().Add(-global.OUTBOX_RELAY_DELAY)).
+		Order("outbox_id").Limit(global.OUTBOX_RELAY_BATCH).Find(&outboxList).Error; err != nil {
+		return err
+	}
+	for i := range outboxList {
+		outbox := &outboxList[i]
+		err := applyOutbox(outbox, true)
+		if err == ErrCacheUnavailable {
+			return err
+		}
+		if err != nil && outbox.Attempts+1 < global.OUTBOX_MAX_ATTEMPTS {
+			log.Printf("replay outbox %d failed: %v\n", outbox.OutboxID, err)
+			if err = global.DB.Model(&model.Outbox{}).Where("outbox_id = ?", outbox.OutboxID).
+				Update("attempts", gorm.Expr("attempts + 1")).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("give up outbox %d after %d attempts: %v\n", outbox.OutboxID, outbox.Attempts+1, err)
+		}
+		if err = global.DB.Delete(&model.Outbox{}, outbox.OutboxID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/initialize"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/service"
+	"github.com/go-redis/redis/v8"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// 与服务使用同一份配置，需要可用的 MySQL 和 Redis
+func TestMain(m *testing.M) {
+	if err := os.Chdir(".."); err != nil {
+		panic(err.Error())
+	}
+	initialize.Global()
+	initialize.Viper()
+	initialize.MySQL()
+	initialize.Redis()
+	os.Exit(m.Run())
+}
+
+// addCommittedOutbox 模拟业务事务已提交、中继在执行前退出，记录写入时间早于重放延迟
+func addCommittedOutbox(t *testing.T, outboxID uint64, outboxType string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox := model.Outbox{OutboxID: outboxID, Type: outboxType, Payload: string(data),
+		CreatedAt: time.Now().Add(-global.OUTBOX_RELAY_DELAY)}
+	if err = global.DB.Create(&outbox).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// waitOutboxRelayed 重放未执行的记录，直到记录被删除或超时
+func waitOutboxRelayed(t *testing.T, outboxID uint64) {
+	deadline := time.Now().Add(global.OUTBOX_RELAY_DELAY + global.OUTBOX_RELAY_INTERVAL)
+	for {
+		if err := service.RelayPendingOutbox(); err != nil {
+			t.Fatal(err)
+		}
+		var count int64
+		if err := global.DB.Model(&model.Outbox{}).Where("outbox_id = ?", outboxID).Count(&count).Error; err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("outbox %d is not relayed", outboxID)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// countEvents 统计事件流中指定类型和视频的事件数目
+func countEvents(t *testing.T, eventType string, videoID uint64) int {
+	msgList, err := global.REDIS.XRevRangeN(global.CONTEXT, service.EventStreamKey, "+", "-", 1000).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, msg := range msgList {
+		var event service.Event
+		if err = json.Unmarshal([]byte(msg.Values["payload"].(string)), &event); err != nil {
+			continue
+		}
+		if event.Type == eventType && event.VideoID == videoID {
+			count++
+		}
+	}
+	return count
+}
+
+func TestOutboxReplayComment(t *testing.T) {
+	videoID, _ := global.ID_GENERATOR.NextID()
+	staleID, _ := global.ID_GENERATOR.NextID()
+	comment := model.Comment{VideoID: videoID, UserID: 1, Content: "outbox", CreatedAt: time.Now()}
+	comment.CommentID, _ = global.ID_GENERATOR.NextID()
+	if err := global.DB.Create(&comment).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer global.DB.Unscoped().Delete(&model.Comment{}, comment.CommentID)
+	// 缓存中是提交前的评论列表
+	keyCommentsOfVideo := fmt.Sprintf(service.VideoCommentsPattern, videoID)
+	if err := global.REDIS.ZAdd(global.CONTEXT, keyCommentsOfVideo, &redis.Z{Score: 1, Member: staleID}).Err(); err != nil {
+		t.Fatal(err)
+	}
+	defer global.REDIS.Del(global.CONTEXT, keyCommentsOfVideo)
+
+	payload := map[string]interface{}{"comment": comment, "author_id": 1}
+	outboxID, _ := global.ID_GENERATOR.NextID()
+	addCommittedOutbox(t, outboxID, service.EventCommentAdded, payload)
+	waitOutboxRelayed(t, outboxID)
+	// 缓存被删除，或者已包含新评论
+	err := global.REDIS.ZScore(global.CONTEXT, keyCommentsOfVideo, strconv.FormatUint(comment.CommentID, 10)).Err()
+	if n, _ := global.REDIS.Exists(global.CONTEXT, keyCommentsOfVideo).Result(); n > 0 && err != nil {
+		t.Fatalf("comment %d is missing from cache: %v", comment.CommentID, err)
+	}
+	if count := countEvents(t, service.EventCommentAdded, videoID); count != 1 {
+		t.Fatalf("expected 1 event, got %d", count)
+	}
+
+	// 多个实例重放同一条记录时，事件只发出一次
+	addCommittedOutbox(t, outboxID, service.EventCommentAdded, payload)
+	waitOutboxRelayed(t, outboxID)
+	if count := countEvents(t, service.EventCommentAdded, videoID); count != 1 {
+		t.Fatalf("expected 1 event after replaying twice, got %d", count)
+	}
+}
+
+func TestOutboxReplayDeletedVideo(t *testing.T) {
+	video := model.Video{AuthorID: 1, Title: "outbox", Status: model.VideoStatusReady,
+		Visibility: model.VideoVisibilityPublic, CreatedAt: time.Now()}
+	video.VideoID, _ = global.ID_GENERATOR.NextID()
+	if err := global.DB.Create(&video).Error; err != nil {
+		t.Fatal(err)
+	}
+	defer global.DB.Unscoped().Delete(&model.Video{}, video.VideoID)
+	// 提交后、重放前视频被删除，记录中仍是发布时的视频
+	if err := global.DB.Delete(&model.Video{}, video.VideoID).Error; err != nil {
+		t.Fatal(err)
+	}
+	outboxID, _ := global.ID_GENERATOR.NextID()
+	addCommittedOutbox(t, outboxID, service.EventVideoPublished, video)
+	waitOutboxRelayed(t, outboxID)
+	videoIDStr := strconv.FormatUint(video.VideoID, 10)
+	if err := global.REDIS.ZScore(global.CONTEXT, "feed", videoIDStr).Err(); err != redis.Nil {
+		t.Fatalf("deleted video %d is in feed: %v", video.VideoID, err)
+	}
+	if count := countEvents(t, service.EventVideoPublished, video.VideoID); count != 0 {
+		t.Fatalf("expected no event for deleted video, got %d", count)
+	}
+}
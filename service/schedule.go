@@ -0,0 +1,58 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// StartScheduledPublish 启动定时发布协程，周期性发布已到达发布时间的视频
+func StartScheduledPublish() {
+	go func() {
+		ticker := time.NewTicker(global.SCHEDULE_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := PublishDueVideos(); err != nil {
+				log.Println("publish scheduled videos failed:", err)
+			}
+		}
+	}()
+}
+
+// PublishDueVideos 发布已到达定时发布时间的视频，并以发布时间作为视频的创建时间
+// 多个实例同时运行时，通过带状态条件的更新保证每个视频只发布一次
+func PublishDueVideos() error {
+	var videoList []model.Video
+	if err := global.DB.Where("status = ? and publish_at <= ?", model.VideoStatusScheduled, time.Now()).
+		Find(&videoList).Error; err != nil {
+		return err
+	}
+	for _, video := range videoList {
+		video.Status = model.VideoStatusReady
+		video.CreatedAt = *video.PublishAt
+		// 视频加入视频流的缓存操作与状态更新在同一事务中写入发件箱
+		var outbox *model.Outbox
+		if err := global.DB.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&model.Video{}).Where("video_id = ? and status = ?", video.VideoID, model.VideoStatusScheduled).
+				Updates(map[string]interface{}{
+					"status":     model.VideoStatusReady,
+					"created_at": *video.PublishAt,
+				})
+			if result.Error != nil || result.RowsAffected == 0 {
+				// 已被其他实例发布时不写入发件箱
+				return result.Error
+			}
+			var err error
+			outbox, err = addOutbox(tx, EventVideoPublished, video)
+			return err
+		}); err != nil {
+			return err
+		}
+		if outbox != nil {
+			relayOutbox(outbox)
+		}
+	}
+	return nil
+}
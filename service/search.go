@@ -0,0 +1,58 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+)
+
+// searchPage 在 column 的全文索引上按相关度倒序分页搜索
+// 相关度保留 6 位小数，保证游标中的分数与数据库中重新计算的结果可以精确比较
+// 索引由 InnoDB 在写入时维护，新注册的用户和新发布的视频提交后即可被搜索到
+func searchPage(db *gorm.DB, idColumn string, column string, keyword string, cursor *util.Cursor, limit int) ([]scoreHit, *util.Cursor, error) {
+	db = db.Where("match("+column+") against(? in natural language mode)", keyword)
+	return scoreKeysetPage(db, idColumn, "round(match("+column+") against(? in natural language mode), 6)",
+		[]interface{}{keyword}, cursor, limit)
+}
+
+// SearchVideos 按标题相关度分页搜索视频及其作者，只返回当前用户可以查看的视频，没有更多时返回的游标为 nil
+func SearchVideos(viewerID uint64, keyword string, cursor *util.Cursor, limit int) ([]model.Video, []model.User, *util.Cursor, error) {
+	db := global.DB.Model(&model.Video{}).Where("status = ? and visibility = ?", model.VideoStatusReady, model.VideoVisibilityPublic)
+	hitList, nextCursor, err := searchPage(db, "video_id", "title", keyword, cursor, limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	videoIDList := make([]uint64, len(hitList))
+	for i, hit := range hitList {
+		videoIDList[i] = hit.ID
+	}
+	videoList, authorList, err := GetVisibleVideosAndAuthors(viewerID, videoIDList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return videoList, authorList, nextCursor, nil
+}
+
+// SearchUsers 按用户名相关度分页搜索用户，不返回当前用户拉黑或屏蔽的用户，没有更多时返回的游标为 nil
+func SearchUsers(viewerID uint64, keyword string, cursor *util.Cursor, limit int) ([]model.User, *util.Cursor, error) {
+	hitList, nextCursor, err := searchPage(global.DB.Model(&model.User{}), "id", "name", keyword, cursor, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	hiddenSet, err := GetHiddenUserIDSet(viewerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	userIDList := make([]uint64, 0, len(hitList))
+	for _, hit := range hitList {
+		if _, ok := hiddenSet[hit.ID]; !ok {
+			userIDList = append(userIDList, hit.ID)
+		}
+	}
+	userList, err := GetUserListByUserIDList(userIDList)
+	if err != nil {
+		return nil, nil, err
+	}
+	return userList, nextCursor, nil
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"strings"
+	"time"
+)
+
+// saveVideoTags 解析标题中的话题并更新视频与话题的关联，需在写入视频的事务中调用
+func saveVideoTags(tx *gorm.DB, videoID uint64, title string) error {
+	if err := tx.Where("video_id = ?", videoID).Delete(&model.VideoTag{}).Error; err != nil {
+		return err
+	}
+	nameList := util.ParseHashtags(title, global.MAX_TAGS_PER_VIDEO, global.MAX_TAG_LENGTH)
+	if len(nameList) == 0 {
+		return nil
+	}
+	// 新话题写入数据库，已存在的话题跳过
+	tagList := make([]model.Tag, len(nameList))
+	for i, name := range nameList {
+		tagList[i].TagID, _ = global.ID_GENERATOR.NextID()
+		tagList[i].Name = name
+	}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tagList).Error; err != nil {
+		return err
+	}
+	var tagIDList []uint64
+	if err := tx.Model(&model.Tag{}).Where("name in ?", nameList).Pluck("tag_id", &tagIDList).Error; err != nil {
+		return err
+	}
+	videoTagList := make([]model.VideoTag, len(tagIDList))
+	for i, tagID := range tagIDList {
+		videoTagList[i].VideoTagID, _ = global.ID_GENERATOR.NextID()
+		videoTagList[i].TagID = tagID
+		videoTagList[i].VideoID = videoID
+	}
+	return tx.Create(&videoTagList).Error
+}
+
+// GetTagByName 根据话题名称查询话题
+func GetTagByName(name string) (*model.Tag, error) {
+	var tag model.Tag
+	result := global.DB.Where("name = ?", strings.ToLower(name)).Limit(1).Find(&tag)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("tag does not exist")
+	}
+	return &tag, nil
+}
+
+// GetTagVideoList 分页获取话题下的视频及其作者，byHot 为 true 时按点赞数和评论数计算的热度排序，否则按发布时间倒序
+// 只返回当前用户可以查看的视频，没有更多时返回的游标为 nil
+func GetTagVideoList(viewerID uint64, tagID uint64, byHot bool, cursor *util.Cursor, limit int) ([]model.Video, []model.User, *util.Cursor, error) {
+	db := global.DB.Table("video_tags").
+		Joins("join videos on videos.video_id = video_tags.video_id").
+		Where("video_tags.tag_id = ? and videos.status = ? and videos.visibility = ? and videos.deleted_at is null",
+			tagID, model.VideoStatusReady, model.VideoVisibilityPublic)
+	var (
+		scoreExpr string
+		scoreArgs []interface{}
+	)
+	if byHot {
+		scoreExpr = "videos.favorite_count * ? + videos.comment_count * ?"
+		scoreArgs = []interface{}{global.HOT_FAVORITE_WEIGHT, global.HOT_COMMENT_WEIGHT}
+	} else {
+		scoreExpr = "round(unix_timestamp(videos.created_at) * 1000)"
+	}
+	hitList, nextCursor, err := scoreKeysetPage(db, "videos.video_id", scoreExpr, scoreArgs, cursor, limit)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	videoIDList := make([]uint64, len(hitList))
+	for i, hit := range hitList {
+		videoIDList[i] = hit.ID
+	}
+	videoList, authorList, err := GetVisibleVideosAndAuthors(viewerID, videoIDList)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return videoList, authorList, nextCursor, nil
+}
+
+// GetTrendingTagList 获取热门话题及其近期的视频数量，按近期发布的公开视频数量从高到低排序
+func GetTrendingTagList() ([]string, []int64, error) {
+	// 查询缓存
+	nameList, countList, err := GetTrendingTagListFromRedis()
+	if err == nil {
+		return nameList, countList, nil
+	} else if !isCacheMiss(err) {
+		return nil, nil, err
+	}
+	// 缓存不存在，查询数据库
+	var hitList []struct {
+		Name  string
+		Count int64
+	}
+	if err = global.DB.Table("video_tags").Select("tags.name as name, count(*) as count").
+		Joins("join videos on videos.video_id = video_tags.video_id").
+		Joins("join tags on tags.tag_id = video_tags.tag_id").
+		Where("videos.status = ? and videos.visibility = ? and videos.deleted_at is null and videos.created_at > ?",
+			model.VideoStatusReady, model.VideoVisibilityPublic, time.Now().Add(-global.TAG_TRENDING_WINDOW)).
+		Group("tags.tag_id, tags.name").Order("count desc").Limit(global.TRENDING_TAG_NUM).
+		Scan(&hitList).Error; err != nil {
+		return nil, nil, err
+	}
+	nameList = make([]string, len(hitList))
+	countList = make([]int64, len(hitList))
+	for i, hit := range hitList {
+		nameList[i] = hit.Name
+		countList[i] = hit.Count
+	}
+	// 更新缓存
+	if err = AddTrendingTagListToRedis(nameList, countList); err != nil && err != ErrCacheUnavailable {
+		return nil, nil, err
+	}
+	return nameList, countList, nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/go-redis/redis/v8"
+)
+
+// GetTrendingTagListFromRedis 从缓存中读取热门话题及其视频数量
+func GetTrendingTagListFromRedis() ([]string, []int64, error) {
+	listZ, err := global.REDIS.ZRevRangeWithScores(global.CONTEXT, TrendingTagsKey, 0, -1).Result()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(listZ) == 0 {
+		return nil, nil, errors.New("not found in cache")
+	}
+	nameList := make([]string, len(listZ))
+	countList := make([]int64, len(listZ))
+	for i, z := range listZ {
+		nameList[i], _ = z.Member.(string)
+		countList[i] = int64(z.Score)
+	}
+	return nameList, countList, nil
+}
+
+// AddTrendingTagListToRedis 将热门话题写入缓存，到期后重新统计；没有热门话题时不写入
+func AddTrendingTagListToRedis(nameList []string, countList []int64) error {
+	if len(nameList) == 0 {
+		return nil
+	}
+	listZ := make([]*redis.Z, len(nameList))
+	for i, name := range nameList {
+		listZ[i] = &redis.Z{Score: float64(countList[i]), Member: name}
+	}
+	// 使用 pipeline
+	_, err := global.REDIS.TxPipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		pipe.Del(global.CONTEXT, TrendingTagsKey)
+		pipe.ZAdd(global.CONTEXT, TrendingTagsKey, listZ...)
+		// 设置过期时间
+		pipe.Expire(global.CONTEXT, TrendingTagsKey, global.TRENDING_TAGS_EXPIRE)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,164 @@
+package service
+
+import (
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// timelineItem 关注流中的候选视频
+type timelineItem struct {
+	VideoID uint64
+	Score   float64 // 发布时间（秒）
+}
+
+// FanOutVideo 视频发布后推送到粉丝的收件箱，粉丝过多的作者不推送，由读取时拉取
+func FanOutVideo(video model.Video) error {
+	followerIDList, err := GetFollowerIDListByUserID(video.AuthorID)
+	if err != nil {
+		return err
+	}
+	if len(followerIDList) >= global.FANOUT_THRESHOLD {
+		return SetBigAuthor(video.AuthorID, true)
+	}
+	if err = SetBigAuthor(video.AuthorID, false); err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	return FanOutVideoToInbox(video, followerIDList)
+}
+
+// GetFollowingFeedVideosAndAuthorsRedis 获取关注的作者发布的视频以及其作者并返回视频数
+func GetFollowingFeedVideosAndAuthorsRedis(userID uint64, videoList *[]model.Video, authors *[]model.User, LatestTime int64, MaxNumVideo int) (int, error) {
+	*videoList = nil
+	*authors = nil
+	celebrityIDList, err := GetFollowIDListByUserID(userID)
+	if err != nil || len(celebrityIDList) == 0 {
+		return 0, err
+	}
+	maxScore := float64(LatestTime-2) / 1000
+	// 推模式：读取收件箱
+	itemList, err := getInboxItemList(userID, celebrityIDList, maxScore, MaxNumVideo)
+	if err != nil {
+		return 0, err
+	}
+	// 拉模式：直接查询粉丝较多的作者最近发布的视频
+	// Redis 不可用时收件箱直接查表，已包含全部关注的作者
+	isBigList, err := GetBigAuthorStatusList(celebrityIDList)
+	if err == ErrCacheUnavailable {
+		isBigList, err = make([]bool, len(celebrityIDList)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	bigAuthorIDList := make([]uint64, 0)
+	for i, isBig := range isBigList {
+		if isBig {
+			bigAuthorIDList = append(bigAuthorIDList, celebrityIDList[i])
+		}
+	}
+	if len(bigAuthorIDList) > 0 {
+		var bigVideoList []model.Video
+		if err = global.DB.Select("video_id", "created_at").
+			Where("author_id in ? and status = ? and visibility <> ? and created_at <= ?",
+				bigAuthorIDList, model.VideoStatusReady, model.VideoVisibilityPrivate, time.UnixMilli(LatestTime-2)).
+			Order("created_at desc").Limit(MaxNumVideo).Find(&bigVideoList).Error; err != nil {
+			return 0, err
+		}
+		for _, video := range bigVideoList {
+			itemList = append(itemList, timelineItem{VideoID: video.VideoID, Score: float64(video.CreatedAt.UnixMilli()) / 1000})
+		}
+	}
+	// 合并去重，按发布时间倒序截取
+	sort.SliceStable(itemList, func(i, j int) bool {
+		return itemList[i].Score > itemList[j].Score
+	})
+	videoIDList := make([]uint64, 0, MaxNumVideo)
+	seen := make(map[uint64]void, len(itemList))
+	for _, item := range itemList {
+		if _, ok := seen[item.VideoID]; ok {
+			continue
+		}
+		seen[item.VideoID] = member
+		videoIDList = append(videoIDList, item.VideoID)
+		if len(videoIDList) >= MaxNumVideo {
+			break
+		}
+	}
+	if len(videoIDList) == 0 {
+		return 0, nil
+	}
+	var candidateList []model.Video
+	if err = GetVideoListByIDsRedis(&candidateList, videoIDList); err != nil {
+		return 0, err
+	}
+	// 过滤已取消关注或被屏蔽的作者以及不存在的视频
+	hiddenSet, err := GetHiddenUserIDSet(userID)
+	if err != nil {
+		return 0, err
+	}
+	mapCelebrityID := make(map[uint64]void, len(celebrityIDList))
+	for _, each := range celebrityIDList {
+		if _, ok := hiddenSet[each]; !ok {
+			mapCelebrityID[each] = member
+		}
+	}
+	*videoList = make([]model.Video, 0, len(candidateList))
+	for _, video := range candidateList {
+		if _, ok := mapCelebrityID[video.AuthorID]; ok && video.VideoID != 0 {
+			*videoList = append(*videoList, video)
+		}
+	}
+	numVideos := len(*videoList)
+	// 批量获取视频作者
+	authorIDList := make([]uint64, numVideos)
+	for i, video := range *videoList {
+		authorIDList[i] = video.AuthorID
+	}
+	if err = GetUserListByUserIDs(authorIDList, authors); err != nil {
+		return 0, err
+	}
+	return numVideos, nil
+}
+
+// getInboxItemList 读取收件箱，收件箱不存在时根据关注列表从数据库重建
+func getInboxItemList(userID uint64, celebrityIDList []uint64, maxScore float64, count int) ([]timelineItem, error) {
+	listZ, err := GetInboxFromRedis(userID, maxScore, count)
+	if err == nil {
+		itemList := make([]timelineItem, 0, len(listZ))
+		for _, z := range listZ {
+			videoID, err := strconv.ParseUint(z.Member.(string), 10, 64)
+			if err != nil {
+				continue
+			}
+			itemList = append(itemList, timelineItem{VideoID: videoID, Score: z.Score})
+		}
+		return itemList, nil
+	} else if !isCacheMiss(err) {
+		return nil, err
+	}
+	// 收件箱不存在，查询数据库
+	var inboxVideoList []model.Video
+	if err = global.DB.Select("video_id", "created_at").Where("author_id in ? and status = ? and visibility <> ?",
+		celebrityIDList, model.VideoStatusReady, model.VideoVisibilityPrivate).
+		Order("created_at desc").Limit(global.INBOX_MAX_LENGTH).Find(&inboxVideoList).Error; err != nil {
+		return nil, err
+	}
+	// 更新缓存
+	if err = GoInbox(userID, inboxVideoList); err != nil && err != ErrCacheUnavailable {
+		return nil, err
+	}
+	itemList := make([]timelineItem, 0, count)
+	for _, video := range inboxVideoList {
+		score := float64(video.CreatedAt.UnixMilli()) / 1000
+		if score > maxScore {
+			continue
+		}
+		itemList = append(itemList, timelineItem{VideoID: video.VideoID, Score: score})
+		if len(itemList) >= count {
+			break
+		}
+	}
+	return itemList, nil
+}
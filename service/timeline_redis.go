@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+// FanOutVideoToInbox 将视频推送到粉丝的收件箱，收件箱不存在时跳过（读取时会从数据库重建）
+func FanOutVideoToInbox(video model.Video, followerIDList []uint64) error {
+	if len(followerIDList) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(followerIDList))
+	for _, followerID := range followerIDList {
+		keys = append(keys, fmt.Sprintf(InboxPattern, followerID))
+	}
+	// 收件箱中分数为 0 的 Header 始终处于 rank 0，裁剪时保留 Header 与最新的 INBOX_MAX_LENGTH 个视频
+	lua := redis.NewScript(`
+				for _, key in ipairs(KEYS) do
+					if redis.call("Exists", key) > 0 then
+						redis.call("ZAdd", key, ARGV[1], ARGV[2])
+						redis.call("ZRemRangeByRank", key, 1, -tonumber(ARGV[3]) - 2)
+						redis.call("Expire", key, ARGV[4])
+					end
+				end
+				return true
+			`)
+	values := []interface{}{float64(video.CreatedAt.UnixMilli()) / 1000, video.VideoID, global.INBOX_MAX_LENGTH,
+		global.INBOX_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// GoInbox 将用户的关注收件箱写入缓存
+func GoInbox(userID uint64, videoList []model.Video) error {
+	keyInbox := fmt.Sprintf(InboxPattern, userID)
+	var listZ = make([]*redis.Z, 0, len(videoList)+1)
+	// Header 保证关注的作者都没有发布视频时收件箱依然存在
+	listZ = append(listZ, &redis.Z{Score: 0, Member: Header})
+	for _, video := range videoList {
+		listZ = append(listZ, &redis.Z{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: video.VideoID})
+	}
+	pipe := global.REDIS.TxPipeline()
+	pipe.Del(global.CONTEXT, keyInbox)
+	pipe.ZAdd(global.CONTEXT, keyInbox, listZ...)
+	pipe.Expire(global.CONTEXT, keyInbox, global.INBOX_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
+	_, err := pipe.Exec(global.CONTEXT)
+	return err
+}
+
+// GetInboxFromRedis 按时间倒序获取收件箱中分数不大于 maxScore 的视频
+func GetInboxFromRedis(userID uint64, maxScore float64, count int) ([]redis.Z, error) {
+	keyInbox := fmt.Sprintf(InboxPattern, userID)
+	n, err := global.REDIS.Exists(global.CONTEXT, keyInbox).Result()
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 {
+		return nil, errors.New("not found in cache")
+	}
+	op := redis.ZRangeBy{
+		Min:    "(0", // 排除 Header
+		Max:    strconv.FormatFloat(maxScore, 'f', 3, 64),
+		Offset: 0,
+		Count:  int64(count),
+	}
+	return global.REDIS.ZRevRangeByScoreWithScores(global.CONTEXT, keyInbox, &op).Result()
+}
+
+// DeleteInbox 删除用户的收件箱，关注关系变化后由下一次读取重建
+func DeleteInbox(userID uint64) error {
+	return global.REDIS.Del(global.CONTEXT, fmt.Sprintf(InboxPattern, userID)).Err()
+}
+
+// SetBigAuthor 标记（或取消标记）粉丝数较多、采用读时拉取的作者
+func SetBigAuthor(authorID uint64, isBig bool) error {
+	if isBig {
+		return global.REDIS.SAdd(global.CONTEXT, BigAuthorKey, authorID).Err()
+	}
+	return global.REDIS.SRem(global.CONTEXT, BigAuthorKey, authorID).Err()
+}
+
+// GetBigAuthorStatusList 批量判断作者是否采用读时拉取
+func GetBigAuthorStatusList(authorIDList []uint64) ([]bool, error) {
+	cmds, err := global.REDIS.Pipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		for _, authorID := range authorIDList {
+			pipe.SIsMember(global.CONTEXT, BigAuthorKey, authorID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	isBigList := make([]bool, len(authorIDList))
+	for i, cmd := range cmds {
+		isBigList[i] = cmd.(*redis.BoolCmd).Val()
+	}
+	return isBigList, nil
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync/atomic"
+	"time"
+)
+
+// GetTokenVersion 获取用户当前的 token 版本
+func GetTokenVersion(userID uint64) (int64, error) {
+	var user model.User
+	result := global.DB.Select("token_version").Where("id = ?", userID).Limit(1).Find(&user)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, errors.New("user does not exist")
+	}
+	// 更新缓存
+	if err := SetTokenVersionInRedis(userID, user.TokenVersion); err != nil && err != ErrCacheUnavailable {
+		return 0, err
+	}
+	return user.TokenVersion, nil
+}
+
+// IssueTokens 为用户签发 access token 和 refresh token
+func IssueTokens(user *model.User) (accessToken string, refreshToken string, err error) {
+	version, err := GetTokenVersion(user.UserID)
+	if err != nil {
+		return
+	}
+	if accessToken, err = util.GenerateToken(user, version); err != nil {
+		return
+	}
+	refreshToken, err = util.GenerateRefreshToken(user, version)
+	return
+}
+
+// ParseAccessToken 解析 access token，并校验其未被吊销
+func ParseAccessToken(tokenString string) (*util.UserClaims, error) {
+	return parseToken(tokenString, util.AccessTokenType)
+}
+
+// RefreshTokens 使用 refresh token 换取新的 token，旧的 refresh token 随即失效
+func RefreshTokens(refreshToken string) (claims *util.UserClaims, accessToken string, newRefreshToken string, err error) {
+	claims, err = parseToken(refreshToken, util.RefreshTokenType)
+	if err != nil {
+		return
+	}
+	// 吊销旧的 refresh token，防止重复使用
+	ok, err := revokeToken(claims)
+	if err != nil {
+		return
+	}
+	if !ok {
+		err = errors.New("token has been revoked")
+		return
+	}
+	user := &model.User{UserID: claims.UserID, Name: claims.Name}
+	if accessToken, err = util.GenerateToken(user, claims.Version); err != nil {
+		return
+	}
+	newRefreshToken, err = util.GenerateRefreshToken(user, claims.Version)
+	return
+}
+
+// Logout 吊销当前设备的 access token，以及一并提交的 refresh token
+func Logout(claims *util.UserClaims, refreshToken string) error {
+	if _, err := revokeToken(claims); err != nil {
+		return err
+	}
+	if refreshToken == "" {
+		return nil
+	}
+	refreshClaims, err := parseToken(refreshToken, util.RefreshTokenType)
+	if err != nil {
+		return err
+	}
+	if refreshClaims.UserID != claims.UserID {
+		return errors.New("refresh token does not belong to current user")
+	}
+	_, err = revokeToken(refreshClaims)
+	return err
+}
+
+// revokeToken 将 token 写入数据库中的吊销列表并更新缓存，返回 false 表示该 token 已被吊销
+func revokeToken(claims *util.UserClaims) (bool, error) {
+	if !claims.ExpiresAt.Time.After(time.Now()) {
+		return false, nil
+	}
+	revoked := model.RevokedToken{TokenID: claims.ID, UserID: claims.UserID, ExpiresAt: claims.ExpiresAt.Time}
+	result := global.DB.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&revoked)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	// 更新缓存，Redis 不可用时由探测协程在恢复后从数据库同步
+	if err := RevokeTokenInRedis(claims.ID, claims.ExpiresAt.Time); err != nil {
+		if err != ErrCacheUnavailable {
+			return false, err
+		}
+		atomic.StoreInt32(&revokedTokenUnsynced, 1)
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// getTokenStatus Redis 不可用时查询数据库中的吊销列表和用户当前的 token 版本
+func getTokenStatus(userID uint64, tokenID string) (revoked bool, version int64, err error) {
+	var count int64
+	if err = global.DB.Model(&model.RevokedToken{}).Where("token_id = ?", tokenID).Count(&count).Error; err != nil {
+		return
+	}
+	var user model.User
+	result := global.DB.Select("token_version").Where("id = ?", userID).Limit(1).Find(&user)
+	if result.Error != nil {
+		return false, 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, 0, errors.New("user does not exist")
+	}
+	return count > 0, user.TokenVersion, nil
+}
+
+// revokedTokenUnsynced 有吊销记录未能写入缓存时为 1
+var revokedTokenUnsynced int32
+
+// SyncRevokedTokens Redis 恢复后将数据库中未过期的吊销记录写回缓存，并清理已过期的记录
+// 未能写入缓存的吊销记录只保存在数据库中，不同步会在恢复后重新生效
+func SyncRevokedTokens() error {
+	atomic.StoreInt32(&revokedTokenUnsynced, 0)
+	if err := global.DB.Where("expires_at < ?", time.Now()).Delete(&model.RevokedToken{}).Error; err != nil {
+		return err
+	}
+	var tokenList []model.RevokedToken
+	err := global.DB.Where("expires_at >= ?", time.Now()).
+		FindInBatches(&tokenList, global.REVOKED_TOKEN_SYNC_BATCH, func(tx *gorm.DB, batch int) error {
+			return AddRevokedTokensToRedis(tokenList)
+		}).Error
+	if err != nil {
+		atomic.StoreInt32(&revokedTokenUnsynced, 1)
+	}
+	return err
+}
+
+// LogoutAllDevices 递增用户的 token 版本，使其已签发的所有 token 失效
+func LogoutAllDevices(userID uint64) error {
+	var user model.User
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.User{}).Where("id = ?", userID).
+			Update("token_version", gorm.Expr("token_version + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("user does not exist")
+		}
+		return tx.Select("token_version").Where("id = ?", userID).Take(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+	// 直接写入新版本而不是删除缓存，防止并发读到的旧版本被重新写入缓存
+	return skipCacheUnavailable(SetTokenVersionInRedis(userID, user.TokenVersion))
+}
+
+// parseToken 校验 token 的签名、类型、吊销状态和版本
+func parseToken(tokenString string, tokenType string) (*util.UserClaims, error) {
+	claims, err := util.ParseToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != tokenType || claims.ID == "" {
+		return nil, errors.New("token is invalid")
+	}
+	// 查询缓存，Redis 不可用时查询数据库
+	revoked, version, err := GetTokenStatusFromRedis(claims.UserID, claims.ID)
+	if err == ErrCacheUnavailable {
+		if revoked, version, err = getTokenStatus(claims.UserID, claims.ID); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		if !isCacheMiss(err) {
+			return nil, err
+		}
+		if version, err = GetTokenVersion(claims.UserID); err != nil {
+			return nil, err
+		}
+	}
+	if revoked || claims.Version != version {
+		return nil, errors.New("token has been revoked")
+	}
+	return claims, nil
+}
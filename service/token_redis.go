@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/go-redis/redis/v8"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// GetTokenStatusFromRedis 查询 token 是否被吊销以及用户当前的 token 版本
+func GetTokenStatusFromRedis(userID uint64, tokenID string) (revoked bool, version int64, err error) {
+	// 定义 key
+	revokedRedis := fmt.Sprintf(RevokedTokenPattern, tokenID)
+	versionRedis := fmt.Sprintf(TokenVersionPattern, userID)
+
+	// 使用 pipeline
+	cmds, err := global.REDIS.Pipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		pipe.Exists(global.CONTEXT, revokedRedis)
+		pipe.Get(global.CONTEXT, versionRedis)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return false, 0, err
+	}
+	revoked = cmds[0].(*redis.IntCmd).Val() > 0
+	version, err = cmds[1].(*redis.StringCmd).Int64()
+	if err == redis.Nil {
+		return revoked, 0, errors.New("not found in cache")
+	}
+	return revoked, version, err
+}
+
+// SetTokenVersionInRedis 缓存用户当前的 token 版本，版本只增不减
+// 并发查询数据库得到的旧版本晚于新版本写入时被忽略，避免已失效的 token 重新生效
+func SetTokenVersionInRedis(userID uint64, version int64) error {
+	// 定义 key
+	versionRedis := fmt.Sprintf(TokenVersionPattern, userID)
+
+	lua := redis.NewScript(`
+				local current = redis.call("Get", KEYS[1])
+				if current and tonumber(current) >= tonumber(ARGV[1]) then
+					return false
+				end
+				redis.call("Set", KEYS[1], ARGV[1], "EX", ARGV[2])
+				return true
+			`)
+	keys := []string{versionRedis}
+	values := []interface{}{version, int64(global.TOKEN_VERSION_EXPIRE.Seconds() + math.Floor(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds()))}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// RevokeTokenInRedis 将 token ID 加入吊销列表，保留到 token 自然过期为止
+func RevokeTokenInRedis(tokenID string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	// 定义 key
+	revokedRedis := fmt.Sprintf(RevokedTokenPattern, tokenID)
+
+	return global.REDIS.Set(global.CONTEXT, revokedRedis, 1, ttl).Err()
+}
+
+// AddRevokedTokensToRedis 批量写入吊销列表，保留到各 token 自然过期为止
+func AddRevokedTokensToRedis(tokenList []model.RevokedToken) error {
+	// 使用 pipeline
+	_, err := global.REDIS.Pipelined(global.CONTEXT, func(pipe redis.Pipeliner) error {
+		for _, token := range tokenList {
+			if ttl := time.Until(token.ExpiresAt); ttl > 0 {
+				pipe.Set(global.CONTEXT, fmt.Sprintf(RevokedTokenPattern, token.TokenID), 1, ttl)
+			}
+		}
+		return nil
+	})
+	return err
+}
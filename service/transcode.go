@@ -0,0 +1,234 @@
+package service
+
+import (
+	"errors"
+	"github.com/Ljkkun/GreenBeanMiners/global"
+	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
+	"github.com/Ljkkun/GreenBeanMiners/util"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// transcodeQueue 待转码的视频 ID
+var transcodeQueue = make(chan uint64, global.TRANSCODE_QUEUE_SIZE)
+
+// queuedVideoSet 已入队或正在转码的视频，避免扫描时重复入队
+var (
+	queuedVideoSet   = make(map[uint64]void)
+	queuedVideoMutex sync.Mutex
+)
+
+// errVideoDeleted 视频在转码过程中被作者删除
+var errVideoDeleted = errors.New("video has been deleted while transcoding")
+
+// StartTranscodeWorkers 启动转码协程，并将上次退出时未完成的任务重新加入队列
+func StartTranscodeWorkers(workerNum int) error {
+	if workerNum <= 0 {
+		workerNum = 1
+	}
+	for i := 0; i < workerNum; i++ {
+		go transcodeWorker()
+	}
+	if err := enqueuePendingVideos(model.VideoStatusPending, model.VideoStatusProcessing); err != nil {
+		return err
+	}
+	// 周期性补充因队列已满未能入队的视频
+	go func() {
+		ticker := time.NewTicker(global.TRANSCODE_RESCAN)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := enqueuePendingVideos(model.VideoStatusPending); err != nil {
+				log.Println("rescan pending videos failed:", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// enqueuePendingVideos 将指定状态的视频加入转码队列，包括转码完成前被删除的视频，由转码协程删除其原始文件
+func enqueuePendingVideos(statusList ...int8) error {
+	var videoIDList []uint64
+	if err := global.DB.Unscoped().Model(&model.Video{}).Where("status in ?", statusList).
+		Order("video_id").Limit(global.TRANSCODE_QUEUE_SIZE).Pluck("video_id", &videoIDList).Error; err != nil {
+		return err
+	}
+	for _, videoID := range videoIDList {
+		if !EnqueueTranscode(videoID) {
+			break
+		}
+	}
+	return nil
+}
+
+// EnqueueTranscode 将视频加入转码队列，不阻塞调用方
+// 队列已满时返回 false，视频保持等待转码状态，由周期性扫描重新入队
+func EnqueueTranscode(videoID uint64) bool {
+	queuedVideoMutex.Lock()
+	defer queuedVideoMutex.Unlock()
+	if _, ok := queuedVideoSet[videoID]; ok {
+		return true
+	}
+	select {
+	case transcodeQueue <- videoID:
+		queuedVideoSet[videoID] = member
+		return true
+	default:
+		return false
+	}
+}
+
+func transcodeWorker() {
+	for videoID := range transcodeQueue {
+		if err := TranscodeVideo(videoID); err != nil && err != errVideoDeleted {
+			log.Printf("transcode video %d failed: %v\n", videoID, err)
+		}
+		queuedVideoMutex.Lock()
+		delete(queuedVideoSet, videoID)
+		queuedVideoMutex.Unlock()
+	}
+}
+
+// TranscodeVideo 生成封面并将视频转码为 HLS 写入存储，成功后加入视频流
+// 上传的原始文件只由转码协程删除，视频在转码完成前被删除时同样由转码协程清理
+func TranscodeVideo(videoID uint64) (err error) {
+	var video model.Video
+	if result := global.DB.Unscoped().Where("video_id = ?", videoID).Limit(1).Find(&video); result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return errors.New("video 表中 video_id 不存在")
+	}
+	if video.Status != model.VideoStatusPending && video.Status != model.VideoStatusProcessing {
+		// 重复的任务
+		return nil
+	}
+	if video.DeletedAt.Valid {
+		// 转码前已被删除，只删除原始文件
+		_ = os.Remove(filepath.Join(global.UPLOAD_ADDR, video.SourceName))
+		return global.DB.Unscoped().Model(&model.Video{}).Where("video_id = ?", videoID).
+			Updates(map[string]interface{}{"status": model.VideoStatusFailed, "source_name": ""}).Error
+	}
+	if err = global.DB.Model(&model.Video{}).Where("video_id = ?", videoID).
+		Update("status", model.VideoStatusProcessing).Error; err != nil {
+		return err
+	}
+
+	name := strconv.FormatUint(videoID, 10)
+	coverName := name + ".jpg"
+	sourcePath := filepath.Join(global.UPLOAD_ADDR, video.SourceName)
+	coverPath := filepath.Join(global.UPLOAD_ADDR, coverName)
+	outputDir := filepath.Join(global.UPLOAD_ADDR, name)
+	var uploadedKeyList []string
+	defer func() {
+		_ = os.RemoveAll(outputDir)
+		_ = os.Remove(coverPath)
+		if err == nil {
+			_ = os.Remove(sourcePath)
+			return
+		}
+		// 转码失败，清理已写入存储的文件
+		for _, key := range uploadedKeyList {
+			_ = global.STORAGE.Delete(key)
+		}
+		// 未达到次数上限时保留原始文件，恢复为等待转码，由周期性扫描重新入队
+		tries := video.TranscodeTries + 1
+		if err != errVideoDeleted && int(tries) < global.TRANSCODE_MAX_TRIES {
+			global.DB.Unscoped().Model(&model.Video{}).Where("video_id = ?", videoID).
+				Updates(map[string]interface{}{"status": model.VideoStatusPending, "transcode_tries": tries})
+			return
+		}
+		_ = os.Remove(sourcePath)
+		global.DB.Unscoped().Model(&model.Video{}).Where("video_id = ?", videoID).
+			Updates(map[string]interface{}{"status": model.VideoStatusFailed, "source_name": "", "transcode_tries": tries})
+	}()
+
+	// 生成封面
+	if _, err = util.GetFrame(sourcePath, coverPath, 1); err != nil {
+		return err
+	}
+	// 转码
+	transcodeConfig := global.CONFIG.TranscodeConfig
+	masterName, err := util.TranscodeHLS(sourcePath, outputDir, transcodeConfig.SegmentTime, transcodeConfig.Renditions)
+	if err != nil {
+		return err
+	}
+	// 写入存储，依次写入分片、各清晰度的播放列表、主播放列表
+	var segmentList, playlistList []string
+	if err = filepath.Walk(outputDir, func(filePath string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(outputDir, filePath)
+		if err != nil {
+			return err
+		}
+		if rel == masterName {
+			return nil
+		}
+		if filepath.Ext(rel) == ".m3u8" {
+			playlistList = append(playlistList, rel)
+		} else {
+			segmentList = append(segmentList, rel)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	fileList := append(append(segmentList, playlistList...), masterName)
+	for _, rel := range fileList {
+		key := storage.VideoKey(path.Join(name, filepath.ToSlash(rel)))
+		if err = global.STORAGE.Put(key, filepath.Join(outputDir, rel)); err != nil {
+			return err
+		}
+		uploadedKeyList = append(uploadedKeyList, key)
+	}
+	if err = global.STORAGE.Put(storage.CoverKey(coverName), coverPath); err != nil {
+		return err
+	}
+	uploadedKeyList = append(uploadedKeyList, storage.CoverKey(coverName))
+
+	// 更新数据库
+	video.PlayName = path.Join(name, masterName)
+	video.CoverName = coverName
+	video.Status = model.VideoStatusReady
+	// 设置了定时发布且尚未到达发布时间的视频，由定时发布协程加入视频流
+	scheduled := video.PublishAt != nil && video.PublishAt.After(time.Now())
+	if scheduled {
+		video.Status = model.VideoStatusScheduled
+	}
+	// 视频加入视频流的缓存操作与状态更新在同一事务中写入发件箱
+	var outbox *model.Outbox
+	if err = global.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Video{}).Where("video_id = ?", videoID).Updates(map[string]interface{}{
+			"play_name":   video.PlayName,
+			"cover_name":  video.CoverName,
+			"status":      video.Status,
+			"source_name": "",
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 转码过程中被删除，不再加入视频流，已写入存储的文件随后清理
+			return errVideoDeleted
+		}
+		if scheduled {
+			return nil
+		}
+		var err error
+		outbox, err = addOutbox(tx, EventVideoPublished, video)
+		return err
+	}); err != nil {
+		return err
+	}
+	if outbox != nil {
+		relayOutbox(outbox)
+	}
+	return nil
+}
@@ -2,9 +2,15 @@ package service
 
 import (
 	"errors"
+	"fmt"
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
+	"github.com/Ljkkun/GreenBeanMiners/storage"
 	"github.com/Ljkkun/GreenBeanMiners/util"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
 )
 
 // Register 用户注册
@@ -44,38 +50,46 @@ func UserInfoByUserID(userID uint64) (user *model.User, err error) {
 	user, err = GetUserInfoByUserIDFromRedis(userID)
 	if err == nil {
 		return
-	} else if err.Error() != "not found in cache" {
+	} else if !isCacheMiss(err) {
 		return nil, err
 	}
+	// 缓存不存在，并发请求只由一个调用方查询数据库并写入缓存
+	value, err := rebuildCache(fmt.Sprintf(UserPattern, userID), func() (interface{}, error) {
+		return loadUserInfo(userID)
+	}, func() (interface{}, bool, error) {
+		user, err := GetUserInfoByUserIDFromRedis(userID)
+		return user, err == nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// 结果由并发请求共享，返回副本
+	userCopy := *value.(*model.User)
+	return &userCopy, nil
+}
+
+// loadUserInfo 从数据库查询用户信息并写入缓存
+func loadUserInfo(userID uint64) (user *model.User, err error) {
 	// 检查 userID 是否存在；若存在，获取用户信息
 	result := global.DB.Where("id = ?", userID).Limit(1).Find(&user)
-	// 查询关注数目
-	global.DB.Model(&model.User{}).Where("follow_count  = ? and is_follow = ?", user.UserID, true).
-		Count(&user.FollowCount)
-	// 查询粉丝数目
-	global.DB.Model(&model.User{}).Where("follower_count  = ? and is_follow = ?", user.UserID, true).
-		Count(&user.FollowerCount)
-	// 查询点赞数目
-	global.DB.Model(&model.User{}).Where("id = ? and is_favorite = ?", user.UserID, true).
-		Count(&user.FavoriteCount)
-	// 查询总点赞数目
-	var publishVideoIDList []uint64
-	_ = GetVideoIDListByUserID(user.UserID, &publishVideoIDList)
-	favoriteCountList, _ := GetFavoriteCountListByVideoIDList(publishVideoIDList)
-	var totalFavorited int64 = 0
-	for _, each := range favoriteCountList {
-		totalFavorited += each
-	}
-	user.TotalFavorited = totalFavorited
+	if result.Error != nil {
+		return nil, result.Error
+	}
 	if result.RowsAffected == 0 {
 		err = errors.New("username does not exist")
 		return
 	}
+	// 计数列加上尚未写回的增量
+	userList := []model.User{*user}
+	if err = addPendingUserCounts(userList); err != nil {
+		return nil, err
+	}
+	*user = userList[0]
 	// 更新缓存
-	if err = AddUserInfoByUserIDFromCacheToRedis(user); err != nil {
+	if err = AddUserInfoByUserIDFromCacheToRedis(user); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
-	return
+	return user, nil
 
 }
 
@@ -83,7 +97,7 @@ func UserInfoByUserID(userID uint64) (user *model.User, err error) {
 func GetUserListByUserIDList(UserIDList []uint64) ([]model.User, error) {
 	// 查询缓存
 	userList, notInCache, err := GetUserListByUserIDListFromRedis(UserIDList)
-	if err != nil && err.Error() != "not found in cache" {
+	if err != nil && !isCacheMiss(err) {
 		return nil, err
 	} else if err == nil {
 		return userList, nil
@@ -93,31 +107,17 @@ func GetUserListByUserIDList(UserIDList []uint64) ([]model.User, error) {
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	// 计数列加上尚未写回的增量
+	if err = addPendingUserCounts(uniqueUserList); err != nil {
+		return nil, err
+	}
 	// 针对查询结果建立映射关系
 	mapUserIDToUser := make(map[uint64]model.User, len(uniqueUserList))
-	for idx, user := range uniqueUserList {
-		// 查询关注数目
-		global.DB.Model(&model.User{}).Where("follower_id = ? and is_follow = ?", user.UserID, true).
-			Count(&uniqueUserList[idx].FollowCount)
-		// 查询粉丝数目
-		global.DB.Model(&model.User{}).Where("celebrity_id = ? and is_follow = ?", user.UserID, true).
-			Count(&uniqueUserList[idx].FollowerCount)
-		// 查询点赞数目
-		global.DB.Model(&model.User{}).Where("user_id = ? and is_favorite = ?", user.UserID, true).
-			Count(&uniqueUserList[idx].FavoriteCount)
-		// 查询总点赞数目
-		var publishVideoIDList []uint64
-		_ = GetVideoIDListByUserID(user.UserID, &publishVideoIDList)
-		favoriteCountList, _ := GetFavoriteCountListByVideoIDList(publishVideoIDList)
-		var totalFavorited int64 = 0
-		for _, each := range favoriteCountList {
-			totalFavorited += each
-		}
-		uniqueUserList[idx].TotalFavorited = totalFavorited
-		mapUserIDToUser[user.UserID] = uniqueUserList[idx]
+	for _, user := range uniqueUserList {
+		mapUserIDToUser[user.UserID] = user
 	}
 	// 更新缓存
-	if err = AddUserListByUserIDListsToRedis(uniqueUserList); err != nil {
+	if err = AddUserListByUserIDListsToRedis(uniqueUserList); err != nil && err != ErrCacheUnavailable {
 		return nil, err
 	}
 	// 后续操作，返回用户列表
@@ -135,3 +135,92 @@ func GetUserListByUserIDs(UserIDs []uint64, userList *[]model.User) (err error)
 	*userList = userListPrototype
 	return
 }
+
+// UpdateUserProfile 修改用户的个人资料，signature 为 nil 时不修改简介；
+// avatarPath 和 backgroundPath 为上传图片的临时路径，为空时不修改，处理完成后临时文件会被删除
+func UpdateUserProfile(userID uint64, signature *string, avatarPath string, backgroundPath string) error {
+	if avatarPath != "" {
+		defer os.Remove(avatarPath)
+	}
+	if backgroundPath != "" {
+		defer os.Remove(backgroundPath)
+	}
+	var user model.User
+	if result := global.DB.Select("id", "avatar", "background_image", "signature").
+		Where("id = ?", userID).Limit(1).Find(&user); result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return errors.New("user does not exist")
+	}
+	updates := make(map[string]interface{}, 3)
+	var uploadedKeyList, oldKeyList []string
+	committed := false
+	defer func() {
+		// 写入数据库失败时删除已写入存储的图片，成功时删除旧图片
+		keyList := uploadedKeyList
+		if committed {
+			keyList = oldKeyList
+		}
+		for _, key := range keyList {
+			if err := global.STORAGE.Delete(key); err != nil {
+				log.Printf("delete profile image %s failed: %v\n", key, err)
+			}
+		}
+	}()
+	if avatarPath != "" {
+		name, err := saveProfileImage(avatarPath, global.AVATAR_SIZE, global.AVATAR_SIZE, storage.AvatarKey)
+		if err != nil {
+			return err
+		}
+		uploadedKeyList = append(uploadedKeyList, storage.AvatarKey(name))
+		if user.Avatar != "" {
+			oldKeyList = append(oldKeyList, storage.AvatarKey(user.Avatar))
+		}
+		user.Avatar = name
+		updates["avatar"] = name
+	}
+	if backgroundPath != "" {
+		name, err := saveProfileImage(backgroundPath, global.BACKGROUND_WIDTH, global.BACKGROUND_HEIGHT, storage.BackgroundKey)
+		if err != nil {
+			return err
+		}
+		uploadedKeyList = append(uploadedKeyList, storage.BackgroundKey(name))
+		if user.BackgroundImage != "" {
+			oldKeyList = append(oldKeyList, storage.BackgroundKey(user.BackgroundImage))
+		}
+		user.BackgroundImage = name
+		updates["background_image"] = name
+	}
+	if signature != nil {
+		user.Signature = *signature
+		updates["signature"] = *signature
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := global.DB.Model(&model.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+		return err
+	}
+	committed = true
+	// 更新缓存
+	return skipCacheUnavailable(SetUserProfileInRedis(&user))
+}
+
+// saveProfileImage 将图片裁剪缩放后写入存储，返回存储中的文件名
+// 每次上传使用新的文件名，避免客户端和 CDN 缓存旧图片
+func saveProfileImage(srcPath string, width, height int, keyOf func(string) string) (string, error) {
+	id, err := global.ID_GENERATOR.NextID()
+	if err != nil {
+		return "", err
+	}
+	name := strconv.FormatUint(id, 10) + ".jpg"
+	dstPath := filepath.Join(global.UPLOAD_ADDR, name)
+	if err = util.ResizeImage(srcPath, dstPath, width, height); err != nil {
+		return "", err
+	}
+	defer os.Remove(dstPath)
+	if err = global.STORAGE.Put(keyOf(name), dstPath); err != nil {
+		return "", err
+	}
+	return name, nil
+}
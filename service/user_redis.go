@@ -48,10 +48,14 @@ func AddUserInfoByUserIDFromCacheToRedis(user *model.User) error {
 		pipe.HSet(global.CONTEXT, userRedis, "user_id", user.UserID)
 		pipe.HSet(global.CONTEXT, userRedis, "name", user.Name)
 		pipe.HSet(global.CONTEXT, userRedis, "password", user.Password)
-		pipe.HSet(global.CONTEXT, userRedis, "follow_count", user.FollowerCount)
+		pipe.HSet(global.CONTEXT, userRedis, "follow_count", user.FollowCount)
 		pipe.HSet(global.CONTEXT, userRedis, "follower_count", user.FollowerCount)
 		pipe.HSet(global.CONTEXT, userRedis, "total_favorited", user.TotalFavorited)
 		pipe.HSet(global.CONTEXT, userRedis, "favorite_count", user.FavoriteCount)
+		pipe.HSet(global.CONTEXT, userRedis, "is_private", user.IsPrivate)
+		pipe.HSet(global.CONTEXT, userRedis, "avatar", user.Avatar)
+		pipe.HSet(global.CONTEXT, userRedis, "background_image", user.BackgroundImage)
+		pipe.HSet(global.CONTEXT, userRedis, "signature", user.Signature)
 		pipe.HSet(global.CONTEXT, userRedis, "created_at", user.CreatedAt.UnixMilli())
 		// 设置过期时间
 		pipe.Expire(global.CONTEXT, userRedis, global.USER_INFO_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
@@ -67,7 +71,7 @@ func GetUserListByUserIDListFromRedis(userIDList []uint64) (userList []model.Use
 	notInCache = make([]uint64, 0, userNum)
 	for _, each := range userIDList {
 		user, err2 := GetUserInfoByUserIDFromRedis(each)
-		if err2 != nil && err2.Error() != "not found in cache" {
+		if err2 != nil && !isCacheMiss(err2) {
 			return nil, nil, err2
 		} else if err2 == nil {
 			userList = append(userList, *user)
@@ -94,6 +98,10 @@ func AddUserListByUserIDListsToRedis(userList []model.User) error {
 			pipe.HSet(global.CONTEXT, userRedis, "follower_count", each.FollowerCount)
 			pipe.HSet(global.CONTEXT, userRedis, "total_favorited", each.TotalFavorited)
 			pipe.HSet(global.CONTEXT, userRedis, "favorite_count", each.FavoriteCount)
+			pipe.HSet(global.CONTEXT, userRedis, "is_private", each.IsPrivate)
+			pipe.HSet(global.CONTEXT, userRedis, "avatar", each.Avatar)
+			pipe.HSet(global.CONTEXT, userRedis, "background_image", each.BackgroundImage)
+			pipe.HSet(global.CONTEXT, userRedis, "signature", each.Signature)
 			pipe.HSet(global.CONTEXT, userRedis, "created_at", each.CreatedAt.UnixMilli())
 			// 设置过期时间
 			pipe.Expire(global.CONTEXT, userRedis, global.USER_INFO_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
@@ -102,3 +110,43 @@ func AddUserListByUserIDListsToRedis(userList []model.User) error {
 	})
 	return err
 }
+
+// SetUserPrivacyInRedis 修改缓存中用户的隐私设置，缓存不存在时跳过
+func SetUserPrivacyInRedis(userID uint64, isPrivate bool) error {
+	// 定义 key
+	userRedis := fmt.Sprintf(UserPattern, userID)
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) > 0 then
+				redis.call("HSet", KEYS[1], "is_private", ARGV[1])
+				return true
+			end
+			return false
+		`)
+	keys := []string{userRedis}
+	values := []interface{}{isPrivate}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// SetUserProfileInRedis 修改缓存中用户的个人资料，缓存不存在时跳过
+func SetUserProfileInRedis(user *model.User) error {
+	// 定义 key
+	userRedis := fmt.Sprintf(UserPattern, user.UserID)
+	lua := redis.NewScript(`
+			if redis.call("Exists", KEYS[1]) > 0 then
+				redis.call("HSet", KEYS[1], "avatar", ARGV[1], "background_image", ARGV[2], "signature", ARGV[3])
+				return true
+			end
+			return false
+		`)
+	keys := []string{userRedis}
+	values := []interface{}{user.Avatar, user.BackgroundImage, user.Signature}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
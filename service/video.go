@@ -6,14 +6,23 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/go-redis/redis/v8"
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
 
 // GetFeedVideosAndAuthorsRedis 获取推送视频以及其作者并返回视频数
-func GetFeedVideosAndAuthorsRedis(videoList *[]model.Video, authors *[]model.User, LatestTime int64, MaxNumVideo int) (int, error) {
-	// 确保 feed 在 redis 中
-	if err := GoFeed(); err != nil {
+func GetFeedVideosAndAuthorsRedis(viewerID uint64, videoList *[]model.Video, authors *[]model.User, LatestTime int64, MaxNumVideo int) (int, error) {
+	// 确保 feed 在 redis 中，Redis 不可用时直接查表
+	if err := GoFeed(); err != nil && err != ErrCacheUnavailable {
+		return 0, err
+	}
+	// 当前用户拉黑或屏蔽的作者，以及未关注的私密账号不出现在视频流中
+	visibility, err := newAuthorVisibility(viewerID)
+	if err != nil {
 		return 0, err
 	}
 	// 初始化查询条件， Offset和 Count用于分页
@@ -23,120 +32,332 @@ func GetFeedVideosAndAuthorsRedis(videoList *[]model.Video, authors *[]model.Use
 		Offset: 0,                                                           // 类似sql的limit, 表示开始偏移量
 		Count:  int64(MaxNumVideo),                                          // 一次返回多少数据
 	}
-	// 获取推送视频ID按逆序返回
-	videoIDStrList, err := global.REDIS.ZRevRangeByScore(global.CONTEXT, "feed", &op).Result()
-	numVideos := len(videoIDStrList)
-	if err != nil || numVideos == 0 {
-		return 0, err
-	}
-
-	videoIDList := make([]uint64, 0, numVideos)
-	for _, videoIDStr := range videoIDStrList {
-		videoID, err := strconv.ParseUint(videoIDStr, 10, 64)
+	*videoList = make([]model.Video, 0, MaxNumVideo)
+	*authors = make([]model.User, 0, MaxNumVideo)
+	// 过滤后不足一页时继续向后读取
+	for len(*videoList) < MaxNumVideo {
+		// 获取推送视频ID按逆序返回
+		videoIDStrList, err := global.REDIS.ZRevRangeByScore(global.CONTEXT, "feed", &op).Result()
+		if err == ErrCacheUnavailable {
+			err = global.DB.Model(&model.Video{}).Where("status = ? and visibility = ? and created_at <= ?",
+				model.VideoStatusReady, model.VideoVisibilityPublic, time.UnixMilli(LatestTime-2)).
+				Order("created_at desc, video_id desc").Offset(int(op.Offset)).Limit(int(op.Count)).
+				Pluck("video_id", &videoIDStrList).Error
+		}
 		if err != nil {
-			continue
+			return 0, err
 		}
-		videoIDList = append(videoIDList, videoID)
-	}
-	if err = GetVideoListByIDsRedis(videoList, videoIDList); err != nil {
-		return 0, err
-	}
-	numVideos = len(*videoList)
-	// 批量或者视频作者
-	authorIDList := make([]uint64, numVideos)
-	for i, video := range *videoList {
-		authorIDList[i] = video.AuthorID
-	}
-	if err = GetUserListByUserIDs(authorIDList, authors); err != nil {
-		return 0, err
+		if len(videoIDStrList) == 0 {
+			break
+		}
+		videoIDList := make([]uint64, 0, len(videoIDStrList))
+		for _, videoIDStr := range videoIDStrList {
+			videoID, err := strconv.ParseUint(videoIDStr, 10, 64)
+			if err != nil {
+				continue
+			}
+			videoIDList = append(videoIDList, videoID)
+		}
+		var candidateList []model.Video
+		if err = GetVideoListByIDsRedis(&candidateList, videoIDList); err != nil {
+			return 0, err
+		}
+		// 批量获取视频作者
+		authorIDList := make([]uint64, len(candidateList))
+		for i, video := range candidateList {
+			authorIDList[i] = video.AuthorID
+		}
+		var candidateAuthorList []model.User
+		if err = GetUserListByUserIDs(authorIDList, &candidateAuthorList); err != nil {
+			return 0, err
+		}
+		for i, video := range candidateList {
+			if !visibility.visible(&candidateAuthorList[i]) {
+				continue
+			}
+			*videoList = append(*videoList, video)
+			*authors = append(*authors, candidateAuthorList[i])
+			if len(*videoList) >= MaxNumVideo {
+				break
+			}
+		}
+		if int64(len(videoIDStrList)) < op.Count {
+			break
+		}
+		op.Offset += op.Count
 	}
-	return numVideos, nil
+	return len(*videoList), nil
 }
 
-// PublishVideo 将用户上传的视频信息写入数据库
-func PublishVideo(userID uint64, videoID uint64, videoName string, coverName string, title string) error {
+// PublishVideo 将用户上传的视频信息写入数据库并加入转码队列，转码完成且到达定时发布时间后才会出现在视频流中
+func PublishVideo(userID uint64, videoID uint64, sourceName string, title string, visibility int8, publishAt *time.Time) error {
 	video := model.Video{
-		VideoID:   videoID,
-		Title:     title,
-		PlayName:  videoName,
-		CoverName: coverName,
+		VideoID:    videoID,
+		Title:      title,
+		SourceName: sourceName,
+		Status:     model.VideoStatusPending,
+		Visibility: visibility,
+		PublishAt:  publishAt,
 		//FavoriteCount: 0,
 		//CommentCount:  0,
 		AuthorID:  userID,
 		CreatedAt: time.Now(),
 	}
-	if global.DB.Create(&video).Error != nil {
+	// 写入视频并关联标题中的话题
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&video).Error; err != nil {
+			return err
+		}
+		if err := saveMentions(tx, userID, videoID, 0, title); err != nil {
+			return err
+		}
+		return saveVideoTags(tx, videoID, title)
+	})
+	if err != nil {
 		return errors.New("video表插入失败")
 	}
-	keyPublish := fmt.Sprintf(PublishPattern, userID)
-	n, err := global.REDIS.Exists(global.CONTEXT, keyPublish).Result()
+	// 队列已满时由周期性扫描重新入队
+	EnqueueTranscode(videoID)
+	return nil
+}
+
+// DeleteVideo 作者删除视频：软删除视频及其评论，取消全部点赞，并清理缓存；媒体文件由 StartMediaGC 延迟删除
+func DeleteVideo(userID uint64, videoID uint64) error {
+	var (
+		video              model.Video
+		favoriteUserIDList []uint64
+		commentList        []model.Comment
+	)
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		// author_id 用来确保只有作者才能删除
+		if result := tx.Where("video_id = ? and author_id = ?", videoID, userID).Limit(1).Find(&video); result.Error != nil {
+			return result.Error
+		} else if result.RowsAffected == 0 {
+			return errors.New("invalid delete")
+		}
+		if err := tx.Delete(&video).Error; err != nil {
+			return err
+		}
+		// 取消全部点赞
+		if err := tx.Model(&model.Favorite{}).Where("video_id = ? and is_favorite = ?", videoID, true).
+			Pluck("user_id", &favoriteUserIDList).Error; err != nil {
+			return err
+		}
+		if len(favoriteUserIDList) > 0 {
+			if err := tx.Model(&model.Favorite{}).Where("video_id = ? and is_favorite = ?", videoID, true).
+				Update("is_favorite", false).Error; err != nil {
+				return err
+			}
+		}
+		// 删除全部评论与回复
+		if err := tx.Select("comment_id", "parent_id").Where("video_id = ?", videoID).Find(&commentList).Error; err != nil {
+			return err
+		}
+		if len(commentList) > 0 {
+			if err := tx.Where("video_id = ?", videoID).Delete(&model.Comment{}).Error; err != nil {
+				return err
+			}
+		}
+		// 删除标题和评论中的提及
+		if err := tx.Where("video_id = ?", videoID).Delete(&model.Mention{}).Error; err != nil {
+			return err
+		}
+		// 相关通知不再展示，标记为已读
+		return tx.Model(&model.Notification{}).Where("video_id = ? and unread = ?", videoID, true).
+			Update("unread", nil).Error
+	})
+	if err != nil {
+		return err
+	}
+	// 转码失败的视频直接删除上传的原始文件，等待或正在转码的视频由转码协程删除
+	if video.Status == model.VideoStatusFailed && video.SourceName != "" {
+		_ = os.Remove(filepath.Join(global.UPLOAD_ADDR, video.SourceName))
+	}
+	// 更新计数
+	deltas := make([]counterDelta, 0, len(favoriteUserIDList)+3)
+	deltas = append(deltas, counterDelta{videoFavoriteCounter, videoID, -int64(len(favoriteUserIDList))},
+		counterDelta{videoCommentCounter, videoID, -int64(len(commentList))},
+		counterDelta{userTotalFavoritedCounter, video.AuthorID, -int64(len(favoriteUserIDList))})
+	for _, each := range favoriteUserIDList {
+		deltas = append(deltas, counterDelta{userFavoriteCounter, each, -1})
+	}
+	if err = incrCounters(deltas...); err != nil {
+		return err
+	}
+	// 更新缓存
+	return skipCacheUnavailable(DeleteVideoInRedis(&video, favoriteUserIDList, commentList))
+}
+
+// UpdateVideoTitle 作者修改视频标题，并重新关联标题中的话题
+func UpdateVideoTitle(userID uint64, videoID uint64, title string) error {
+	err := global.DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.Video{}).Where("video_id = ? and author_id = ?", videoID, userID).Update("title", title)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 标题未变化时影响行数也为 0，再确认视频是否属于当前用户
+			var count int64
+			if err := tx.Model(&model.Video{}).Where("video_id = ? and author_id = ?", videoID, userID).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count == 0 {
+				return errors.New("invalid update")
+			}
+		}
+		// 更新标题中的提及，只通知新提及的用户
+		if err := updateTitleMentions(tx, userID, videoID, title); err != nil {
+			return err
+		}
+		return saveVideoTags(tx, videoID, title)
+	})
 	if err != nil {
 		return err
 	}
+	// 更新缓存
+	return skipCacheUnavailable(SetVideoTitleInRedis(videoID, title))
+}
+
+// FilterVisibleVideoList 过滤当前用户无权查看的视频：仅自己可见的视频只对作者可见，仅粉丝可见的视频只对作者和粉丝可见
+func FilterVisibleVideoList(viewerID uint64, videoList []model.Video) ([]model.Video, error) {
+	var followSet map[uint64]void
+	visibleList := make([]model.Video, 0, len(videoList))
+	for _, video := range videoList {
+		if video.Visibility == model.VideoVisibilityPublic || (viewerID != 0 && video.AuthorID == viewerID) {
+			visibleList = append(visibleList, video)
+			continue
+		}
+		if video.Visibility != model.VideoVisibilityFollowers || viewerID == 0 {
+			continue
+		}
+		if followSet == nil {
+			// 通过用户 ID 查询关注 ID 列表
+			celebrityIDList, err := GetFollowIDListByUserID(viewerID)
+			if err != nil {
+				return nil, err
+			}
+			followSet = make(map[uint64]void, len(celebrityIDList))
+			for _, each := range celebrityIDList {
+				followSet[each] = member
+			}
+		}
+		if _, ok := followSet[video.AuthorID]; ok {
+			visibleList = append(visibleList, video)
+		}
+	}
+	return visibleList, nil
+}
+
+// GetVisibleVideosAndAuthors 根据视频 ID 列表获取视频及其作者，并过滤已不存在的视频，以及当前用户拉黑或屏蔽的作者和未关注的私密账号
+func GetVisibleVideosAndAuthors(viewerID uint64, videoIDList []uint64) ([]model.Video, []model.User, error) {
+	var candidateList []model.Video
+	if err := GetVideoListByIDsRedis(&candidateList, videoIDList); err != nil {
+		return nil, nil, err
+	}
+	// 批量获取视频作者
+	authorIDList := make([]uint64, len(candidateList))
+	for i, video := range candidateList {
+		authorIDList[i] = video.AuthorID
+	}
+	var candidateAuthorList []model.User
+	if err := GetUserListByUserIDs(authorIDList, &candidateAuthorList); err != nil {
+		return nil, nil, err
+	}
+	visibility, err := newAuthorVisibility(viewerID)
+	if err != nil {
+		return nil, nil, err
+	}
+	videoList := make([]model.Video, 0, len(candidateList))
+	authorList := make([]model.User, 0, len(candidateList))
+	for i, video := range candidateList {
+		if video.VideoID != 0 && visibility.visible(&candidateAuthorList[i]) {
+			videoList = append(videoList, video)
+			authorList = append(authorList, candidateAuthorList[i])
+		}
+	}
+	return videoList, authorList, nil
+}
 
+// GoPublishVideo 视频转码完成后的缓存操作：加入视频流、发布列表，并推送给粉丝，重复执行结果一致
+// Redis 不可用时仍执行全部步骤，使涉及的 key 都被记录并在恢复后失效
+func GoPublishVideo(video model.Video) error {
+	keyPublish := fmt.Sprintf(PublishPattern, video.AuthorID)
+	n, err := global.REDIS.Exists(global.CONTEXT, keyPublish).Result()
+	if err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	var listZ []*redis.Z
 	if n <= 0 {
 		//	keyPublish不存在 查询mysql将用户发布过的视频全部写入缓存中
 		var videoList []model.Video
-		if err = global.DB.Where("author_id = ?", userID).Find(&videoList).Error; err != nil {
+		if err = global.DB.Where("author_id = ? and status = ?", video.AuthorID, model.VideoStatusReady).
+			Find(&videoList).Error; err != nil {
 			return err
 		}
-		var listZ = make([]*redis.Z, 0, len(videoList))
+		listZ = make([]*redis.Z, 0, len(videoList))
 		for _, video_ := range videoList {
 			listZ = append(listZ, &redis.Z{Score: float64(video_.CreatedAt.UnixMilli()) / 1000, Member: video_.VideoID})
 		}
-		return PublishEvent(video, listZ...)
+	} else {
+		// keyPublish存在 只添加当前上传的视频
+		listZ = []*redis.Z{{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: video.VideoID}}
 	}
-	// keyPublish存在 只添加当前上传的视频
-	Z := redis.Z{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: videoID}
-	return PublishEvent(video, &Z)
+	if err = PublishEvent(video, listZ...); err != nil && err != ErrCacheUnavailable {
+		return err
+	}
+	// 公开视频加入热榜
+	if video.Visibility == model.VideoVisibilityPublic {
+		if err = AddHotScoreNX(video.VideoID, global.HOT_PUBLISH_WEIGHT); err != nil && err != ErrCacheUnavailable {
+			return err
+		}
+	}
+	// 仅自己可见的视频不推送
+	if video.Visibility == model.VideoVisibilityPrivate {
+		return nil
+	}
+	// 推送到粉丝的关注收件箱
+	return skipCacheUnavailable(FanOutVideo(video))
 }
 
 // GetPublishedVideosRedis 获取用户上传的视频列表
 func GetPublishedVideosRedis(videoList *[]model.Video, userID uint64) (int, error) {
 	keyEmpty := fmt.Sprintf(EmptyPattern, userID)
 	n, err := global.REDIS.Exists(global.CONTEXT, keyEmpty).Result()
-	if n > 0 || err != nil {
-		// 当前用户没有发布过视频
+	if err != nil && err != ErrCacheUnavailable {
 		return 0, err
 	}
+	if n > 0 {
+		// 当前用户没有发布过视频
+		return 0, nil
+	}
 	keyPublish := fmt.Sprintf(PublishPattern, userID)
 	n, err = global.REDIS.Exists(global.CONTEXT, keyPublish).Result()
-	if err != nil {
+	if err != nil && err != ErrCacheUnavailable {
 		return 0, err
 	}
 	if n <= 0 {
-		// "publish userid"不存在
+		// "publish userid"不存在或 Redis 不可用
 		// 因为有序集合插入时需要video的创建时间当做score，所以不能只查主键
-		result := global.DB.Where("author_id = ?", userID).Find(videoList)
+		result := global.DB.Where("author_id = ? and status = ?", userID, model.VideoStatusReady).Find(videoList)
 		numVideos := int(result.RowsAffected)
 
 		if result.Error != nil {
 			return 0, err
 		}
 		if numVideos == 0 {
-			return 0, SetUserPublishEmpty(userID)
+			return 0, skipCacheUnavailable(SetUserPublishEmpty(userID))
 		}
 		var listZ = make([]*redis.Z, 0, numVideos)
-		var videoIDList = make([]uint64, 0, numVideos)
 		for _, video_ := range *videoList {
 			listZ = append(listZ, &redis.Z{Score: float64(video_.CreatedAt.UnixMilli()) / 1000, Member: video_.VideoID})
-			videoIDList = append(videoIDList, video_.VideoID)
 		}
-		// 批量查找favorite_count与comment_count
-		favoriteCountList, err := GetFavoriteCountListByVideoIDList(videoIDList)
-		if err != nil {
+		// favorite_count 与 comment_count 加上尚未写回的增量
+		if err = addPendingVideoCounts(*videoList); err != nil {
 			return 0, err
 		}
-		var commentCountList []int64
-		if err = GetCommentCountListByVideoIDList(videoIDList, &commentCountList); err != nil {
-			return 0, err
-		}
-		for i := range *videoList {
-			(*videoList)[i].FavoriteCount = favoriteCountList[i]
-			(*videoList)[i].CommentCount = commentCountList[i]
-		}
 		// 将用户发表过的视频列表写入缓存
-		if err = GoPublish(userID, listZ...); err != nil {
+		if err = GoPublish(userID, listZ...); err != nil && err != ErrCacheUnavailable {
 			return 0, err
 		}
 
@@ -168,19 +389,49 @@ func GetPublishedVideosRedis(videoList *[]model.Video, userID uint64) (int, erro
 
 // GetVideoListByIDsRedis 给定视频ID列表得到对应的视频信息
 func GetVideoListByIDsRedis(videoList *[]model.Video, videoIDs []uint64) error {
+	var (
+		inCache          []bool
+		notInCacheIDList []uint64
+		err              error
+	)
+	if *videoList, inCache, notInCacheIDList, err = getVideoListFromRedis(videoIDs); err != nil {
+		return err
+	}
+	if len(notInCacheIDList) == 0 {
+		// 视频全部在缓存中则提前返回
+		return nil
+	}
+	// 批量查找不在redis的video
+	var notInCacheVideoList []model.Video
+	if err = GetVideoListByIDsSql(&notInCacheVideoList, notInCacheIDList); err != nil {
+		return err
+	}
+	// 将不在redis中的video填入返回值
+	idxNotInCache := 0
+	for i := range *videoList {
+		if inCache[i] == false {
+			(*videoList)[i] = notInCacheVideoList[idxNotInCache]
+			idxNotInCache++
+		}
+	}
+	return nil
+}
+
+// getVideoListFromRedis 从缓存读取视频信息，不在缓存中的视频以空值占位，并返回其 ID 列表
+func getVideoListFromRedis(videoIDs []uint64) ([]model.Video, []bool, []uint64, error) {
 	numVideos := len(videoIDs)
-	*videoList = make([]model.Video, 0, numVideos)
+	videoList := make([]model.Video, 0, numVideos)
 	inCache := make([]bool, 0, numVideos)
 	notInCacheIDList := make([]uint64, 0, numVideos)
 	for _, videoID := range videoIDs {
 		keyVideo := fmt.Sprintf(VideoPattern, videoID)
 		n, err := global.REDIS.Exists(global.CONTEXT, keyVideo).Result()
-		if err != nil {
-			return err
+		if err != nil && err != ErrCacheUnavailable {
+			return nil, nil, nil, err
 		}
 		if n <= 0 {
-			// 当前视频不在缓存中
-			*videoList = append(*videoList, model.Video{})
+			// 当前视频不在缓存中或 Redis 不可用
+			videoList = append(videoList, model.Video{})
 			inCache = append(inCache, false)
 			notInCacheIDList = append(notInCacheIDList, videoID)
 			continue
@@ -188,10 +439,10 @@ func GetVideoListByIDsRedis(videoList *[]model.Video, videoIDs []uint64) error {
 		// video存在
 		var video model.Video
 		if err = global.REDIS.Expire(global.CONTEXT, keyVideo, global.VIDEO_EXPIRE).Err(); err != nil {
-			return err
+			return nil, nil, nil, err
 		}
 		if err = global.REDIS.HGetAll(global.CONTEXT, keyVideo).Scan(&video); err != nil {
-			return errors.New("GetVideoListByIDsRedis fail")
+			return nil, nil, nil, errors.New("GetVideoListByIDsRedis fail")
 		}
 		video.VideoID = videoID
 		timeUnixMilliStr, err := global.REDIS.HGet(global.CONTEXT, keyVideo, "created_at").Result()
@@ -203,79 +454,86 @@ func GetVideoListByIDsRedis(videoList *[]model.Video, videoIDs []uint64) error {
 			continue
 		}
 		video.CreatedAt = time.UnixMilli(timeUnixMilli)
-		*videoList = append(*videoList, video)
+		videoList = append(videoList, video)
 		inCache = append(inCache, true)
 	}
-	if len(notInCacheIDList) == 0 {
-		// 视频全部在缓存中则提前返回
-		return nil
+	return videoList, inCache, notInCacheIDList, nil
+}
+
+// GetVideoListByIDsSql 被调用当videoID不在redis中，我们不得不查sql
+// 每个视频并行重建缓存，并发请求同一视频时只由一个调用方查表并写入缓存，其余调用方等待后读取缓存
+func GetVideoListByIDsSql(videoList *[]model.Video, videoIDs []uint64) error {
+	loadedList := make([]model.Video, len(videoIDs))
+	var group errgroup.Group
+	for i, videoID := range videoIDs {
+		i, videoID := i, videoID
+		group.Go(func() error {
+			value, err := rebuildCache(fmt.Sprintf(VideoPattern, videoID), func() (interface{}, error) {
+				return loadVideoList([]uint64{videoID})
+			}, func() (interface{}, bool, error) {
+				cachedList, _, notInCacheIDList, err := getVideoListFromRedis([]uint64{videoID})
+				return cachedList, err == nil && len(notInCacheIDList) == 0, err
+			})
+			if err != nil {
+				return err
+			}
+			// 结果由并发请求共享，复制到返回值中
+			loadedList[i] = value.([]model.Video)[0]
+			return nil
+		})
 	}
-	// 批量查找不在redis的video
-	var notInCacheVideoList []model.Video
-	if err := GetVideoListByIDsSql(&notInCacheVideoList, notInCacheIDList); err != nil {
+	if err := group.Wait(); err != nil {
 		return err
 	}
-	// 将不在redis中的video填入返回值
-	idxNotInCache := 0
-	for i := range *videoList {
-		if inCache[i] == false {
-			(*videoList)[i] = notInCacheVideoList[idxNotInCache]
-			idxNotInCache++
-		}
-	}
+	*videoList = loadedList
 	return nil
 }
 
-// GetVideoListByIDsSql 被调用当videoID不在redis中，我们不得不查sql
-func GetVideoListByIDsSql(videoList *[]model.Video, videoIDs []uint64) error {
+// loadVideoList 从数据库查询视频信息并写入缓存，不存在的视频以空值占位
+func loadVideoList(videoIDs []uint64) ([]model.Video, error) {
 	var uniqueVideoList []model.Video
 	result := global.DB.Where("video_id in ?", videoIDs).Find(&uniqueVideoList)
 	if result.Error != nil {
-		return result.Error
+		return nil, result.Error
 	}
 	numVideos := result.RowsAffected
+	// favorite_count 与 comment_count 加上尚未写回的增量
+	if err := addPendingVideoCounts(uniqueVideoList); err != nil {
+		return nil, err
+	}
 	// 针对查询结果建立映射关系
-	*videoList = make([]model.Video, 0, numVideos)
+	videoList := make([]model.Video, 0, numVideos)
 	mapVideoIDToVideo := make(map[uint64]model.Video, numVideos)
 	for _, video := range uniqueVideoList {
 		mapVideoIDToVideo[video.VideoID] = video
 	}
-	// 查询favorite_count与comment_count
-	var commentCountList []int64
-	if err := GetCommentCountListByVideoIDListSql(videoIDs, &commentCountList); err != nil {
-		return err
-	}
-	favoriteCountList, err := GetFavoriteCountListByVideoIDList(videoIDs)
-	if err != nil {
-		return err
-	}
-	for i, videoID := range videoIDs {
-		tmpVideo := mapVideoIDToVideo[videoID]
-		tmpVideo.FavoriteCount = favoriteCountList[i]
-		tmpVideo.CommentCount = commentCountList[i]
-		*videoList = append(*videoList, tmpVideo)
+	for _, videoID := range videoIDs {
+		videoList = append(videoList, mapVideoIDToVideo[videoID])
 	}
 	// 当视频信息写入缓存
-	return GoVideoList(*videoList)
+	return videoList, skipCacheUnavailable(GoVideoList(videoList))
 }
 
 // GetVideoIDListByUserID 得到用户发表过的视频id列表
 func GetVideoIDListByUserID(userID uint64, videoIDList *[]uint64) error {
 	keyEmpty := fmt.Sprintf(EmptyPattern, userID)
 	n, err := global.REDIS.Exists(global.CONTEXT, keyEmpty).Result()
-	if n > 0 || err != nil {
-		// 当前用户没有发布过视频
+	if err != nil && err != ErrCacheUnavailable {
 		return err
 	}
+	if n > 0 {
+		// 当前用户没有发布过视频
+		return nil
+	}
 	keyPublish := fmt.Sprintf(VideoCommentsPattern, userID)
 	n, err = global.REDIS.Exists(global.CONTEXT, keyPublish).Result()
-	if err != nil {
+	if err != nil && err != ErrCacheUnavailable {
 		return err
 	}
 	if n <= 0 {
-		// "publish userid"不存在
+		// "publish userid"不存在或 Redis 不可用
 		var videoList []model.Video
-		result := global.DB.Where("author_id = ?", userID).Find(&videoList)
+		result := global.DB.Where("author_id = ? and status = ?", userID, model.VideoStatusReady).Find(&videoList)
 		if result.Error != nil {
 			return err
 		}
@@ -293,7 +551,7 @@ func GetVideoIDListByUserID(userID uint64, videoIDList *[]uint64) error {
 			listZ = append(listZ, &redis.Z{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: video.VideoID})
 		}
 		// 写入缓存
-		return GoPublish(userID, listZ...)
+		return skipCacheUnavailable(GoPublish(userID, listZ...))
 	}
 	// "publish userid"存在
 	if err = global.REDIS.Expire(global.CONTEXT, keyPublish, global.PUBLISH_EXPIRE).Err(); err != nil {
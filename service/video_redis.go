@@ -28,7 +28,7 @@ func GoVideoList(videoList []model.Video) error {
 	for _, video := range videoList {
 		keyVideo := fmt.Sprintf(VideoPattern, video.VideoID)
 		pipe.HSet(global.CONTEXT, keyVideo, "title", video.Title, "play_name", video.PlayName, "cover_name", video.CoverName,
-			"favorite_count", video.FavoriteCount, "comment_count", video.CommentCount, "author_id", video.AuthorID, "created_at", video.CreatedAt.UnixMilli())
+			"favorite_count", video.FavoriteCount, "comment_count", video.CommentCount, "author_id", video.AuthorID, "visibility", video.Visibility, "created_at", video.CreatedAt.UnixMilli())
 		pipe.Expire(global.CONTEXT, keyVideo, global.VIDEO_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
 	}
 	_, err := pipe.Exec(global.CONTEXT)
@@ -44,7 +44,8 @@ func GoFeed() error {
 	if n <= 0 {
 		// "feed"不存在
 		var allVideos []model.Video
-		if err := global.DB.Find(&allVideos).Error; err != nil {
+		if err := global.DB.Where("status = ? and visibility = ?", model.VideoStatusReady, model.VideoVisibilityPublic).
+			Find(&allVideos).Error; err != nil {
 			return err
 		}
 		if len(allVideos) == 0 {
@@ -66,12 +67,15 @@ func PublishEvent(video model.Video, listZ ...*redis.Z) error {
 	keyEmpty := fmt.Sprintf(EmptyPattern, video.AuthorID)
 	videoIDStr := strconv.FormatUint(video.VideoID, 10)
 	pipe := global.REDIS.TxPipeline()
-	pipe.ZAdd(global.CONTEXT, "feed", &redis.Z{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: videoIDStr})
+	// 只有公开视频进入视频流
+	if video.Visibility == model.VideoVisibilityPublic {
+		pipe.ZAdd(global.CONTEXT, "feed", &redis.Z{Score: float64(video.CreatedAt.UnixMilli()) / 1000, Member: videoIDStr})
+	}
 	pipe.ZAdd(global.CONTEXT, keyPublish, listZ...)
 	pipe.Expire(global.CONTEXT, keyPublish, global.PUBLISH_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
 
 	pipe.HSet(global.CONTEXT, keyVideo, "author_id", video.AuthorID, "play_name", video.PlayName, "cover_name", video.CoverName,
-		"favorite_count", video.FavoriteCount, "comment_count", video.CommentCount, "title", video.Title, "created_at", video.CreatedAt.UnixMilli())
+		"favorite_count", video.FavoriteCount, "comment_count", video.CommentCount, "title", video.Title, "visibility", video.Visibility, "created_at", video.CreatedAt.UnixMilli())
 	pipe.Expire(global.CONTEXT, keyVideo, global.VIDEO_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second)
 	pipe.Del(global.CONTEXT, keyEmpty)
 	_, err := pipe.Exec(global.CONTEXT)
@@ -114,3 +118,71 @@ func SetUserPublishEmpty(userID uint64) error {
 	keyEmpty := fmt.Sprintf(EmptyPattern, userID)
 	return global.REDIS.Set(global.CONTEXT, keyEmpty, "1", global.EMPTY_EXPIRE+time.Duration(rand.Float64()*global.EXPIRE_TIME_JITTER.Seconds())*time.Second).Err()
 }
+
+// DeleteVideoInRedis 删除视频后的缓存操作：移出视频流、发布列表和热榜，删除视频与评论缓存，并修正点赞者的点赞数目和作者的获赞总数
+func DeleteVideoInRedis(video *model.Video, favoriteUserIDList []uint64, commentList []model.Comment) error {
+	videoIDStr := strconv.FormatUint(video.VideoID, 10)
+	pipe := global.REDIS.TxPipeline()
+	pipe.ZRem(global.CONTEXT, "feed", videoIDStr)
+	pipe.ZRem(global.CONTEXT, HotKey, videoIDStr)
+	pipe.ZRem(global.CONTEXT, fmt.Sprintf(PublishPattern, video.AuthorID), videoIDStr)
+	pipe.Del(global.CONTEXT, fmt.Sprintf(VideoPattern, video.VideoID), fmt.Sprintf(VideoCommentsPattern, video.VideoID),
+		fmt.Sprintf(VideoTopCommentsPattern, video.VideoID))
+	for _, comment := range commentList {
+		pipe.Del(global.CONTEXT, fmt.Sprintf(CommentPattern, comment.CommentID))
+		if comment.ParentID == 0 {
+			pipe.Del(global.CONTEXT, fmt.Sprintf(CommentRepliesPattern, comment.CommentID))
+		}
+	}
+	if _, err := pipe.Exec(global.CONTEXT); err != nil {
+		return err
+	}
+	if len(favoriteUserIDList) == 0 {
+		return nil
+	}
+	// KEYS[1] 为作者信息，之后依次为每个点赞者的点赞集合和用户信息
+	keys := make([]string, 0, 2*len(favoriteUserIDList)+1)
+	keys = append(keys, fmt.Sprintf(UserPattern, video.AuthorID))
+	for _, userID := range favoriteUserIDList {
+		keys = append(keys, fmt.Sprintf(UserFavoritePattern, userID), fmt.Sprintf(UserPattern, userID))
+	}
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("HIncrBy", KEYS[1], "total_favorited", -tonumber(ARGV[2]))
+				end
+				for i = 2, #KEYS, 2 do
+					if redis.call("Exists", KEYS[i]) > 0 then
+						redis.call("ZAdd", KEYS[i], 0, ARGV[1])
+					end
+					if redis.call("Exists", KEYS[i + 1]) > 0 then
+						redis.call("HIncrBy", KEYS[i + 1], "favorite_count", -1)
+					end
+				end
+				return true
+			`)
+	values := []interface{}{video.VideoID, len(favoriteUserIDList)}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
+
+// SetVideoTitleInRedis 修改缓存中视频的标题，缓存不存在时跳过
+func SetVideoTitleInRedis(videoID uint64, title string) error {
+	keyVideo := fmt.Sprintf(VideoPattern, videoID)
+	lua := redis.NewScript(`
+				if redis.call("Exists", KEYS[1]) > 0 then
+					redis.call("HSet", KEYS[1], "title", ARGV[1])
+					return true
+				end
+				return false
+			`)
+	keys := []string{keyVideo}
+	values := []interface{}{title}
+	err := lua.Run(global.CONTEXT, global.REDIS, keys, values).Err()
+	if err == nil || err == redis.Nil {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Local 本地磁盘存储，文件由 Gin 以静态文件的形式提供访问
+type Local struct {
+	Root      string // 存储根目录
+	URLPrefix string // 静态文件路由前缀
+	BaseURL   string // 访问域名，为空时返回相对地址
+}
+
+// NewLocal 创建本地存储并确保根目录存在
+func NewLocal(root, urlPrefix, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Local{
+		Root:      root,
+		URLPrefix: "/" + strings.Trim(urlPrefix, "/"),
+		BaseURL:   strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+func (l *Local) Put(key string, localPath string) error {
+	dst := filepath.Join(l.Root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+	// 优先直接移动文件，跨设备时退化为复制
+	if err := os.Rename(localPath, dst); err == nil {
+		return nil
+	}
+	src, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(localPath)
+}
+
+func (l *Local) Delete(key string) error {
+	err := os.Remove(filepath.Join(l.Root, filepath.FromSlash(key)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (l *Local) DeletePrefix(prefix string) error {
+	return os.RemoveAll(filepath.Join(l.Root, filepath.FromSlash(prefix)))
+}
+
+func (l *Local) URL(key string) string {
+	return l.BaseURL + l.URLPrefix + "/" + key
+}
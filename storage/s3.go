@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// S3 兼容 S3 协议的对象存储（AWS S3、MinIO 等）
+type S3 struct {
+	client    *s3.S3
+	uploader  *s3manager.Uploader
+	bucket    string
+	baseURL   string
+	presign   bool          // 是否返回带签名的临时地址
+	urlExpire time.Duration // 签名地址有效期
+}
+
+// NewS3 创建 S3 存储，bucket 不存在时自动创建
+// publicRead 为 true 时为 bucket 设置匿名只读策略；否则未配置 baseURL 时返回带签名的临时地址，
+// 配置了 baseURL 时认为由 CDN 负责访问授权，直接返回 CDN 地址
+func NewS3(endpoint, region, accessKey, secretKey, bucket, baseURL string, useSSL bool,
+	publicRead bool, urlExpire time.Duration) (*S3, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(region),
+		Credentials:      credentials.NewStaticCredentials(accessKey, secretKey, ""),
+		S3ForcePathStyle: aws.Bool(true), // MinIO 需要使用路径形式访问 bucket
+		DisableSSL:       aws.Bool(!useSSL),
+	})
+	if err != nil {
+		return nil, err
+	}
+	presign := !publicRead && baseURL == ""
+	if baseURL == "" {
+		scheme := "http"
+		if useSSL {
+			scheme = "https"
+		}
+		baseURL = fmt.Sprintf("%s://%s/%s", scheme, endpoint, bucket)
+	}
+	if urlExpire <= 0 {
+		urlExpire = time.Hour
+	}
+	s := &S3{
+		client:    s3.New(sess),
+		uploader:  s3manager.NewUploader(sess),
+		bucket:    bucket,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		presign:   presign,
+		urlExpire: urlExpire,
+	}
+	if err = s.ensureBucket(); err != nil {
+		return nil, err
+	}
+	if publicRead {
+		if _, err = s.client.PutBucketPolicy(&s3.PutBucketPolicyInput{
+			Bucket: aws.String(bucket),
+			Policy: aws.String(publicReadPolicy(bucket)),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// publicReadPolicy 返回允许匿名读取 bucket 内全部文件的策略
+func publicReadPolicy(bucket string) string {
+	return fmt.Sprintf(`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},`+
+		`"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::%s/*"]}]}`, bucket)
+}
+
+// ensureBucket 确保 bucket 存在
+func (s *S3) ensureBucket() error {
+	_, err := s.client.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	if err == nil {
+		return nil
+	}
+	if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != 404 {
+		return err
+	}
+	_, err = s.client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(s.bucket)})
+	return err
+}
+
+func (s *S3) Put(key string, localPath string) error {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	if _, err = s.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(contentType),
+	}); err != nil {
+		return err
+	}
+	file.Close()
+	return os.Remove(localPath)
+}
+
+func (s *S3) Delete(key string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3) DeletePrefix(prefix string) error {
+	iter := s3manager.NewDeleteListIterator(s.client, &s3.ListObjectsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(strings.TrimRight(prefix, "/") + "/"),
+	})
+	return s3manager.NewBatchDeleteWithClient(s.client).Delete(aws.BackgroundContext(), iter)
+}
+
+// URL 返回文件的访问地址，bucket 不允许匿名读取时返回带签名的临时地址
+// HLS 播放列表中的分片地址是相对地址，无法签名，私有 bucket 需通过 CDN 播放
+func (s *S3) URL(key string) string {
+	if !s.presign {
+		return s.baseURL + "/" + key
+	}
+	req, _ := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	url, err := req.Presign(s.urlExpire)
+	if err != nil {
+		return s.baseURL + "/" + key
+	}
+	return url
+}
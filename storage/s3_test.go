@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestS3 创建不访问网络的 S3 存储，签名在本地完成
+func newTestS3(t *testing.T, presign bool) *S3 {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String("127.0.0.1:9000"),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("minioadmin", "minioadmin", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &S3{
+		client:    s3.New(sess),
+		bucket:    "green-bean-miners",
+		baseURL:   "http://127.0.0.1:9000/green-bean-miners",
+		presign:   presign,
+		urlExpire: 10 * time.Minute,
+	}
+}
+
+func TestS3PublicURL(t *testing.T) {
+	s := newTestS3(t, false)
+	if got := s.URL(CoverKey("1.jpg")); got != "http://127.0.0.1:9000/green-bean-miners/cover/1.jpg" {
+		t.Fatalf("unexpected url %s", got)
+	}
+}
+
+func TestS3PresignedURL(t *testing.T) {
+	s := newTestS3(t, true)
+	rawURL := s.URL(CoverKey("1.jpg"))
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/green-bean-miners/cover/1.jpg" {
+		t.Fatalf("unexpected path %s", u.Path)
+	}
+	query := u.Query()
+	if query.Get("X-Amz-Signature") == "" {
+		t.Fatalf("url is not signed: %s", rawURL)
+	}
+	if query.Get("X-Amz-Expires") != "600" {
+		t.Fatalf("unexpected expire %s", query.Get("X-Amz-Expires"))
+	}
+}
+
+func TestS3PublicReadPolicy(t *testing.T) {
+	var policy struct {
+		Statement []struct {
+			Effect   string
+			Action   []string
+			Resource []string
+		}
+	}
+	if err := json.Unmarshal([]byte(publicReadPolicy("green-bean-miners")), &policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Statement) != 1 {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	statement := policy.Statement[0]
+	if statement.Effect != "Allow" || strings.Join(statement.Action, ",") != "s3:GetObject" ||
+		strings.Join(statement.Resource, ",") != "arn:aws:s3:::green-bean-miners/*" {
+		t.Fatalf("unexpected statement %+v", statement)
+	}
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "path"
+
+// 存储中的目录
+const (
+	VideoDir      = "video"      // 视频
+	CoverDir      = "cover"      // 封面
+	AvatarDir     = "avatar"     // 头像
+	BackgroundDir = "background" // 个人主页背景图
+)
+
+// Storage 定义媒体文件的存储后端，key 为存储内的相对路径，如 video/1.mp4
+type Storage interface {
+	// Put 将本地文件写入存储，成功后本地文件不再需要
+	Put(key string, localPath string) error
+	// Delete 删除存储中的文件，文件不存在时不报错
+	Delete(key string) error
+	// DeletePrefix 删除存储中 prefix 目录下的全部文件，如视频的全部 HLS 分片
+	DeletePrefix(prefix string) error
+	// URL 返回文件的访问地址，未配置访问域名时返回以 / 开头的相对地址
+	URL(key string) string
+}
+
+// VideoKey 返回视频文件在存储中的 key
+func VideoKey(name string) string {
+	return path.Join(VideoDir, name)
+}
+
+// CoverKey 返回封面文件在存储中的 key
+func CoverKey(name string) string {
+	return path.Join(CoverDir, name)
+}
+
+// AvatarKey 返回头像文件在存储中的 key
+func AvatarKey(name string) string {
+	return path.Join(AvatarDir, name)
+}
+
+// BackgroundKey 返回背景图文件在存储中的 key
+func BackgroundKey(name string) string {
+	return path.Join(BackgroundDir, name)
+}
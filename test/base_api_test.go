@@ -2,8 +2,10 @@ package test
 
 import (
 	"fmt"
+	"github.com/stretchr/testify/assert"
 	"math/rand"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 )
@@ -24,6 +26,17 @@ func TestFeed(t *testing.T) {
 	}
 }
 
+func TestHotFeed(t *testing.T) {
+	e := newExpect(t)
+
+	hotResp := e.GET("/douyin/feed/hot/").Expect().Status(http.StatusOK).JSON().Object()
+	hotResp.Value("status_code").Number().Equal(0)
+	hotResp.Value("next_offset").Number().Ge(0)
+	hotResp.ContainsKey("has_more")
+
+	e.GET("/douyin/feed/hot/").WithQuery("offset", -1).Expect().Status(http.StatusBadRequest)
+}
+
 func TestUserAction(t *testing.T) {
 	e := newExpect(t)
 
@@ -63,6 +76,107 @@ func TestUserAction(t *testing.T) {
 	userInfo.Value("name").String().Length().Gt(0)
 }
 
+func TestRefreshAndLogout(t *testing.T) {
+	e := newExpect(t)
+
+	loginResp := e.POST("/douyin/user/login/").
+		WithQuery("username", testUserB).WithQuery("password", testUserB).
+		WithFormField("username", testUserB).WithFormField("password", testUserB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	if loginResp.Value("status_code").Number().Raw() != 0 {
+		getTestUserToken(testUserB, e)
+		loginResp = e.POST("/douyin/user/login/").
+			WithQuery("username", testUserB).WithQuery("password", testUserB).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object()
+	}
+	refreshToken := loginResp.Value("refresh_token").String().NotEmpty().Raw()
+
+	refreshResp := e.POST("/douyin/user/refresh/").
+		WithFormField("refresh_token", refreshToken).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	refreshResp.Value("status_code").Number().Equal(0)
+	token := refreshResp.Value("token").String().NotEmpty().Raw()
+	newRefreshToken := refreshResp.Value("refresh_token").String().NotEmpty().Raw()
+
+	// 旧的 refresh token 只能使用一次
+	e.POST("/douyin/user/refresh/").
+		WithFormField("refresh_token", refreshToken).
+		Expect().
+		Status(http.StatusForbidden)
+
+	e.POST("/douyin/user/logout/").
+		WithQuery("token", token).WithFormField("refresh_token", newRefreshToken).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	// 退出后 token 失效
+	e.GET("/douyin/user/").
+		WithQuery("token", token).
+		Expect().
+		Status(http.StatusForbidden)
+	e.POST("/douyin/user/refresh/").
+		WithFormField("refresh_token", newRefreshToken).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func TestUserProfile(t *testing.T) {
+	e := newExpect(t)
+
+	userId, token := getTestUserToken(testUserA, e)
+
+	profileResp := e.POST("/douyin/user/profile/").
+		WithMultipart().
+		WithFile("avatar", "../public/cover/40393600231573761.png").
+		WithFormField("signature", "Hello Douyin").
+		WithFormField("token", token).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	profileResp.Value("status_code").Number().Equal(0)
+	profileResp.Value("user").Object().Value("avatar").String().NotEmpty()
+
+	userResp := e.GET("/douyin/user/").
+		WithQuery("token", token).WithQuery("user_id", userId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	userResp.Value("status_code").Number().Equal(0)
+	user := userResp.Value("user").Object()
+	user.Value("signature").String().Equal("Hello Douyin")
+	user.Value("avatar").String().NotEmpty()
+	user.ContainsKey("background_image")
+}
+
+func TestUserInfoConcurrent(t *testing.T) {
+	e := newExpect(t)
+
+	userId, token := getTestUserToken(testUserA, e)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userResp := e.GET("/douyin/user/").
+				WithQuery("token", token).WithQuery("user_id", userId).
+				Expect().
+				Status(http.StatusOK).
+				JSON().Object()
+			userResp.Value("status_code").Number().Equal(0)
+			userResp.Value("user").Object().Value("id").Number().Equal(userId)
+		}()
+	}
+	wg.Wait()
+}
+
 func TestPublish(t *testing.T) {
 	e := newExpect(t)
 
@@ -94,3 +208,176 @@ func TestPublish(t *testing.T) {
 		video.Value("cover_url").String().NotEmpty()
 	}
 }
+
+func TestPublishUpdateAndDelete(t *testing.T) {
+	e := newExpect(t)
+
+	userId, token := getTestUserToken(testUserA, e)
+	_, tokenB := getTestUserToken(testUserB, e)
+
+	publishListResp := e.GET("/douyin/publish/list/").
+		WithQuery("user_id", userId).WithQuery("token", token).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	publishListResp.Value("status_code").Number().Equal(0)
+	videoList := publishListResp.Value("video_list").Array()
+	videoList.Length().Gt(0)
+	videoId := int(videoList.First().Object().Value("id").Number().Raw())
+
+	e.POST("/douyin/publish/update/").
+		WithQuery("token", token).WithQuery("video_id", videoId).WithQuery("title", "Bear").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	// 只有作者可以修改和删除
+	e.POST("/douyin/publish/update/").
+		WithQuery("token", tokenB).WithQuery("video_id", videoId).WithQuery("title", "Not Mine").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(1)
+	e.POST("/douyin/publish/delete/").
+		WithQuery("token", tokenB).WithQuery("video_id", videoId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(1)
+
+	// 发布一个新视频，转码完成后删除，删除后不再出现在发布列表和视频流中
+	title := fmt.Sprintf("DeleteMe%d", rand.Int())
+	publishTestVideo(e, token, title)
+	publishedVideoList := func() []interface{} {
+		return getVideoList(e, "/douyin/publish/list/", map[string]interface{}{"user_id": userId, "token": token})
+	}
+	newVideoId := 0
+	assert.True(t, waitUntil(time.Minute, func() bool {
+		newVideoId = findVideoByTitle(publishedVideoList(), title)
+		return newVideoId != 0
+	}), "Published video is not transcoded")
+
+	e.POST("/douyin/publish/delete/").
+		WithQuery("token", token).WithQuery("video_id", newVideoId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	assert.Equal(t, 0, findVideoByTitle(publishedVideoList(), title), "Deleted video is still in publish list")
+	assert.Equal(t, 0, findVideoByTitle(getVideoList(e, "/douyin/feed/", nil), title), "Deleted video is still in feed")
+}
+
+func TestSearch(t *testing.T) {
+	e := newExpect(t)
+
+	userId, token := getTestUserToken(testUserA, e)
+
+	userResp := e.GET("/douyin/search/user/").
+		WithQuery("keyword", testUserA).WithQuery("token", token).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	userResp.Value("status_code").Number().Equal(0)
+	userList := userResp.Value("user_list").Array()
+	userList.Length().Gt(0)
+	userList.First().Object().Value("id").Number().Equal(userId)
+
+	videoResp := e.GET("/douyin/search/video/").
+		WithQuery("keyword", "Bear").WithQuery("limit", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	videoResp.Value("status_code").Number().Equal(0)
+	videoResp.Value("video_list").Array().Length().Le(1)
+
+	// 发布标题唯一的视频，转码完成后能按标题搜索到
+	title := fmt.Sprintf("SearchMe%d", rand.Int())
+	publishTestVideo(e, token, title)
+	assert.True(t, waitUntil(time.Minute, func() bool {
+		videoList := getVideoList(e, "/douyin/search/video/", map[string]interface{}{"keyword": title, "limit": 1})
+		return findVideoByTitle(videoList, title) != 0
+	}), "Can't find published video by title")
+
+	e.GET("/douyin/search/video/").
+		Expect().
+		Status(http.StatusBadRequest).
+		JSON().Object().Value("status_code").Number().Equal(1)
+}
+
+func TestTag(t *testing.T) {
+	e := newExpect(t)
+
+	_, token := getTestUserToken(testUserA, e)
+
+	trendingResp := e.GET("/douyin/tag/trending/").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	trendingResp.Value("status_code").Number().Equal(0)
+	trendingResp.ContainsKey("tag_list")
+
+	for _, sort := range []string{"time", "hot"} {
+		e.GET("/douyin/tag/video/list/").
+			WithQuery("tag", "douyinTestTagNotExist").WithQuery("sort", sort).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().Value("status_code").Number().Equal(1)
+	}
+
+	e.GET("/douyin/tag/video/list/").
+		WithQuery("tag", "bear").WithQuery("sort", "random").
+		Expect().
+		Status(http.StatusBadRequest).
+		JSON().Object().Value("status_code").Number().Equal(1)
+
+	// 发布带新话题的视频，转码完成后出现在话题的两种排序中
+	tag := fmt.Sprintf("douyinTestTag%d", rand.Int())
+	title := "Tagged #" + tag
+	publishTestVideo(e, token, title)
+	tagVideoList := func(sort string) []interface{} {
+		return getVideoList(e, "/douyin/tag/video/list/", map[string]interface{}{"tag": tag, "sort": sort})
+	}
+	assert.True(t, waitUntil(time.Minute, func() bool {
+		return findVideoByTitle(tagVideoList("time"), title) != 0
+	}), "Can't find published video by tag")
+	assert.NotEqual(t, 0, findVideoByTitle(tagVideoList("hot"), title), "Can't find published video by tag sorted by hot")
+}
+
+func TestFollowingFeed(t *testing.T) {
+	e := newExpect(t)
+
+	_, token := getTestUserToken(testUserA, e)
+	userIdB, tokenB := getTestUserToken(testUserB, e)
+
+	feedResp := e.GET("/douyin/feed/").
+		WithQuery("token", token).WithQuery("feed_type", "following").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	feedResp.Value("status_code").Number().Equal(0)
+	feedResp.Value("next_time").Number().Gt(0)
+
+	e.GET("/douyin/feed/").
+		WithQuery("feed_type", "following").
+		Expect().
+		Status(http.StatusForbidden)
+
+	// 关注后，被关注者发布的视频出现在关注流中
+	relationAction := func(actionType int) {
+		e.POST("/douyin/relation/action/").
+			WithQuery("token", token).WithQuery("to_user_id", userIdB).WithQuery("action_type", actionType).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().Value("status_code").Number().Equal(0)
+	}
+	followingFeed := func() []interface{} {
+		return getVideoList(e, "/douyin/feed/", map[string]interface{}{"token": token, "feed_type": "following"})
+	}
+	relationAction(1)
+	title := fmt.Sprintf("FollowMe%d", rand.Int())
+	publishTestVideo(e, tokenB, title)
+	assert.True(t, waitUntil(time.Minute, func() bool {
+		return findVideoByTitle(followingFeed(), title) != 0
+	}), "Can't find followed user's video in following feed")
+
+	// 取消关注后不再出现
+	relationAction(2)
+	assert.Equal(t, 0, findVideoByTitle(followingFeed(), title), "Unfollowed user's video is still in following feed")
+}
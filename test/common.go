@@ -4,6 +4,7 @@ import (
 	"github.com/gavv/httpexpect/v2"
 	"net/http"
 	"testing"
+	"time"
 )
 
 var serverAddr = "http://localhost:8080"
@@ -47,3 +48,52 @@ func getTestUserToken(user string, e *httpexpect.Expect) (int, string) {
 	}
 	return userId, token
 }
+
+// waitUntil 轮询直到 cond 返回 true 或超时，用于检查异步处理的结果
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+// publishTestVideo 上传测试视频，转码完成后才会出现在各个视频列表中
+func publishTestVideo(e *httpexpect.Expect, token string, title string) {
+	e.POST("/douyin/publish/action/").
+		WithMultipart().
+		WithFile("data", "../public/bear.mp4").
+		WithFormField("token", token).
+		WithFormField("title", title).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+}
+
+// getVideoList 请求视频列表接口，返回响应中的视频列表
+func getVideoList(e *httpexpect.Expect, path string, query map[string]interface{}) []interface{} {
+	req := e.GET(path)
+	for key, value := range query {
+		req = req.WithQuery(key, value)
+	}
+	videoList, _ := req.Expect().
+		Status(http.StatusOK).
+		JSON().Object().Raw()["video_list"].([]interface{})
+	return videoList
+}
+
+// findVideoByTitle 返回视频列表中指定标题的视频 ID，不存在时返回 0
+func findVideoByTitle(videoList []interface{}, title string) int {
+	for _, element := range videoList {
+		video := element.(map[string]interface{})
+		if video["title"] == title {
+			return int(video["id"].(float64))
+		}
+	}
+	return 0
+}
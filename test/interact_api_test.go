@@ -91,3 +91,166 @@ func TestComment(t *testing.T) {
 		JSON().Object()
 	delCommentResp.Value("status_code").Number().Equal(0)
 }
+
+func TestCommentMention(t *testing.T) {
+	e := newExpect(t)
+
+	feedResp := e.GET("/douyin/feed/").Expect().Status(http.StatusOK).JSON().Object()
+	feedResp.Value("status_code").Number().Equal(0)
+	feedResp.Value("video_list").Array().Length().Gt(0)
+	firstVideo := feedResp.Value("video_list").Array().First().Object()
+	videoId := firstVideo.Value("id").Number().Raw()
+
+	_, token := getTestUserToken(testUserA, e)
+	userIdB, tokenB := getTestUserToken(testUserB, e)
+
+	addCommentResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 1).
+		WithFormField("comment_text", "测试提及 @"+testUserB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	addCommentResp.Value("status_code").Number().Equal(0)
+	comment := addCommentResp.Value("comment").Object()
+	comment.Value("mentions").Array().First().Object().Value("user_id").Number().Equal(userIdB)
+	commentId := int(comment.Value("id").Number().Raw())
+
+	mentionListResp := e.GET("/douyin/mention/list/").
+		WithQuery("token", tokenB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	mentionListResp.Value("status_code").Number().Equal(0)
+	containTestMention := false
+	for _, element := range mentionListResp.Value("mention_list").Array().Iter() {
+		if int(element.Object().Value("comment_id").Number().Raw()) == commentId {
+			containTestMention = true
+		}
+	}
+	assert.True(t, containTestMention, "Can't find test mention in list")
+
+	delCommentResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 2).WithFormField("comment_id", commentId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	delCommentResp.Value("status_code").Number().Equal(0)
+}
+
+func TestCommentReply(t *testing.T) {
+	e := newExpect(t)
+
+	feedResp := e.GET("/douyin/feed/").Expect().Status(http.StatusOK).JSON().Object()
+	feedResp.Value("status_code").Number().Equal(0)
+	feedResp.Value("video_list").Array().Length().Gt(0)
+	firstVideo := feedResp.Value("video_list").Array().First().Object()
+	videoId := firstVideo.Value("id").Number().Raw()
+
+	_, token := getTestUserToken(testUserA, e)
+
+	addCommentResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 1).WithFormField("comment_text", "测试评论").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	addCommentResp.Value("status_code").Number().Equal(0)
+	commentId := int(addCommentResp.Value("comment").Object().Value("id").Number().Raw())
+
+	addReplyResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 1).
+		WithFormField("comment_text", "测试回复").WithFormField("parent_id", commentId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	addReplyResp.Value("status_code").Number().Equal(0)
+	reply := addReplyResp.Value("comment").Object()
+	reply.Value("parent_id").Number().Equal(commentId)
+	replyId := int(reply.Value("id").Number().Raw())
+
+	replyListResp := e.GET("/douyin/comment/reply/list/").
+		WithQuery("token", token).WithQuery("comment_id", commentId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	replyListResp.Value("status_code").Number().Equal(0)
+	containTestReply := false
+	for _, element := range replyListResp.Value("reply_list").Array().Iter() {
+		if int(element.Object().Value("id").Number().Raw()) == replyId {
+			containTestReply = true
+		}
+	}
+	assert.True(t, containTestReply, "Can't find test reply in list")
+
+	// 评论列表只包含顶层评论
+	commentListResp := e.GET("/douyin/comment/list/").
+		WithQuery("token", token).WithQuery("video_id", videoId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	for _, element := range commentListResp.Value("comment_list").Array().Iter() {
+		assert.NotEqual(t, replyId, int(element.Object().Value("id").Number().Raw()))
+	}
+
+	delCommentResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 2).WithFormField("comment_id", commentId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	delCommentResp.Value("status_code").Number().Equal(0)
+}
+
+func TestCommentLike(t *testing.T) {
+	e := newExpect(t)
+
+	feedResp := e.GET("/douyin/feed/").Expect().Status(http.StatusOK).JSON().Object()
+	feedResp.Value("status_code").Number().Equal(0)
+	feedResp.Value("video_list").Array().Length().Gt(0)
+	firstVideo := feedResp.Value("video_list").Array().First().Object()
+	videoId := firstVideo.Value("id").Number().Raw()
+
+	_, token := getTestUserToken(testUserA, e)
+
+	addCommentResp := e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 1).WithFormField("comment_text", "测试评论").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	addCommentResp.Value("status_code").Number().Equal(0)
+	commentId := int(addCommentResp.Value("comment").Object().Value("id").Number().Raw())
+
+	likeResp := e.POST("/douyin/comment/like/action/").
+		WithFormField("token", token).WithFormField("comment_id", commentId).WithFormField("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	likeResp.Value("status_code").Number().Equal(0)
+
+	commentListResp := e.GET("/douyin/comment/list/").
+		WithQuery("token", token).WithQuery("video_id", videoId).WithQuery("sort_type", "hot").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	commentListResp.Value("status_code").Number().Equal(0)
+	containTestComment := false
+	for _, element := range commentListResp.Value("comment_list").Array().Iter() {
+		comment := element.Object()
+		if int(comment.Value("id").Number().Raw()) == commentId {
+			containTestComment = true
+			comment.Value("like_count").Number().Equal(1)
+			comment.Value("is_liked").Boolean().True()
+		}
+	}
+	assert.True(t, containTestComment, "Can't find test comment in list")
+
+	e.POST("/douyin/comment/like/action/").
+		WithFormField("token", token).WithFormField("comment_id", commentId).WithFormField("action_type", 2).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	e.POST("/douyin/comment/action/").
+		WithFormField("token", token).WithFormField("video_id", videoId).WithFormField("action_type", 2).WithFormField("comment_id", commentId).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+}
@@ -1,96 +1,80 @@
 package test
 
 import (
-	"encoding/json"
 	"fmt"
-	"github.com/RaymondCode/simple-demo/controller"
-	"io"
-	"net"
+	"github.com/Ljkkun/GreenBeanMiners/controller"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
 	"testing"
 	"time"
 )
 
 func TestMessageServer(t *testing.T) {
 	e := newExpect(t)
-	userIdA, _ := getTestUserToken(testUserA, e)
-	userIdB, _ := getTestUserToken(testUserB, e)
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdB, tokenB := getTestUserToken(testUserB, e)
 
-	connA, err := net.Dial("tcp", "127.0.0.1:9090")
+	connA, err := dialMessageServer(tokenA)
 	if err != nil {
-		fmt.Printf("Connect server failed: %v\n", err)
-		return
+		t.Fatalf("Connect server failed: %v", err)
 	}
-	connB, err := net.Dial("tcp", "127.0.0.1:9090")
+	defer connA.Close()
+	connB, err := dialMessageServer(tokenB)
 	if err != nil {
-		fmt.Printf("Connect server failed: %v\n", err)
-		return
+		t.Fatalf("Connect server failed: %v", err)
 	}
+	defer connB.Close()
 
-	createChat(userIdA, connA, userIdB, connB)
-
-	go readMessage(connB)
-	sendMessage(userIdA, userIdB, connA)
-}
-
-func readMessage(conn net.Conn) {
-	defer conn.Close()
-
-	var buf [256]byte
-	for {
-		n, err := conn.Read(buf[:])
-		if n == 0 {
-			if err == io.EOF {
-				break
-			}
-			fmt.Printf("Read message failed: %v\n", err)
-			continue
+	// 内容带随机后缀，与离线消息和其它测试的消息区分
+	contentList := make([]string, 3)
+	for i := range contentList {
+		contentList[i] = fmt.Sprintf("Test Content %d-%d", i, rand.Int())
+		sendEvent := controller.MessageSendEvent{
+			UserId:     int64(userIdA),
+			ToUserId:   int64(userIdB),
+			MsgContent: contentList[i],
 		}
+		if err = connA.WriteJSON(sendEvent); err != nil {
+			t.Fatalf("Send message failed: %v", err)
+		}
+	}
 
-		var event = controller.MessagePushEvent{}
-		_ = json.Unmarshal(buf[:n], &event)
-		fmt.Printf("Read message：%+v\n", event)
+	eventList := readMessages(t, connB, contentList, 10*time.Second)
+	for i, event := range eventList {
+		assert.Equal(t, contentList[i], event.MsgContent)
+		assert.Equal(t, int64(userIdA), event.FromUserId)
+		assert.Equal(t, int64(userIdB), event.ToUserId)
+		assert.NotZero(t, event.MsgId)
+		assert.NotZero(t, event.CreateTime)
 	}
 }
 
-func sendMessage(fromUserId int, toUserId int, fromConn net.Conn) {
-	defer fromConn.Close()
-
-	for i := 0; i < 3; i++ {
-		time.Sleep(time.Second)
-		sendEvent := controller.MessageSendEvent{
-			UserId:     int64(fromUserId),
-			ToUserId:   int64(toUserId),
-			MsgContent: "Test Content",
-		}
-		data, _ := json.Marshal(sendEvent)
-		_, err := fromConn.Write(data)
-		if err != nil {
-			fmt.Printf("Send message failed: %v\n", err)
-			return
-		}
-	}
-	time.Sleep(time.Second)
+func dialMessageServer(token string) (*websocket.Conn, error) {
+	wsAddr := strings.Replace(serverAddr, "http", "ws", 1) + "/douyin/message/ws/?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(wsAddr, nil)
+	return conn, err
 }
 
-func createChat(userIdA int, connA net.Conn, userIdB int, connB net.Conn) {
-	chatEventA := controller.MessageSendEvent{
-		UserId:   int64(userIdA),
-		ToUserId: int64(userIdB),
-	}
-	chatEventB := controller.MessageSendEvent{
-		UserId:   int64(userIdB),
-		ToUserId: int64(userIdA),
+// readMessages 在超时前读取推送，返回内容在 contentList 中的消息，全部收到后返回
+func readMessages(t *testing.T, conn *websocket.Conn, contentList []string, timeout time.Duration) []controller.MessagePushEvent {
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
 	}
-	eventA, _ := json.Marshal(chatEventA)
-	eventB, _ := json.Marshal(chatEventB)
-	_, err := connA.Write(eventA)
-	if err != nil {
-		fmt.Printf("Create chatA failed: %v\n", err)
-		return
+	expectSet := make(map[string]bool, len(contentList))
+	for _, content := range contentList {
+		expectSet[content] = true
 	}
-	_, err = connB.Write(eventB)
-	if err != nil {
-		fmt.Printf("Create chatB failed: %v\n", err)
-		return
+	var eventList []controller.MessagePushEvent
+	for len(eventList) < len(contentList) {
+		var event controller.MessagePushEvent
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("Read message failed after %d of %d messages: %v", len(eventList), len(contentList), err)
+		}
+		if expectSet[event.MsgContent] {
+			eventList = append(eventList, event)
+		}
 	}
+	return eventList
 }
@@ -1,9 +1,12 @@
 package test
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestRelation(t *testing.T) {
@@ -57,6 +60,291 @@ func TestRelation(t *testing.T) {
 	assert.True(t, containTestUserA, "Follower test user failed")
 }
 
+func TestRelationListPagination(t *testing.T) {
+	e := newExpect(t)
+
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdB, _ := getTestUserToken(testUserB, e)
+
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdB).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	// 逐页读取关注列表，直到没有更多
+	containTestUserB := false
+	cursor := ""
+	for page := 0; page < 100; page++ {
+		req := e.GET("/douyin/relation/follow/list/").
+			WithQuery("token", tokenA).WithQuery("user_id", userIdA).WithQuery("limit", 1)
+		if cursor != "" {
+			req = req.WithQuery("cursor", cursor)
+		}
+		followListResp := req.Expect().Status(http.StatusOK).JSON().Object()
+		followListResp.Value("status_code").Number().Equal(0)
+		userList := followListResp.Value("user_list").Array()
+		userList.Length().Le(1)
+		for _, element := range userList.Iter() {
+			if int(element.Object().Value("id").Number().Raw()) == userIdB {
+				containTestUserB = true
+			}
+		}
+		if !followListResp.Value("has_more").Boolean().Raw() {
+			break
+		}
+		cursor = followListResp.Value("next_cursor").String().NotEmpty().Raw()
+	}
+	assert.True(t, containTestUserB, "Follow test user failed")
+
+	e.GET("/douyin/relation/follower/list/").
+		WithQuery("user_id", userIdB).WithQuery("cursor", "invalid!").
+		Expect().
+		Status(http.StatusBadRequest)
+}
+
+func TestFriendList(t *testing.T) {
+	e := newExpect(t)
+
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdB, tokenB := getTestUserToken(testUserB, e)
+
+	// A 与 B 互相关注
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdB).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenB).WithQuery("to_user_id", userIdA).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	e.POST("/douyin/message/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdB).WithQuery("action_type", 1).WithQuery("content", "Hello UserB").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	friendListResp := e.GET("/douyin/relation/friend/list/").
+		WithQuery("token", tokenA).WithQuery("user_id", userIdA).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	friendListResp.Value("status_code").Number().Equal(0)
+
+	containTestUserB := false
+	for _, element := range friendListResp.Value("user_list").Array().Iter() {
+		user := element.Object()
+		if int(user.Value("id").Number().Raw()) == userIdB {
+			containTestUserB = true
+			user.Value("is_friend").Boolean().True()
+			user.Value("message").String().Equal("Hello UserB")
+			user.Value("msgType").Number().Equal(1)
+		}
+	}
+	assert.True(t, containTestUserB, "Friend list does not contain test user B")
+
+	// 不能查看他人的好友列表
+	e.GET("/douyin/relation/friend/list/").
+		WithQuery("token", tokenA).WithQuery("user_id", userIdB).
+		Expect().
+		Status(http.StatusForbidden)
+}
+
+func TestBlock(t *testing.T) {
+	e := newExpect(t)
+
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdC, tokenC := getTestUserToken("douyinTestUserC", e)
+
+	// 拉黑时解除关注关系
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdC).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	e.POST("/douyin/relation/block/action/").
+		WithQuery("token", tokenC).WithQuery("to_user_id", userIdA).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	followerListResp := e.GET("/douyin/relation/follower/list/").
+		WithQuery("user_id", userIdC).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	followerListResp.Value("status_code").Number().Equal(0)
+	// 没有粉丝时 user_list 为 null
+	followerList, _ := followerListResp.Raw()["user_list"].([]interface{})
+	for _, element := range followerList {
+		assert.NotEqual(t, float64(userIdA), element.(map[string]interface{})["id"], "Block did not remove follow")
+	}
+
+	// 被拉黑后不能关注和私信
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdC).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusForbidden)
+	e.POST("/douyin/message/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdC).WithQuery("action_type", 1).WithQuery("content", "Hello UserC").
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(1)
+
+	blockListResp := e.GET("/douyin/relation/block/list/").
+		WithQuery("token", tokenC).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	blockListResp.Value("status_code").Number().Equal(0)
+	containTestUserA := false
+	for _, element := range blockListResp.Value("user_list").Array().Iter() {
+		if int(element.Object().Value("id").Number().Raw()) == userIdA {
+			containTestUserA = true
+		}
+	}
+	assert.True(t, containTestUserA, "Block test user failed")
+
+	// 取消拉黑后恢复正常
+	e.POST("/douyin/relation/block/action/").
+		WithQuery("token", tokenC).WithQuery("to_user_id", userIdA).WithQuery("action_type", 2).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdC).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+}
+
+func TestPrivateAccount(t *testing.T) {
+	e := newExpect(t)
+
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdD, tokenD := getTestUserToken("douyinTestUserD", e)
+
+	e.POST("/douyin/user/privacy/").
+		WithQuery("token", tokenD).WithQuery("is_private", true).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdD).WithQuery("action_type", 2).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	// 关注私密账号时创建关注申请，同意前不能查看其作品
+	e.POST("/douyin/relation/action/").
+		WithQuery("token", tokenA).WithQuery("to_user_id", userIdD).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_msg").String().Equal("follow request pending")
+	e.GET("/douyin/publish/list/").
+		WithQuery("token", tokenA).WithQuery("user_id", userIdD).
+		Expect().
+		Status(http.StatusForbidden)
+
+	requestListResp := e.GET("/douyin/relation/request/list/").
+		WithQuery("token", tokenD).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	requestListResp.Value("status_code").Number().Equal(0)
+	containTestUserA := false
+	for _, element := range requestListResp.Value("user_list").Array().Iter() {
+		if int(element.Object().Value("id").Number().Raw()) == userIdA {
+			containTestUserA = true
+		}
+	}
+	assert.True(t, containTestUserA, "Follow request not found")
+
+	e.POST("/douyin/relation/request/action/").
+		WithQuery("token", tokenD).WithQuery("to_user_id", userIdA).WithQuery("action_type", 1).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+	e.GET("/douyin/publish/list/").
+		WithQuery("token", tokenA).WithQuery("user_id", userIdD).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	e.POST("/douyin/user/privacy/").
+		WithQuery("token", tokenD).WithQuery("is_private", false).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+}
+
+func TestNotification(t *testing.T) {
+	e := newExpect(t)
+
+	userIdA, tokenA := getTestUserToken(testUserA, e)
+	userIdB, tokenB := getTestUserToken(testUserB, e)
+
+	// 清空未读通知，之后的关注通知只聚合 A 触发的事件
+	e.POST("/douyin/notification/read/").
+		WithQuery("token", tokenB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	// 两次重新关注，触发两次关注通知
+	for _, actionType := range []int{2, 1, 2, 1} {
+		e.POST("/douyin/relation/action/").
+			WithFormField("token", tokenA).WithFormField("to_user_id", userIdB).WithFormField("action_type", actionType).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().Value("status_code").Number().Equal(0)
+	}
+	// 通知由事件消费者异步写入，轮询直到出现未读通知
+	hasUnread := waitUntil(10*time.Second, func() bool {
+		unreadResp := e.GET("/douyin/notification/unread/").
+			WithQuery("token", tokenB).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object()
+		unreadResp.Value("status_code").Number().Equal(0)
+		return unreadResp.Value("unread_count").Number().Raw() > 0
+	})
+	assert.True(t, hasUnread, "Can't find unread notification")
+
+	notificationListResp := e.GET("/douyin/notification/list/").
+		WithQuery("token", tokenB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object()
+	notificationListResp.Value("status_code").Number().Equal(0)
+	containFollowNotification := false
+	for _, element := range notificationListResp.Value("notification_list").Array().Iter() {
+		notification := element.Object()
+		if int(notification.Value("type").Number().Raw()) == 4 &&
+			int(notification.Value("from_user").Object().Value("id").Number().Raw()) == userIdA &&
+			!notification.Value("is_read").Boolean().Raw() {
+			containFollowNotification = true
+			// 同一用户多次触发只计数一次
+			notification.Value("actor_count").Number().Equal(1)
+		}
+	}
+	assert.True(t, containFollowNotification, "Can't find follow notification in list")
+
+	e.POST("/douyin/notification/read/").
+		WithQuery("token", tokenB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("status_code").Number().Equal(0)
+
+	e.GET("/douyin/notification/unread/").
+		WithQuery("token", tokenB).
+		Expect().
+		Status(http.StatusOK).
+		JSON().Object().Value("unread_count").Number().Equal(0)
+}
+
 func TestChat(t *testing.T) {
 	e := newExpect(t)
 
@@ -88,4 +376,34 @@ func TestChat(t *testing.T) {
 		JSON().Object()
 	chatResp.Value("status_code").Number().Equal(0)
 	chatResp.Value("message_list").Array().Length().Gt(0)
+
+	// 从本页最后一条消息之后继续拉取，不再返回该消息；消息 ID 超出 float64 精度，按 json.Number 解析
+	type chatMessage struct {
+		Id         json.Number `json:"id"`
+		CreateTime int64       `json:"create_time"`
+	}
+	getChat := func(query map[string]interface{}) []chatMessage {
+		req := e.GET("/douyin/message/chat/").WithQuery("token", tokenB).WithQuery("to_user_id", userIdA)
+		for key, value := range query {
+			req = req.WithQuery(key, value)
+		}
+		var resp struct {
+			StatusCode  int           `json:"status_code"`
+			MessageList []chatMessage `json:"message_list"`
+		}
+		decoder := json.NewDecoder(strings.NewReader(req.Expect().Status(http.StatusOK).Body().Raw()))
+		decoder.UseNumber()
+		assert.NoError(t, decoder.Decode(&resp))
+		assert.Equal(t, 0, resp.StatusCode)
+		return resp.MessageList
+	}
+	messageList := getChat(nil)
+	if !assert.NotEmpty(t, messageList) {
+		return
+	}
+	last := messageList[len(messageList)-1]
+	for _, message := range getChat(map[string]interface{}{"pre_msg_time": last.CreateTime, "pre_msg_id": last.Id.String()}) {
+		assert.NotEqual(t, last.Id, message.Id, "Message before pre_msg_id is returned again")
+		assert.GreaterOrEqual(t, message.CreateTime, last.CreateTime)
+	}
 }
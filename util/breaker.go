@@ -0,0 +1,88 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// 熔断器状态
+const (
+	BreakerClosed   = iota // 正常放行
+	BreakerOpen            // 熔断，拒绝全部请求
+	BreakerHalfOpen        // 半开，只放行探测请求
+)
+
+// CircuitBreaker 熔断器，连续失败达到阈值后熔断；熔断 timeout 后放行一个探测请求，成功则恢复，失败则继续熔断
+type CircuitBreaker struct {
+	mu            sync.Mutex
+	state         int
+	failures      int
+	threshold     int
+	timeout       time.Duration
+	changedAt     time.Time
+	onStateChange func(from, to int)
+}
+
+// NewCircuitBreaker 创建熔断器，onStateChange 在状态变化后异步调用，可以为 nil
+func NewCircuitBreaker(threshold int, timeout time.Duration, onStateChange func(from, to int)) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, timeout: timeout, onStateChange: onStateChange}
+}
+
+// Allow 判断是否放行本次请求
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(b.changedAt) < b.timeout {
+			return false
+		}
+		b.setState(BreakerHalfOpen)
+		return true
+	default:
+		// 探测请求未返回前拒绝其它请求，探测请求超过 timeout 未返回时重新探测
+		if time.Since(b.changedAt) < b.timeout {
+			return false
+		}
+		b.changedAt = time.Now()
+		return true
+	}
+}
+
+// Success 记录一次成功，半开状态下恢复正常
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	if b.state == BreakerHalfOpen {
+		b.setState(BreakerClosed)
+	}
+}
+
+// Failure 记录一次失败，连续失败达到阈值或探测失败时熔断
+func (b *CircuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.setState(BreakerOpen)
+	}
+}
+
+// State 返回当前状态
+func (b *CircuitBreaker) State() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+func (b *CircuitBreaker) setState(state int) {
+	from := b.state
+	b.state = state
+	b.changedAt = time.Now()
+	if b.onStateChange != nil {
+		go b.onStateChange(from, state)
+	}
+}
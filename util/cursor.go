@@ -0,0 +1,35 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// Cursor 分页游标，列表按 (Score, ID) 倒序排列，游标指向上一页的最后一条
+type Cursor struct {
+	Score float64 `json:"s"`
+	ID    uint64  `json:"i"`
+}
+
+// EncodeCursor 将游标编码为对客户端不透明的字符串
+func EncodeCursor(cursor *Cursor) string {
+	if cursor == nil {
+		return ""
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor 解析客户端传入的游标
+func DecodeCursor(cursorString string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursorString)
+	if err != nil {
+		return nil, errors.New("cursor is invalid")
+	}
+	var cursor Cursor
+	if err = json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("cursor is invalid")
+	}
+	return &cursor, nil
+}
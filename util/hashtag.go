@@ -0,0 +1,28 @@
+package util
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// hashtagPattern 话题以 # 开头，由字母、数字和下划线组成，支持中文
+var hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+// ParseHashtags 解析文本中的话题，统一转为小写并去重，按出现顺序最多返回 maxNum 个，超过 maxLength 的话题被忽略
+func ParseHashtags(text string, maxNum int, maxLength int) []string {
+	var tagList []string
+	tagSet := make(map[string]bool)
+	for _, match := range hashtagPattern.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if utf8.RuneCountInString(tag) > maxLength || tagSet[tag] {
+			continue
+		}
+		tagSet[tag] = true
+		tagList = append(tagList, tag)
+		if len(tagList) >= maxNum {
+			break
+		}
+	}
+	return tagList
+}
@@ -0,0 +1,16 @@
+package util
+
+import (
+	"github.com/disintegration/imaging"
+)
+
+// ResizeImage 将图片按中心裁剪并缩放为 width*height，保存为 dstPath，格式由 dstPath 的后缀决定
+func ResizeImage(srcPath, dstPath string, width, height int) error {
+	// 按 EXIF 信息校正手机拍摄图片的方向
+	img, err := imaging.Open(srcPath, imaging.AutoOrientation(true))
+	if err != nil {
+		return err
+	}
+	img = imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	return imaging.Save(img, dstPath, imaging.JPEGQuality(90))
+}
@@ -5,27 +5,68 @@ import (
 	"github.com/Ljkkun/GreenBeanMiners/global"
 	"github.com/Ljkkun/GreenBeanMiners/model"
 	"github.com/golang-jwt/jwt/v4"
+	"strconv"
 	"time"
 )
 
+// token 类型
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+)
+
+// 未配置时的默认有效期
+const (
+	defaultAccessExpire  = 2 * time.Hour
+	defaultRefreshExpire = 7 * 24 * time.Hour
+)
+
 type UserClaims struct {
-	UserID uint64
-	Name   string
+	UserID  uint64
+	Name    string
+	Type    string // token 类型，access 或 refresh
+	Version int64  // 签发时用户的 token 版本，退出所有设备后旧版本失效
 	jwt.RegisteredClaims
 }
 
-// GenerateToken 生成 token
-func GenerateToken(user *model.User) (string, error) {
+// GenerateToken 生成访问用的 access token
+func GenerateToken(user *model.User, version int64) (string, error) {
+	expire := global.CONFIG.JWTConfig.AccessExpire
+	if expire <= 0 {
+		expire = defaultAccessExpire
+	}
+	return generateToken(user, AccessTokenType, version, expire)
+}
+
+// GenerateRefreshToken 生成用于换取新 token 的 refresh token
+func GenerateRefreshToken(user *model.User, version int64) (string, error) {
+	expire := global.CONFIG.JWTConfig.RefreshExpire
+	if expire <= 0 {
+		expire = defaultRefreshExpire
+	}
+	return generateToken(user, RefreshTokenType, version, expire)
+}
+
+func generateToken(user *model.User, tokenType string, version int64, expire time.Duration) (string, error) {
 	// 获取全局签名
 	mySigningKey := []byte(global.CONFIG.JWTConfig.SigningKey)
+	// 生成 token ID，用于单个 token 的吊销
+	tokenID, err := global.ID_GENERATOR.NextID()
+	if err != nil {
+		return "", err
+	}
 	// 配置 userClaims ,并生成 token
+	now := time.Now()
 	claims := UserClaims{
 		user.UserID,
 		user.Name,
+		tokenType,
+		version,
 		jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
+			ID:        strconv.FormatUint(tokenID, 10),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expire)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -38,6 +79,9 @@ func ParseToken(tokenString string) (*UserClaims, error) {
 	mySigningKey := []byte(global.CONFIG.JWTConfig.SigningKey)
 	// 解析 token 信息
 	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
 		return mySigningKey, nil
 	})
 	if err != nil {
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"regexp"
+)
+
+// usernameChars 用户名允许的字符：字母、数字和下划线，支持中文；注册时按此校验，保证所有用户都能被提及
+const usernameChars = `[\p{L}\p{N}_]`
+
+var (
+	usernamePattern = regexp.MustCompile(`^` + usernameChars + `+$`)
+	mentionPattern  = regexp.MustCompile(`@(` + usernameChars + `+)`) // 提及以 @ 开头，后接用户名
+)
+
+// IsValidUsername 判断用户名是否只由允许的字符组成，长度由调用方校验
+func IsValidUsername(name string) bool {
+	return usernamePattern.MatchString(name)
+}
+
+// ParseMentions 解析文本中提及的用户名并去重，按出现顺序最多返回 maxNum 个
+func ParseMentions(text string, maxNum int) []string {
+	var nameList []string
+	nameSet := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(text, -1) {
+		if nameSet[match[1]] {
+			continue
+		}
+		nameSet[match[1]] = true
+		nameList = append(nameList, match[1])
+		if len(nameList) >= maxNum {
+			break
+		}
+	}
+	return nameList
+}
@@ -2,11 +2,15 @@ package util
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/Ljkkun/GreenBeanMiners/config"
 	"github.com/disintegration/imaging"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -19,31 +23,106 @@ func GetFrame(videoPath, snapshotPath string, frameNum int) (snapshotName string
 		Run()
 
 	if err != nil {
-		log.Fatal("生成缩略图失败：", err)
+		log.Println("生成缩略图失败：", err)
 		return "", err
 	}
 
 	img, err := imaging.Decode(buf)
 	if err != nil {
-		log.Fatal("生成缩略图失败：", err)
+		log.Println("生成缩略图失败：", err)
 		return "", err
 	}
 
 	err = imaging.Save(img, snapshotPath)
 	if err != nil {
-		log.Fatal("生成缩略图失败：", err)
+		log.Println("生成缩略图失败：", err)
 		return "", err
 	}
 
-	fmt.Println("--snapshotPath--", snapshotPath)
-
-	names := strings.Split(snapshotPath, "\"")
-	fmt.Println("----names----", names)
-
 	// 这里把 snapshotPath 的 string 类型转换成 []string
+	names := strings.Split(snapshotPath, "\"")
 	snapshotName = names[len(names)-1]
-	fmt.Println("----snapshotName----", snapshotName)
-	// ----snapshotName---- ./assets/testImage.png
 
 	return snapshotName, nil
 }
+
+// probeResult ffprobe 输出中需要的字段
+type probeResult struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+}
+
+// GetVideoSize 获取视频的宽高
+func GetVideoSize(videoPath string) (width int, height int, err error) {
+	output, err := ffmpeg.Probe(videoPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	var result probeResult
+	if err = json.Unmarshal([]byte(output), &result); err != nil {
+		return 0, 0, err
+	}
+	for _, stream := range result.Streams {
+		if stream.CodecType == "video" && stream.Width > 0 && stream.Height > 0 {
+			return stream.Width, stream.Height, nil
+		}
+	}
+	return 0, 0, errors.New("no video stream")
+}
+
+// TranscodeHLS 将视频转码为多码率的 H.264/AAC HLS，输出到 outputDir，返回主播放列表的文件名
+// 高于原视频的清晰度会被跳过，但至少保留最低的一种
+func TranscodeHLS(videoPath, outputDir string, segmentTime int, renditions []config.RenditionConfig) (string, error) {
+	if len(renditions) == 0 {
+		return "", errors.New("no rendition configured")
+	}
+	width, height, err := GetVideoSize(videoPath)
+	if err != nil {
+		return "", err
+	}
+	master := bytes.NewBufferString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for i, rendition := range renditions {
+		if i > 0 && rendition.Height > height {
+			continue
+		}
+		renditionDir := filepath.Join(outputDir, rendition.Name)
+		if err = os.MkdirAll(renditionDir, os.ModePerm); err != nil {
+			return "", err
+		}
+		err = ffmpeg.Input(videoPath).
+			Output(filepath.Join(renditionDir, "index.m3u8"), ffmpeg.KwArgs{
+				"vf":                   fmt.Sprintf("scale=-2:%d", rendition.Height),
+				"c:v":                  "libx264",
+				"profile:v":            "main",
+				"preset":               "veryfast",
+				"b:v":                  fmt.Sprintf("%dk", rendition.VideoBitrate),
+				"maxrate":              fmt.Sprintf("%dk", rendition.VideoBitrate*107/100),
+				"bufsize":              fmt.Sprintf("%dk", rendition.VideoBitrate*3/2),
+				"c:a":                  "aac",
+				"b:a":                  fmt.Sprintf("%dk", rendition.AudioBitrate),
+				"ac":                   2,
+				"f":                    "hls",
+				"hls_time":             segmentTime,
+				"hls_playlist_type":    "vod",
+				"hls_segment_filename": filepath.Join(renditionDir, "segment_%03d.ts"),
+			}).
+			OverWriteOutput().
+			Run()
+		if err != nil {
+			return "", err
+		}
+		// 宽度按比例缩放并取偶数，与 scale=-2 保持一致
+		renditionWidth := width * rendition.Height / height / 2 * 2
+		bandwidth := (rendition.VideoBitrate + rendition.AudioBitrate) * 1000
+		master.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+			bandwidth, renditionWidth, rendition.Height, rendition.Name))
+	}
+	masterName := "master.m3u8"
+	if err = os.WriteFile(filepath.Join(outputDir, masterName), master.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return masterName, nil
+}